@@ -3,6 +3,10 @@ package common
 const (
 	OTelSpanEventException = "exception"
 
+	OTelExceptionMessageKey    = "exception.message"
+	OTelExceptionTypeKey       = "exception.type"
+	OTelExceptionStacktraceKey = "exception.stacktrace"
+
 	DefaultParentSpanId = "0000000000000000"
 
 	OTelResourceServiceName = "service.name"
@@ -15,18 +19,61 @@ const (
 	OTelSpanEventsKey    = "events"
 	OTelSpanErrorKey     = "error"
 
-	OTelSpanEventAttrKey          = "attributes"
-	OTelSpanEventExceptionHashKey = "exception_hash"
+	OTelSpanEventNameKey               = "name"
+	OTelSpanEventAttrKey               = "attributes"
+	OTelSpanEventExceptionHashKey      = "exception_hash"
+	OTelSpanEventExceptionGroupHashKey = "exception_group_hash"
 
 	OTelSpanAttrServiceNameKey        = "service.name"
 	OTelResourceAttrNamespaceKey      = "k8s.namespace.name"
 	OTelResourceAttrDeploymentNameKey = "k8s.deployment.name"
+	OTelResourceAttrDeploymentUidKey  = "k8s.deployment.uid"
+	OTelResourceAttrPodNameKey        = "k8s.pod.name"
+	OTelResourceAttrPodUidKey         = "k8s.pod.uid"
+	OTelResourceAttrPodIpKey          = "k8s.pod.ip"
+	OTelResourceAttrNodeNameKey       = "k8s.node.name"
+	OTelResourceAttrReplicaSetNameKey = "k8s.replicaset.name"
+	OTelResourceAttrReplicaSetUidKey  = "k8s.replicaset.uid"
+	OTelResourceAttrStatefulSetKey    = "k8s.statefulset.name"
+	OTelResourceAttrDaemonSetKey      = "k8s.daemonset.name"
+	OTelResourceAttrJobKey            = "k8s.job.name"
+
+	OTelSpanAttrSamplingRuleKey          = "sampling.rule"
+	OTelSpanAttrSamplingProbabilityKey   = "sampling.probability"
+	OTelSpanAttrSamplingAdjustedCountKey = "sampling.adjusted_count"
+	// OTelSpanAttrErrorReasonKey is set on the spans classified as errors, to the type of their most telling error.
+	OTelSpanAttrErrorReasonKey = "error.reason"
 
 	ScenarioWorkloadGenericServiceNameKey = "*"
 	ScenarioWorkloadGenericNamespaceKey   = "*"
 	ScenarioWorkloadGenericDeploymentKey  = "*"
 
 	ServiceListKey = "service_list"
+	// ExceptionGroupKeyPrefix prefixes the keys of exception groups, next to the exceptions keyed by raw hash.
+	ExceptionGroupKeyPrefix = "grp_"
+	// ExceptionOccurrenceKeyPrefix prefixes the occurrence hash of an exception group, followed by its services
+	// set and samples list with the suffixes below.
+	ExceptionOccurrenceKeyPrefix = "occ_"
+	ExceptionServicesKeySuffix   = "_services"
+	ExceptionSamplesKeySuffix    = "_samples"
+	// ExceptionIndexKey is the sorted set of exception group hashes by last seen time. Every service has its own
+	// index, with the service name appended.
+	ExceptionIndexKey = "exception_index"
+	// ServiceKeyPrefix prefixes the keys of the per service metadata hashes.
+	ServiceKeyPrefix = "svc_"
+	// WorkloadActiveBucketKeySuffix is appended to a workload id for the key holding the start of its active
+	// trace bucket. Buckets are keyed by the workload id and their start in unix seconds, joined by _.
+	WorkloadActiveBucketKeySuffix = "_active_bucket"
+
+	SamplingDecisionDBName = "sampling_decisions"
+	ServiceGraphDBName     = "service_graph"
 
 	DefaultSchemaVersion = "1.7.0"
+
+	StorageFormatRaw  = "raw"
+	StorageFormatZk   = "zk"
+	StorageFormatBoth = "both"
+
+	// ZkSpanKeyPrefix marks the trace store and badger keys of zk spans.
+	ZkSpanKeyPrefix = "zk_"
 )
@@ -12,9 +12,19 @@ import (
 const LOG_TAG = "Config"
 
 type ExceptionConfig struct {
-	SyncDuration int `yaml:"syncDuration"`
-	BatchSize    int `yaml:"batchSize"`
-	Ttl          int `yaml:"ttl"`
+	SyncDuration int                        `yaml:"syncDuration"`
+	BatchSize    int                        `yaml:"batchSize"`
+	Ttl          int                        `yaml:"ttl"`
+	Fingerprint  ExceptionFingerprintConfig `yaml:"fingerprint"`
+	// MaxSamples is the number of latest trace and span ids kept per exception group.
+	MaxSamples int `yaml:"maxSamples"`
+}
+
+type ExceptionFingerprintConfig struct {
+	// MaxFrames is the number of top stack frames in the group hash.
+	MaxFrames int `yaml:"maxFrames"`
+	// MessagePatterns are regexes of message tokens replaced in the message template, next to the built-in ones.
+	MessagePatterns []string `yaml:"messagePatterns"`
 }
 
 type ResourceConfig struct {
@@ -27,6 +37,8 @@ type TraceConfig struct {
 	SyncDuration int `yaml:"syncDuration"`
 	BatchSize    int `yaml:"batchSize"`
 	Ttl          int `yaml:"ttl"`
+	// StorageFormat is one of raw, zk or both. Defaults to raw.
+	StorageFormat string `yaml:"storageFormat"`
 }
 
 type WorkloadConfig struct {
@@ -34,15 +46,175 @@ type WorkloadConfig struct {
 	BatchSize            int `yaml:"batchSize"`
 	BucketActiveDuration int `yaml:"bucketActiveDuration"`
 	Ttl                  int `yaml:"ttl"`
+	// Capture limits of every workload, enforced by every receiver pod on its own spans.
+	MaxTracesPerBucketPerPod int                   `yaml:"maxTracesPerBucketPerPod"`
+	TracesPerSecondPerPod    float64               `yaml:"tracesPerSecondPerPod"`
+	Limits                   []WorkloadLimitConfig `yaml:"limits"`
+}
+
+// WorkloadLimitConfig overrides the per pod capture limits of the workloads it matches. Empty or * ids match any.
+type WorkloadLimitConfig struct {
+	Scenario                 string  `yaml:"scenario"`
+	Workload                 string  `yaml:"workload"`
+	MaxTracesPerBucketPerPod int     `yaml:"maxTracesPerBucketPerPod"`
+	TracesPerSecondPerPod    float64 `yaml:"tracesPerSecondPerPod"`
 }
 
 type ServiceListConfig struct {
 	SyncDuration int `yaml:"syncDuration"`
 	BatchSize    int `yaml:"batchSize"`
+	// Ttl in seconds after which a service which sent no spans is removed.
+	Ttl int `yaml:"ttl"`
 }
 
 type ScenarioConfig struct {
-	SyncDuration int `yaml:"syncDuration"`
+	SyncDuration int                 `yaml:"syncDuration"`
+	Files        ScenarioFilesConfig `yaml:"files"`
+}
+
+// ScenarioFilesConfig configures the scenarios loaded from a directory next to the ones in Redis.
+type ScenarioFilesConfig struct {
+	// Dir is the directory of the scenario files. Scenarios are only read from Redis when it is empty.
+	Dir string `yaml:"dir"`
+	// PollDuration is the interval in seconds between the checks for changed files.
+	PollDuration int `yaml:"pollDuration"`
+	// Precedence is file or redis, the source whose scenario is used when both have the same id. Defaults to file.
+	Precedence string `yaml:"precedence"`
+}
+
+type AttributeTransformAction struct {
+	Action  string      `yaml:"action"`
+	Level   string      `yaml:"level"`
+	Key     string      `yaml:"key"`
+	FromKey string      `yaml:"fromKey"`
+	Value   interface{} `yaml:"value"`
+	Pattern string      `yaml:"pattern"`
+}
+
+type ProtocolDetectionRule struct {
+	Name        string   `yaml:"name"`
+	Protocol    string   `yaml:"protocol"`
+	Priority    int      `yaml:"priority"`
+	AttributeId string   `yaml:"attributeId"`
+	Attributes  []string `yaml:"attributes"`
+}
+
+type ProtocolDetectionConfig struct {
+	Rules []ProtocolDetectionRule `yaml:"rules"`
+}
+
+type DnsConfig struct {
+	// Enabled defaults to true, as peer names were always resolved before the option was added.
+	Enabled     *bool `yaml:"enabled"`
+	CacheSize   int   `yaml:"cacheSize"`
+	Ttl         int   `yaml:"ttl"`
+	NegativeTtl int   `yaml:"negativeTtl"`
+	TimeoutMs   int   `yaml:"timeoutMs"`
+}
+
+type K8sMetadataConfig struct {
+	Enabled      bool `yaml:"enabled"`
+	ResyncPeriod int  `yaml:"resyncPeriod"`
+	SyncTimeout  int  `yaml:"syncTimeout"`
+}
+
+type SchemaTranslationConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	TargetVersion string `yaml:"targetVersion"`
+}
+
+type TransformConfig struct {
+	Enabled bool                       `yaml:"enabled"`
+	Actions []AttributeTransformAction `yaml:"actions"`
+	// HashKey is the HMAC key of the hash action.
+	HashKey string `yaml:"hashKey" env:"ZK_TRANSFORM_HASH_KEY"`
+}
+
+type AttributeRedactionRules struct {
+	AllowKeys    []string `yaml:"allowKeys"`
+	DenyKeys     []string `yaml:"denyKeys"`
+	MaskPatterns []string `yaml:"maskPatterns"`
+	HashKeys     []string `yaml:"hashKeys"`
+}
+
+type RedactionConfig struct {
+	Enabled   bool                    `yaml:"enabled"`
+	MaskValue string                  `yaml:"maskValue"`
+	Resource  AttributeRedactionRules `yaml:"resource"`
+	Scope     AttributeRedactionRules `yaml:"scope"`
+	Span      AttributeRedactionRules `yaml:"span"`
+	Event     AttributeRedactionRules `yaml:"event"`
+	// HashKey is the HMAC key of the hashed values, needed by hashKeys.
+	HashKey string `yaml:"hashKey" env:"ZK_REDACTION_HASH_KEY"`
+}
+
+type SpanMetricsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Buckets of the duration histogram in seconds.
+	Buckets []float64 `yaml:"buckets"`
+	// Dimensions are span or resource attribute keys added as labels.
+	Dimensions []string `yaml:"dimensions"`
+	// MaxSeries limits the label combinations. Spans of new combinations above it are recorded as overflow.
+	MaxSeries int  `yaml:"maxSeries"`
+	Exemplars bool `yaml:"exemplars"`
+}
+
+type HeadSamplingRule struct {
+	Service        string   `yaml:"service"`
+	SpanName       string   `yaml:"spanName"`
+	Ratio          *float64 `yaml:"ratio"`
+	SpansPerSecond float64  `yaml:"spansPerSecond"`
+}
+
+type HeadSamplingConfig struct {
+	Enabled bool               `yaml:"enabled"`
+	Rules   []HeadSamplingRule `yaml:"rules"`
+	// MaxBuckets bounds the rate limits kept per service and span name.
+	MaxBuckets int `yaml:"maxBuckets"`
+}
+
+type TailSamplingConfig struct {
+	Enabled            bool    `yaml:"enabled"`
+	DecisionWait       int     `yaml:"decisionWait"`
+	LatencyThresholdMs int     `yaml:"latencyThresholdMs"`
+	SampleRatio        float64 `yaml:"sampleRatio"`
+	MaxTraces          int     `yaml:"maxTraces"`
+	SyncDuration       int     `yaml:"syncDuration"`
+	BatchSize          int     `yaml:"batchSize"`
+	Ttl                int     `yaml:"ttl"`
+}
+
+type ServiceGraphConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Wait is the time in seconds a client or server span waits for its pair on the pod, and then in Redis.
+	Wait     int `yaml:"wait"`
+	MaxItems int `yaml:"maxItems"`
+	// PeerAttributes name the callee of client spans without a server span, checked in order.
+	PeerAttributes []string `yaml:"peerAttributes"`
+	// BucketDuration is the width in seconds of the stored time buckets.
+	BucketDuration int `yaml:"bucketDuration"`
+	SyncDuration   int `yaml:"syncDuration"`
+	BatchSize      int `yaml:"batchSize"`
+	Ttl            int `yaml:"ttl"`
+}
+
+type ExporterConfig struct {
+	Name         string            `yaml:"name"`
+	Endpoint     string            `yaml:"endpoint"`
+	Protocol     string            `yaml:"protocol"`
+	Mode         string            `yaml:"mode"`
+	Headers      map[string]string `yaml:"headers"`
+	Compression  string            `yaml:"compression"`
+	Insecure     bool              `yaml:"insecure"`
+	Timeout      int               `yaml:"timeout"`
+	SyncDuration int               `yaml:"syncDuration"`
+	BatchSize    int               `yaml:"batchSize"`
+	QueueSize    int               `yaml:"queueSize"`
+	// MaxRetries is the number of retries of a failed batch, 0 disables them.
+	MaxRetries    *int `yaml:"maxRetries"`
+	RetryInterval int  `yaml:"retryInterval"`
+	// Workers is the number of batches sent concurrently.
+	Workers int `yaml:"workers"`
 }
 
 type OtlpConfig struct {
@@ -58,6 +230,17 @@ type OtlpConfig struct {
 	Exception         ExceptionConfig           `yaml:"exception"`
 	Resources         ResourceConfig            `yaml:"resources"`
 	Services          ServiceListConfig         `yaml:"services"`
+	Exporters         []ExporterConfig          `yaml:"exporters"`
+	K8sMetadata       K8sMetadataConfig         `yaml:"k8sMetadata"`
+	Dns               DnsConfig                 `yaml:"dns"`
+	ProtocolDetection ProtocolDetectionConfig   `yaml:"protocolDetection"`
+	SchemaTranslation SchemaTranslationConfig   `yaml:"schemaTranslation"`
+	Transform         TransformConfig           `yaml:"transform"`
+	Redaction         RedactionConfig           `yaml:"redaction"`
+	SpanMetrics       SpanMetricsConfig         `yaml:"spanMetrics"`
+	ServiceGraph      ServiceGraphConfig        `yaml:"serviceGraph"`
+	HeadSampling      HeadSamplingConfig        `yaml:"headSampling"`
+	TailSampling      TailSamplingConfig        `yaml:"tailSampling"`
 }
 
 func CreateConfig(configPath string) *OtlpConfig {
@@ -0,0 +1,79 @@
+package exception
+
+import (
+	"fmt"
+	"github.com/zerok-ai/zk-observer/model"
+	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
+	"strconv"
+)
+
+// Status code attributes of http spans, checked in order.
+var httpStatusCodeKeys = []string{"http.response.status_code", "http.status_code"}
+
+const grpcStatusCodeKey = "rpc.grpc.status_code"
+
+// errorReasonPriority orders the error types by how much they tell about the error. The error reason of a
+// span is the type of its most telling error.
+var errorReasonPriority = []model.ErrorType{
+	model.ErrorTypeException,
+	model.ErrorTypeHttpStatus,
+	model.ErrorTypeGrpcStatus,
+	model.ErrorTypeSpanStatus,
+}
+
+// ClassifySpanErrors returns the errors of the span, which are the errors of its exception events followed by
+// the ones of its status, HTTP status code and gRPC status code, and the error reason of the span. The reason
+// is empty when the span has no errors.
+func ClassifySpanErrors(span *tracev1.Span, spanAttributes map[string]interface{}, exceptionErrors []model.SpanErrorInfo) ([]model.SpanErrorInfo, model.ErrorType) {
+	spanErrors := exceptionErrors
+	if span.GetStatus().GetCode() == tracev1.Status_STATUS_CODE_ERROR {
+		spanErrors = append(spanErrors, model.SpanErrorInfo{
+			ErrorType: model.ErrorTypeSpanStatus,
+			Message:   span.GetStatus().GetMessage(),
+		})
+	}
+	for _, key := range httpStatusCodeKeys {
+		statusCode, ok := intAttribute(spanAttributes, key)
+		if !ok {
+			continue
+		}
+		if statusCode >= 500 && statusCode < 600 {
+			spanErrors = append(spanErrors, model.SpanErrorInfo{
+				ErrorType: model.ErrorTypeHttpStatus,
+				Message:   fmt.Sprintf("HTTP status code %d", statusCode),
+			})
+		}
+		break
+	}
+	if statusCode, ok := intAttribute(spanAttributes, grpcStatusCodeKey); ok && statusCode != 0 {
+		spanErrors = append(spanErrors, model.SpanErrorInfo{
+			ErrorType: model.ErrorTypeGrpcStatus,
+			Message:   fmt.Sprintf("gRPC status code %d", statusCode),
+		})
+	}
+
+	for _, errorType := range errorReasonPriority {
+		for _, spanError := range spanErrors {
+			if spanError.ErrorType == errorType {
+				return spanErrors, errorType
+			}
+		}
+	}
+	return spanErrors, ""
+}
+
+// intAttribute reads an integer attribute, which may have been stored as a number or a string.
+func intAttribute(attributes map[string]interface{}, key string) (int64, bool) {
+	switch value := attributes[key].(type) {
+	case int64:
+		return value, true
+	case int:
+		return int64(value), true
+	case float64:
+		return int64(value), true
+	case string:
+		intValue, err := strconv.ParseInt(value, 10, 64)
+		return intValue, err == nil
+	}
+	return 0, false
+}
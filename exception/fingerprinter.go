@@ -0,0 +1,119 @@
+package exception
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/zerok-ai/zk-observer/config"
+	"github.com/zerok-ai/zk-observer/model"
+	logger "github.com/zerok-ai/zk-utils-go/logs"
+	"regexp"
+	"strings"
+)
+
+var fingerprinterLogTag = "ExceptionFingerprinter"
+
+const defaultMaxFrames = 5
+
+type messageToken struct {
+	pattern     *regexp.Regexp
+	placeholder string
+	// accept filters the matches of the pattern when set.
+	accept func(match string) bool
+}
+
+// builtinMessageTokens are replaced in order, so that the longer tokens are replaced before the numbers in them.
+var builtinMessageTokens = []messageToken{
+	{regexp.MustCompile(`\b\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+\-]\d{2}:?\d{2})?\b`), "<ts>", nil},
+	{regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`), "<uuid>", nil},
+	{regexp.MustCompile(`[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}`), "<email>", nil},
+	{regexp.MustCompile(`\b[a-zA-Z][a-zA-Z0-9+.\-]*://[^\s'"]+`), "<url>", nil},
+	{regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}(?::\d+)?\b`), "<ip>", nil},
+	{regexp.MustCompile(`\b0[xX][0-9a-fA-F]+\b`), "<hex>", nil},
+	{regexp.MustCompile(`\b[0-9a-fA-F]{8,}\b`), "<hex>", isHexId},
+	{regexp.MustCompile(`'[^']*'|"[^"]*"`), "<str>", nil},
+	{regexp.MustCompile(`-?\b\d+(?:\.\d+)?\b`), "<num>", nil},
+}
+
+// Fingerprinter computes the group hash of exceptions from their type, message template and top stack frames.
+type Fingerprinter struct {
+	maxFrames     int
+	messageTokens []messageToken
+}
+
+func NewFingerprinter(cfg config.ExceptionFingerprintConfig) (*Fingerprinter, error) {
+	fingerprinter := Fingerprinter{maxFrames: cfg.MaxFrames}
+	if fingerprinter.maxFrames <= 0 {
+		fingerprinter.maxFrames = defaultMaxFrames
+	}
+	for _, pattern := range cfg.MessagePatterns {
+		compiledPattern, err := regexp.Compile(pattern)
+		if err != nil {
+			logger.Error(fingerprinterLogTag, "Invalid message pattern ", pattern, ": ", err)
+			return nil, err
+		}
+		fingerprinter.messageTokens = append(fingerprinter.messageTokens, messageToken{compiledPattern, "<var>", nil})
+	}
+	fingerprinter.messageTokens = append(fingerprinter.messageTokens, builtinMessageTokens...)
+	return &fingerprinter, nil
+}
+
+// Fingerprint sets the group hash of the exception and returns its group. language is the SDK language of the
+// service, the stacktrace format is detected when it is empty.
+func (f *Fingerprinter) Fingerprint(exception *model.ExceptionDetails, language string) *model.ExceptionGroup {
+	language = strings.ToLower(language)
+	if len(language) == 0 {
+		language = DetectLanguage(exception.Stacktrace)
+	}
+
+	group := model.ExceptionGroup{
+		Type:            exception.Type,
+		MessageTemplate: f.MessageTemplate(exception.Message),
+		Language:        language,
+	}
+	frames := ParseFrames(language, exception.Stacktrace)
+	if len(frames) > f.maxFrames {
+		frames = frames[:f.maxFrames]
+	}
+	group.Frames = frames
+
+	var fingerprint strings.Builder
+	fingerprint.WriteString(group.Type)
+	fingerprint.WriteString("\n")
+	fingerprint.WriteString(group.MessageTemplate)
+	for _, frame := range frames {
+		fingerprint.WriteString("\n")
+		fingerprint.WriteString(frame.Module + "|" + frame.Function + "|" + frame.File)
+	}
+	if len(frames) == 0 && len(exception.Stacktrace) > 0 {
+		// Unknown stacktrace formats are grouped by the stacktrace without numbers. Exceptions without a
+		// stacktrace are grouped by their type and message template only.
+		fingerprint.WriteString("\n")
+		fingerprint.WriteString(f.MessageTemplate(exception.Stacktrace))
+	}
+	hash := sha256.Sum256([]byte(fingerprint.String()))
+	group.GroupHash = hex.EncodeToString(hash[:])
+	exception.GroupHash = group.GroupHash
+	return &group
+}
+
+// MessageTemplate replaces the variable tokens of a message, like ids, numbers and quoted values, with placeholders.
+func (f *Fingerprinter) MessageTemplate(message string) string {
+	for _, token := range f.messageTokens {
+		if token.accept == nil {
+			message = token.pattern.ReplaceAllString(message, token.placeholder)
+			continue
+		}
+		message = token.pattern.ReplaceAllStringFunc(message, func(match string) string {
+			if token.accept(match) {
+				return token.placeholder
+			}
+			return match
+		})
+	}
+	return strings.TrimSpace(message)
+}
+
+// isHexId accepts hex strings with both digits and letters, leaving plain numbers and words to the other tokens.
+func isHexId(match string) bool {
+	return strings.ContainsAny(match, "0123456789") && strings.ContainsAny(match, "abcdefABCDEF")
+}
@@ -0,0 +1,151 @@
+package exception
+
+import (
+	"github.com/zerok-ai/zk-observer/config"
+	"github.com/zerok-ai/zk-observer/model"
+	"strings"
+	"testing"
+)
+
+func TestMessageTemplate(t *testing.T) {
+	fingerprinter, err := NewFingerprinter(config.ExceptionFingerprintConfig{MessagePatterns: []string{`tenant-[a-z]+`}})
+	if err != nil {
+		t.Fatalf("NewFingerprinter() error = %v", err)
+	}
+
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{message: "failed at 2024-01-02T03:04:05.123Z", want: "failed at <ts>"},
+		{message: "retry at 2024-01-02 03:04:05+05:30 after 3 attempts", want: "retry at <ts> after <num> attempts"},
+		{message: "order 123e4567-e89b-12d3-a456-426614174000 missing", want: "order <uuid> missing"},
+		{message: "user bob.smith@example.com locked", want: "user <email> locked"},
+		{message: "GET https://api.acme.com/orders/42?page=2 failed", want: "GET <url> failed"},
+		{message: "connect to 10.0.0.5:5432 refused", want: "connect to <ip> refused"},
+		{message: "segfault at 0x7ffee4b2", want: "segfault at <hex>"},
+		{message: "span 4bf92f3577b34da6 of trace 4BF92F3577B34DA6A3CE929D0E0E4736 dropped", want: "span <hex> of trace <hex> dropped"},
+		{message: "facade deadbeef", want: "facade deadbeef"},
+		{message: "order 12345678 not found", want: "order <num> not found"},
+		{message: `key 'user-42' not found in "cache 7"`, want: "key <str> not found in <str>"},
+		{message: "balance -12.50 below 0", want: "balance <num> below <num>"},
+		{message: "http2 stream 7 reset", want: "http2 stream <num> reset"},
+		{message: "tenant-acme over quota 5", want: "<var> over quota <num>"},
+		{message: "  no variable tokens  ", want: "no variable tokens"},
+	}
+	for _, test := range tests {
+		t.Run(test.message, func(t *testing.T) {
+			if got := fingerprinter.MessageTemplate(test.message); got != test.want {
+				t.Errorf("MessageTemplate() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFingerprintGroups(t *testing.T) {
+	fingerprinter, err := NewFingerprinter(config.ExceptionFingerprintConfig{MaxFrames: 2})
+	if err != nil {
+		t.Fatalf("NewFingerprinter() error = %v", err)
+	}
+	stacktrace := func(frames ...string) string {
+		return "java.lang.IllegalStateException: failed\n\tat " + strings.Join(frames, "\n\tat ") + "\n"
+	}
+	javaException := func(exceptionType string, message string, frames ...string) model.ExceptionDetails {
+		return model.ExceptionDetails{Type: exceptionType, Message: message, Stacktrace: stacktrace(frames...)}
+	}
+	find := "com.acme.OrderService.find(OrderService.java:88)"
+	get := "com.acme.OrderController.get(OrderController.java:30)"
+	run := "java.lang.Thread.run(Thread.java:833)"
+
+	tests := []struct {
+		name     string
+		a        model.ExceptionDetails
+		b        model.ExceptionDetails
+		language string
+		wantSame bool
+	}{
+		{
+			name:     "variable message tokens",
+			a:        javaException("IllegalStateException", "order 42 of user 'bob' not found at 2024-01-02T03:04:05Z", find, get),
+			b:        javaException("IllegalStateException", "order 7 of user 'alice' not found at 2024-02-03T04:05:06Z", find, get),
+			language: "java",
+			wantSame: true,
+		},
+		{
+			name:     "ids in the message",
+			a:        javaException("NotFoundException", "trace 4bf92f3577b34da6 from 10.0.0.5:8080", find),
+			b:        javaException("NotFoundException", "trace a3ce929d0e0e4736 from 10.0.0.9:9090", find),
+			language: "java",
+			wantSame: true,
+		},
+		{
+			name:     "line numbers",
+			a:        javaException("IllegalStateException", "failed", find, get),
+			b:        javaException("IllegalStateException", "failed", "com.acme.OrderService.find(OrderService.java:91)", "com.acme.OrderController.get(OrderController.java:31)"),
+			language: "java",
+			wantSame: true,
+		},
+		{
+			name:     "frames below max frames",
+			a:        javaException("IllegalStateException", "failed", find, get, run),
+			b:        javaException("IllegalStateException", "failed", find, get),
+			language: "java",
+			wantSame: true,
+		},
+		{
+			name:     "detected language",
+			a:        javaException("IllegalStateException", "failed", find, get),
+			b:        javaException("IllegalStateException", "failed", find, get),
+			wantSame: true,
+		},
+		{
+			name:     "different types",
+			a:        javaException("IllegalStateException", "failed", find, get),
+			b:        javaException("IllegalArgumentException", "failed", find, get),
+			language: "java",
+		},
+		{
+			name:     "different messages",
+			a:        javaException("IllegalStateException", "order not found", find, get),
+			b:        javaException("IllegalStateException", "order not paid", find, get),
+			language: "java",
+		},
+		{
+			name:     "different top frame",
+			a:        javaException("IllegalStateException", "failed", find, get),
+			b:        javaException("IllegalStateException", "failed", "com.acme.OrderService.save(OrderService.java:88)", get),
+			language: "java",
+		},
+		{
+			name:     "different frame order",
+			a:        javaException("IllegalStateException", "failed", find, get),
+			b:        javaException("IllegalStateException", "failed", get, find),
+			language: "java",
+		},
+		{
+			name:     "unknown stacktrace format",
+			a:        model.ExceptionDetails{Type: "Error", Message: "failed", Stacktrace: "worker 3 crashed in job 17"},
+			b:        model.ExceptionDetails{Type: "Error", Message: "failed", Stacktrace: "worker 5 crashed in job 21"},
+			language: "cobol",
+			wantSame: true,
+		},
+		{
+			name:     "stacktrace missing on one",
+			a:        model.ExceptionDetails{Type: "Error", Message: "failed", Stacktrace: "worker 3 crashed"},
+			b:        model.ExceptionDetails{Type: "Error", Message: "failed"},
+			language: "cobol",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			groupA := fingerprinter.Fingerprint(&test.a, test.language)
+			groupB := fingerprinter.Fingerprint(&test.b, test.language)
+			if groupA.GroupHash != test.a.GroupHash || groupB.GroupHash != test.b.GroupHash {
+				t.Errorf("Fingerprint() did not set the group hash of the exception")
+			}
+			if same := groupA.GroupHash == groupB.GroupHash; same != test.wantSame {
+				t.Errorf("same group = %v, want %v\n%+v\n%+v", same, test.wantSame, groupA, groupB)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package exception
+
+import (
+	"github.com/zerok-ai/zk-observer/model"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Languages as reported in telemetry.sdk.language.
+const (
+	LanguageJava   = "java"
+	LanguageNode   = "nodejs"
+	LanguagePython = "python"
+	LanguageGo     = "go"
+	LanguageDotnet = "dotnet"
+)
+
+var (
+	// The class loader and module prefixes are optional and may be empty, as in `app//`. Hidden classes of
+	// lambdas end in a `/0x` address, which is part of the class name.
+	javaFrame       = regexp.MustCompile(`^\s*at\s+(?:[\w.\-@]*/)*?([\w$.<>]+(?:/0x[0-9a-f]+)?)\.([\w$<>\-]+)\((.*?)\)`)
+	dotnetFrame     = regexp.MustCompile(`^\s*at\s+([\w$.<>\x60\[\], ]+?)\.([\w$<>\x60\[\]]+)\(.*?\)(?:\s+in\s+(.+?):line\s+\d+)?\s*$`)
+	nodeFrame       = regexp.MustCompile(`^\s*at\s+(?:(?:async\s+)?(.+?)\s+\()?(.+?)(?::\d+)?(?::\d+)?\)?$`)
+	pythonFrame     = regexp.MustCompile(`^\s*File "(.+?)", line \d+, in (.+)$`)
+	goFunctionLine  = regexp.MustCompile(`^(\S+)\([^()]*\)$`)
+	goFileLine      = regexp.MustCompile(`^\s+(.+?\.go):\d+`)
+	javaCauseHeader = regexp.MustCompile(`^\s*(Caused by:|Suppressed:)`)
+)
+
+// Generated names which change between builds or runs are reduced to their stable part.
+var (
+	javaLambda        = regexp.MustCompile(`\$\$Lambda(\$\d+)?(/0x[0-9a-f]+)?(@[0-9a-f]+)?`)
+	javaLambdaMethod  = regexp.MustCompile(`lambda\$(\w+?)\$\d+`)
+	javaGeneratedName = regexp.MustCompile(`\$\$(EnhancerBySpringCGLIB|EnhancerByCGLIB|FastClassBySpringCGLIB|FastClassByCGLIB|SpringCGLIB)\$\$[0-9a-f]+(\$\d+)?`)
+	javaAccessor      = regexp.MustCompile(`(GeneratedMethodAccessor|GeneratedConstructorAccessor|GeneratedSerializationConstructorAccessor)\d+`)
+	javaProxy         = regexp.MustCompile(`\$Proxy\d+`)
+	javaAnonymous     = regexp.MustCompile(`\$\d+`)
+	dotnetGenerated   = regexp.MustCompile(`(<[\w]*>[a-zA-Z]__)\d+(_\d+)?`)
+	dotnetDisplay     = regexp.MustCompile(`(DisplayClass)\d+(_\d+)?`)
+	goClosure         = regexp.MustCompile(`\.func\d+(\.\d+)*`)
+	goGenericShape    = regexp.MustCompile(`\[[^\]]*\]`)
+	bundleHash        = regexp.MustCompile(`[.\-][0-9a-f]{6,}(\.(js|mjs|cjs))$`)
+	nodeAnonymous     = regexp.MustCompile(`<anonymous>|Object\.<anonymous>`)
+	nodePromiseIndex  = regexp.MustCompile(`^index \d+$`)
+)
+
+// DetectLanguage guesses the language of a stacktrace when the SDK language is unknown.
+func DetectLanguage(stacktrace string) string {
+	switch {
+	case strings.Contains(stacktrace, "Traceback (most recent call last)") || pythonFrame.MatchString(firstMatchingLine(stacktrace, "File \"")):
+		return LanguagePython
+	case strings.Contains(stacktrace, "goroutine ") || strings.Contains(stacktrace, ".go:"):
+		return LanguageGo
+	case strings.Contains(stacktrace, ":line ") || strings.Contains(stacktrace, ".cs:"):
+		return LanguageDotnet
+	case strings.Contains(stacktrace, ".java:") || strings.Contains(stacktrace, "(Unknown Source)") || strings.Contains(stacktrace, "(Native Method)"):
+		return LanguageJava
+	case strings.Contains(stacktrace, ".js:") || strings.Contains(stacktrace, ".ts:") || strings.Contains(stacktrace, ".mjs:"):
+		return LanguageNode
+	}
+	return ""
+}
+
+func firstMatchingLine(stacktrace string, substring string) string {
+	for _, line := range strings.Split(stacktrace, "\n") {
+		if strings.Contains(line, substring) {
+			return line
+		}
+	}
+	return ""
+}
+
+// ParseFrames returns the frames of the stacktrace, innermost first. Line numbers are dropped and generated
+// names are normalized. For Java and .NET, only the frames of the thrown exception are returned, not of its causes
+// or inner exceptions.
+func ParseFrames(language string, stacktrace string) []model.StackFrame {
+	lines := strings.Split(strings.ReplaceAll(stacktrace, "\r\n", "\n"), "\n")
+	switch language {
+	case LanguageJava:
+		return parseJavaFrames(lines)
+	case LanguageDotnet:
+		return parseDotnetFrames(lines)
+	case LanguageNode:
+		return parseNodeFrames(lines)
+	case LanguagePython:
+		return parsePythonFrames(lines)
+	case LanguageGo:
+		return parseGoFrames(lines)
+	}
+	return nil
+}
+
+func parseJavaFrames(lines []string) []model.StackFrame {
+	var frames []model.StackFrame
+	for _, line := range lines {
+		if javaCauseHeader.MatchString(line) {
+			break
+		}
+		match := javaFrame.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		file, _, _ := strings.Cut(match[3], ":")
+		frames = append(frames, model.StackFrame{
+			Module:   normalizeJavaName(match[1]),
+			Function: normalizeJavaName(match[2]),
+			File:     file,
+		})
+	}
+	return frames
+}
+
+func normalizeJavaName(name string) string {
+	name = javaLambda.ReplaceAllString(name, "$$$$Lambda")
+	name = javaLambdaMethod.ReplaceAllString(name, "lambda$$$1")
+	name = javaGeneratedName.ReplaceAllString(name, "$$$$$1")
+	name = javaAccessor.ReplaceAllString(name, "$1")
+	name = javaProxy.ReplaceAllString(name, "$$Proxy")
+	return javaAnonymous.ReplaceAllString(name, "$$")
+}
+
+func parseDotnetFrames(lines []string) []model.StackFrame {
+	var frames []model.StackFrame
+	for _, line := range lines {
+		// Inner exceptions are printed first, so the frames after the last marker are the ones of the thrown one.
+		if strings.Contains(line, "--- End of inner exception stack trace ---") {
+			frames = nil
+			continue
+		}
+		match := dotnetFrame.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		frames = append(frames, model.StackFrame{
+			Module:   normalizeDotnetName(match[1]),
+			Function: normalizeDotnetName(match[2]),
+			File:     baseName(match[3]),
+		})
+	}
+	return frames
+}
+
+func normalizeDotnetName(name string) string {
+	name = dotnetGenerated.ReplaceAllString(name, "$1")
+	return dotnetDisplay.ReplaceAllString(name, "$1")
+}
+
+func parseNodeFrames(lines []string) []model.StackFrame {
+	var frames []model.StackFrame
+	for _, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), "at ") {
+			continue
+		}
+		match := nodeFrame.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		function := nodeAnonymous.ReplaceAllString(match[1], "<anonymous>")
+		file := match[2]
+		// Promise.all and Promise.any frames have the index of the promise instead of a file.
+		if nodePromiseIndex.MatchString(file) {
+			file = ""
+		}
+		// Bundles and some runtimes add query strings or hashes to file names.
+		file, _, _ = strings.Cut(file, "?")
+		file = bundleHash.ReplaceAllString(file, "$1")
+		if i := strings.LastIndex(file, "node_modules/"); i >= 0 {
+			file = file[i:]
+		} else {
+			file = baseName(file)
+		}
+		frames = append(frames, model.StackFrame{Function: function, File: file})
+	}
+	return frames
+}
+
+func parsePythonFrames(lines []string) []model.StackFrame {
+	var frames []model.StackFrame
+	for _, line := range lines {
+		// Chained exceptions are printed first, so the frames of the last traceback are the ones of the raised one.
+		if strings.HasPrefix(line, "Traceback (most recent call last)") {
+			frames = nil
+			continue
+		}
+		match := pythonFrame.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		file := match[1]
+		if i := strings.LastIndex(file, "site-packages/"); i >= 0 {
+			file = file[i+len("site-packages/"):]
+		} else {
+			file = baseName(file)
+		}
+		frames = append(frames, model.StackFrame{Function: match[2], File: file})
+	}
+	// Python prints the innermost frame last.
+	for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+		frames[i], frames[j] = frames[j], frames[i]
+	}
+	return frames
+}
+
+func parseGoFrames(lines []string) []model.StackFrame {
+	var frames []model.StackFrame
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.HasPrefix(line, "goroutine ") {
+			// Only the first goroutine is the one which failed.
+			if len(frames) > 0 {
+				break
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "created by ") || strings.HasPrefix(line, "panic(") {
+			i++
+			continue
+		}
+		match := goFunctionLine.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		frame := model.StackFrame{}
+		function := goGenericShape.ReplaceAllString(match[1], "")
+		function = goClosure.ReplaceAllString(function, ".func")
+		if slash := strings.LastIndex(function, "/"); slash >= 0 {
+			if dot := strings.Index(function[slash:], "."); dot >= 0 {
+				frame.Module, frame.Function = function[:slash+dot], function[slash+dot+1:]
+			}
+		} else if dot := strings.Index(function, "."); dot >= 0 {
+			frame.Module, frame.Function = function[:dot], function[dot+1:]
+		}
+		if len(frame.Function) == 0 {
+			frame.Function = function
+		}
+		if i+1 < len(lines) {
+			if fileMatch := goFileLine.FindStringSubmatch(lines[i+1]); fileMatch != nil {
+				frame.File = baseName(fileMatch[1])
+				i++
+			}
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+func baseName(file string) string {
+	if len(file) == 0 {
+		return ""
+	}
+	return path.Base(strings.ReplaceAll(file, "\\", "/"))
+}
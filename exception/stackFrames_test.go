@@ -0,0 +1,180 @@
+package exception
+
+import (
+	"github.com/zerok-ai/zk-observer/model"
+	"reflect"
+	"testing"
+)
+
+const javaStacktrace = `java.lang.IllegalStateException: order 42 not found
+	at com.acme.orders.OrderService.lambda$find$3(OrderService.java:88)
+	at com.acme.orders.OrderService$$Lambda$321/0x0000000800c4b840.apply(Unknown Source)
+	at com.acme.orders.OrderService$$Lambda/0x00007f3c8c0a2d18.apply(Unknown Source)
+	at java.base/java.util.Optional.map(Optional.java:260)
+	at app//com.acme.orders.OrderController$$EnhancerBySpringCGLIB$$1a2b3c4d.get(<generated>)
+	at jdk.internal.reflect.GeneratedMethodAccessor12.invoke(Unknown Source)
+	at com.sun.proxy.$Proxy87.find(Unknown Source)
+	at com.acme.orders.OrderController$1.run(OrderController.java:30)
+	at java.base@17.0.8/java.lang.Thread.run(Thread.java:833)
+Caused by: java.sql.SQLException: timeout
+	at com.acme.db.Pool.get(Pool.java:10)
+	... 9 more
+`
+
+const nodeStacktrace = `TypeError: Cannot read properties of undefined (reading 'id')
+    at getUser (/app/src/users.js:12:20)
+    at async Promise.all (index 0)
+    at Object.<anonymous> (/app/dist/main.3f9a1c2b.js:1:900)
+    at Layer.handle [as handle_request] (/app/node_modules/express/lib/router/layer.js:95:5)
+    at /app/src/server.js:40:3
+    at file:///app/src/index.mjs?v=12:5:1
+`
+
+const pythonStacktrace = `Traceback (most recent call last):
+  File "/app/db.py", line 3, in connect
+    raise OSError()
+OSError
+
+During handling of the above exception, another exception occurred:
+
+Traceback (most recent call last):
+  File "/app/main.py", line 10, in <module>
+    handler()
+  File "/usr/lib/python3.11/site-packages/flask/app.py", line 1498, in dispatch
+    return view()
+  File "/app/views.py", line 22, in view
+    raise ValueError("bad")
+ValueError: bad
+`
+
+const goStacktrace = `panic: runtime error: index out of range [3] with length 3
+
+goroutine 42 [running]:
+panic({0x6b2f40, 0xc000012345})
+	/usr/local/go/src/runtime/panic.go:914 +0x21f
+github.com/acme/shop/cart.(*Cart).Item(0xc000010000, 0x3)
+	/src/cart/cart.go:51 +0x1d
+github.com/acme/shop/cart.Handler.func1.2()
+	/src/cart/handler.go:20 +0x45
+github.com/acme/shop/cache.Get[...](0xc000020000)
+	/src/cache/cache.go:14 +0x30
+main.main()
+	/src/main.go:9 +0x25
+
+goroutine 1 [chan receive]:
+main.wait()
+	/src/main.go:30 +0x10
+`
+
+const dotnetStacktrace = `System.InvalidOperationException: Loading orders failed ---> System.InvalidOperationException: Sequence contains no elements
+   at System.Linq.ThrowHelper.ThrowNoElementsException()
+   at Acme.Orders.OrderRepository.<LoadAsync>d__4.MoveNext() in /src/Orders/OrderRepository.cs:line 18
+   --- End of inner exception stack trace ---
+   at Acme.Orders.OrderService.<GetAsync>b__5_0(Order o) in /src/Orders/OrderService.cs:line 41
+   at Acme.Orders.OrderService.<>c__DisplayClass3_0.<Run>b__1() in C:\src\Orders\OrderService.cs:line 60
+   at Acme.Api.Program.Main()
+`
+
+func TestParseFrames(t *testing.T) {
+	tests := []struct {
+		name       string
+		language   string
+		stacktrace string
+		want       []model.StackFrame
+	}{
+		{
+			name:       "java",
+			language:   LanguageJava,
+			stacktrace: javaStacktrace,
+			want: []model.StackFrame{
+				{Module: "com.acme.orders.OrderService", Function: "lambda$find", File: "OrderService.java"},
+				{Module: "com.acme.orders.OrderService$$Lambda", Function: "apply", File: "Unknown Source"},
+				{Module: "com.acme.orders.OrderService$$Lambda", Function: "apply", File: "Unknown Source"},
+				{Module: "java.util.Optional", Function: "map", File: "Optional.java"},
+				{Module: "com.acme.orders.OrderController$$EnhancerBySpringCGLIB", Function: "get", File: "<generated>"},
+				{Module: "jdk.internal.reflect.GeneratedMethodAccessor", Function: "invoke", File: "Unknown Source"},
+				{Module: "com.sun.proxy.$Proxy", Function: "find", File: "Unknown Source"},
+				{Module: "com.acme.orders.OrderController$", Function: "run", File: "OrderController.java"},
+				{Module: "java.lang.Thread", Function: "run", File: "Thread.java"},
+			},
+		},
+		{
+			name:       "node",
+			language:   LanguageNode,
+			stacktrace: nodeStacktrace,
+			want: []model.StackFrame{
+				{Function: "getUser", File: "users.js"},
+				{Function: "Promise.all"},
+				{Function: "<anonymous>", File: "main.js"},
+				{Function: "Layer.handle [as handle_request]", File: "node_modules/express/lib/router/layer.js"},
+				{File: "server.js"},
+				{File: "index.mjs"},
+			},
+		},
+		{
+			name:       "python",
+			language:   LanguagePython,
+			stacktrace: pythonStacktrace,
+			want: []model.StackFrame{
+				{Function: "view", File: "views.py"},
+				{Function: "dispatch", File: "flask/app.py"},
+				{Function: "<module>", File: "main.py"},
+			},
+		},
+		{
+			name:       "go",
+			language:   LanguageGo,
+			stacktrace: goStacktrace,
+			want: []model.StackFrame{
+				{Module: "github.com/acme/shop/cart", Function: "(*Cart).Item", File: "cart.go"},
+				{Module: "github.com/acme/shop/cart", Function: "Handler.func", File: "handler.go"},
+				{Module: "github.com/acme/shop/cache", Function: "Get", File: "cache.go"},
+				{Module: "main", Function: "main", File: "main.go"},
+			},
+		},
+		{
+			name:       "dotnet",
+			language:   LanguageDotnet,
+			stacktrace: dotnetStacktrace,
+			want: []model.StackFrame{
+				{Module: "Acme.Orders.OrderService", Function: "<GetAsync>b__", File: "OrderService.cs"},
+				{Module: "Acme.Orders.OrderService.<>c__DisplayClass", Function: "<Run>b__", File: "OrderService.cs"},
+				{Module: "Acme.Api.Program", Function: "Main"},
+			},
+		},
+		{
+			name:       "unknown language",
+			language:   "erlang",
+			stacktrace: javaStacktrace,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ParseFrames(test.language, test.stacktrace); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ParseFrames() =\n%+v\nwant\n%+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		name       string
+		stacktrace string
+		want       string
+	}{
+		{name: "java", stacktrace: javaStacktrace, want: LanguageJava},
+		{name: "node", stacktrace: nodeStacktrace, want: LanguageNode},
+		{name: "python", stacktrace: pythonStacktrace, want: LanguagePython},
+		{name: "go", stacktrace: goStacktrace, want: LanguageGo},
+		{name: "dotnet", stacktrace: dotnetStacktrace, want: LanguageDotnet},
+		{name: "no frames", stacktrace: "something failed", want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := DetectLanguage(test.stacktrace); got != test.want {
+				t.Errorf("DetectLanguage() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
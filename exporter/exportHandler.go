@@ -0,0 +1,127 @@
+package exporter
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/zerok-ai/zk-observer/config"
+	"github.com/zerok-ai/zk-observer/processor"
+	logger "github.com/zerok-ai/zk-utils-go/logs"
+	commonv1 "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcev1 "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+var exportHandlerLogTag = "ExportHandler"
+
+// ExportHandler fans out received spans to the configured downstream OTLP exporters. The exported copies are
+// redacted with the same rules as the stored spans.
+type ExportHandler struct {
+	allExporters      []*OtlpExporter
+	filteredExporters []*OtlpExporter
+	redactor          *processor.RedactionProcessor
+}
+
+func NewExportHandler(otlpConfig *config.OtlpConfig, redactor *processor.RedactionProcessor) (*ExportHandler, error) {
+	handler := ExportHandler{redactor: redactor}
+	for _, exporterConfig := range otlpConfig.Exporters {
+		exporter, err := NewOtlpExporter(exporterConfig)
+		if err != nil {
+			logger.Error(exportHandlerLogTag, "Error while creating exporter ", exporterConfig.Name, " error: ", err)
+			return nil, err
+		}
+		switch exporter.Mode() {
+		case ModeAll:
+			handler.allExporters = append(handler.allExporters, exporter)
+		case ModeFiltered:
+			handler.filteredExporters = append(handler.filteredExporters, exporter)
+		default:
+			logger.Error(exportHandlerLogTag, "Unknown mode ", exporter.Mode(), " for exporter ", exporter.Name(), ", skipping it")
+		}
+	}
+	return &handler, nil
+}
+
+// ExportsAll tells if any exporter needs the original resource spans.
+func (h *ExportHandler) ExportsAll() bool {
+	return len(h.allExporters) > 0
+}
+
+// ExportsFiltered tells if any exporter needs only the spans which matched a workload.
+func (h *ExportHandler) ExportsFiltered() bool {
+	return len(h.filteredExporters) > 0
+}
+
+// ExportAll sends a copy of the original resource spans to the exporters in `all` mode. It has to be called
+// before the spans are modified during processing.
+func (h *ExportHandler) ExportAll(resourceSpans []*tracev1.ResourceSpans) {
+	if !h.ExportsAll() || len(resourceSpans) == 0 {
+		return
+	}
+	clonedResourceSpans := make([]*tracev1.ResourceSpans, 0, len(resourceSpans))
+	for _, resourceSpan := range resourceSpans {
+		clonedResourceSpans = append(clonedResourceSpans, proto.Clone(resourceSpan).(*tracev1.ResourceSpans))
+	}
+	h.redactor.RedactResourceSpans(clonedResourceSpans)
+	for _, exporter := range h.allExporters {
+		exporter.Enqueue(clonedResourceSpans)
+	}
+}
+
+// ExportFiltered sends the spans collected in the builder to the exporters in `filtered` mode.
+func (h *ExportHandler) ExportFiltered(builder *FilteredSpansBuilder) {
+	if !h.ExportsFiltered() || builder == nil || builder.IsEmpty() {
+		return
+	}
+	filteredResourceSpans := builder.Build()
+	h.redactor.RedactResourceSpans(filteredResourceSpans)
+	for _, exporter := range h.filteredExporters {
+		exporter.Enqueue(filteredResourceSpans)
+	}
+}
+
+// FilteredSpansBuilder collects individual spans while keeping the resource and scope they were received with.
+type FilteredSpansBuilder struct {
+	resourceSpans []*tracev1.ResourceSpans
+	resourceIndex map[*tracev1.ResourceSpans]*tracev1.ResourceSpans
+	scopeIndex    map[*tracev1.ScopeSpans]*tracev1.ScopeSpans
+}
+
+func NewFilteredSpansBuilder() *FilteredSpansBuilder {
+	return &FilteredSpansBuilder{
+		resourceIndex: map[*tracev1.ResourceSpans]*tracev1.ResourceSpans{},
+		scopeIndex:    map[*tracev1.ScopeSpans]*tracev1.ScopeSpans{},
+	}
+}
+
+// Add stores a copy of the span, and of its resource and scope the first time they are seen, so it has to be
+// called before the span is modified during processing.
+func (b *FilteredSpansBuilder) Add(resourceSpan *tracev1.ResourceSpans, scopeSpans *tracev1.ScopeSpans, span *tracev1.Span) {
+	filteredResourceSpan, ok := b.resourceIndex[resourceSpan]
+	if !ok {
+		filteredResourceSpan = &tracev1.ResourceSpans{SchemaUrl: resourceSpan.SchemaUrl}
+		if resourceSpan.Resource != nil {
+			filteredResourceSpan.Resource = proto.Clone(resourceSpan.Resource).(*resourcev1.Resource)
+		}
+		b.resourceIndex[resourceSpan] = filteredResourceSpan
+		b.resourceSpans = append(b.resourceSpans, filteredResourceSpan)
+	}
+
+	filteredScopeSpans, ok := b.scopeIndex[scopeSpans]
+	if !ok {
+		filteredScopeSpans = &tracev1.ScopeSpans{SchemaUrl: scopeSpans.SchemaUrl}
+		if scopeSpans.Scope != nil {
+			filteredScopeSpans.Scope = proto.Clone(scopeSpans.Scope).(*commonv1.InstrumentationScope)
+		}
+		b.scopeIndex[scopeSpans] = filteredScopeSpans
+		filteredResourceSpan.ScopeSpans = append(filteredResourceSpan.ScopeSpans, filteredScopeSpans)
+	}
+
+	filteredScopeSpans.Spans = append(filteredScopeSpans.Spans, proto.Clone(span).(*tracev1.Span))
+}
+
+func (b *FilteredSpansBuilder) IsEmpty() bool {
+	return len(b.resourceSpans) == 0
+}
+
+func (b *FilteredSpansBuilder) Build() []*tracev1.ResourceSpans {
+	return b.resourceSpans
+}
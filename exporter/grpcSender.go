@@ -0,0 +1,64 @@
+package exporter
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/zerok-ai/zk-observer/config"
+	pb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type grpcSender struct {
+	conn     *grpc.ClientConn
+	client   pb.TraceServiceClient
+	metadata metadata.MD
+	callOpts []grpc.CallOption
+}
+
+func newGrpcSender(cfg config.ExporterConfig) (*grpcSender, error) {
+	transportCreds := credentials.NewTLS(&tls.Config{})
+	if cfg.Insecure {
+		transportCreds = insecure.NewCredentials()
+	}
+
+	conn, err := grpc.Dial(cfg.Endpoint, grpc.WithTransportCredentials(transportCreds))
+	if err != nil {
+		return nil, err
+	}
+
+	var callOpts []grpc.CallOption
+	if cfg.Compression == CompressionGzip {
+		callOpts = append(callOpts, grpc.UseCompressor(gzip.Name))
+	}
+
+	return &grpcSender{
+		conn:     conn,
+		client:   pb.NewTraceServiceClient(conn),
+		metadata: metadata.New(cfg.Headers),
+		callOpts: callOpts,
+	}, nil
+}
+
+func (s *grpcSender) send(ctx context.Context, request *pb.ExportTraceServiceRequest) (bool, error) {
+	ctx = metadata.NewOutgoingContext(ctx, s.metadata)
+	_, err := s.client.Export(ctx, request, s.callOpts...)
+	if err != nil {
+		return isRetryableGrpcCode(status.Code(err)), err
+	}
+	return false, nil
+}
+
+// Ref: https://opentelemetry.io/docs/specs/otlp/#failures
+func isRetryableGrpcCode(code codes.Code) bool {
+	switch code {
+	case codes.Canceled, codes.DeadlineExceeded, codes.Aborted, codes.OutOfRange, codes.Unavailable, codes.DataLoss, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
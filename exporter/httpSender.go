@@ -0,0 +1,90 @@
+package exporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/zerok-ai/zk-observer/config"
+	pb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"io"
+	"net/http"
+)
+
+type httpSender struct {
+	client   *http.Client
+	endpoint string
+	headers  map[string]string
+	gzip     bool
+}
+
+func newHttpSender(cfg config.ExporterConfig) (*httpSender, error) {
+	if len(cfg.Endpoint) == 0 {
+		return nil, fmt.Errorf("endpoint is empty")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.Insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &httpSender{
+		client:   &http.Client{Transport: transport},
+		endpoint: cfg.Endpoint,
+		headers:  cfg.Headers,
+		gzip:     cfg.Compression == CompressionGzip,
+	}, nil
+}
+
+func (s *httpSender) send(ctx context.Context, request *pb.ExportTraceServiceRequest) (bool, error) {
+	body, err := proto.Marshal(request)
+	if err != nil {
+		return false, err
+	}
+
+	if s.gzip {
+		var buf bytes.Buffer
+		gzipWriter := gzip.NewWriter(&buf)
+		if _, err = gzipWriter.Write(body); err != nil {
+			return false, err
+		}
+		if err = gzipWriter.Close(); err != nil {
+			return false, err
+		}
+		body = buf.Bytes()
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for key, value := range s.headers {
+		httpRequest.Header.Set(key, value)
+	}
+	httpRequest.Header.Set("Content-Type", "application/x-protobuf")
+	if s.gzip {
+		httpRequest.Header.Set("Content-Encoding", "gzip")
+	}
+
+	response, err := s.client.Do(httpRequest)
+	if err != nil {
+		// Network errors are always retried.
+		return true, err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+	return isRetryableHttpStatus(response.StatusCode), fmt.Errorf("export failed with status %d", response.StatusCode)
+}
+
+// Ref: https://opentelemetry.io/docs/specs/otlp/#retryable-response-codes
+func isRetryableHttpStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,262 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"github.com/zerok-ai/zk-observer/config"
+	promMetrics "github.com/zerok-ai/zk-observer/metrics"
+	logger "github.com/zerok-ai/zk-utils-go/logs"
+	pb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var otlpExporterLogTag = "OtlpExporter"
+
+const (
+	ProtocolGRPC = "grpc"
+	ProtocolHTTP = "http"
+
+	ModeAll      = "all"
+	ModeFiltered = "filtered"
+
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+
+	defaultTimeout       = 10
+	defaultSyncDuration  = 5
+	defaultBatchSize     = 512
+	defaultQueueSize     = 10000
+	defaultMaxRetries    = 5
+	defaultRetryInterval = 5
+	defaultWorkers       = 2
+	maxBackoffShift      = 6
+)
+
+// sender pushes a single export request to the downstream endpoint. The returned bool tells whether
+// the request can be retried after a failure.
+type sender interface {
+	send(ctx context.Context, request *pb.ExportTraceServiceRequest) (bool, error)
+}
+
+type pendingBatch struct {
+	resourceSpans []*tracev1.ResourceSpans
+	spanCount     int
+	attempts      int
+}
+
+// OtlpExporter batches the queued resource spans on a single goroutine, and sends the batches from a pool of
+// workers, so that a slow endpoint or a retry backoff does not stop the batching.
+type OtlpExporter struct {
+	cfg    config.ExporterConfig
+	sender sender
+	queue  chan *tracev1.ResourceSpans
+	// batches holds the batches waiting for a worker, and is bounded to as many spans as the queue.
+	batches chan *pendingBatch
+	// retrying counts the batches waiting for their backoff, with the same bound.
+	retrying   atomic.Int32
+	maxBatches int
+	batch      *pendingBatch
+}
+
+func NewOtlpExporter(cfg config.ExporterConfig) (*OtlpExporter, error) {
+	cfg = withDefaults(cfg)
+
+	var s sender
+	var err error
+	switch cfg.Protocol {
+	case ProtocolGRPC:
+		s, err = newGrpcSender(cfg)
+	case ProtocolHTTP:
+		s, err = newHttpSender(cfg)
+	default:
+		err = fmt.Errorf("unsupported exporter protocol %s", cfg.Protocol)
+	}
+	if err != nil {
+		logger.Error(otlpExporterLogTag, "Error while creating sender for exporter ", cfg.Name, " error: ", err)
+		return nil, err
+	}
+
+	exporter := newOtlpExporter(cfg, s)
+	exporter.start()
+	return exporter, nil
+}
+
+func newOtlpExporter(cfg config.ExporterConfig, s sender) *OtlpExporter {
+	maxBatches := max(1, cfg.QueueSize/cfg.BatchSize)
+	return &OtlpExporter{
+		cfg:        cfg,
+		sender:     s,
+		queue:      make(chan *tracev1.ResourceSpans, cfg.QueueSize),
+		batches:    make(chan *pendingBatch, maxBatches),
+		maxBatches: maxBatches,
+		batch:      &pendingBatch{},
+	}
+}
+
+// start runs the batching goroutine and the pool of workers.
+func (e *OtlpExporter) start() {
+	for i := 0; i < e.cfg.Workers; i++ {
+		go e.sendBatches()
+	}
+	go e.run()
+}
+
+func withDefaults(cfg config.ExporterConfig) config.ExporterConfig {
+	cfg.Protocol = strings.ToLower(cfg.Protocol)
+	if len(cfg.Protocol) == 0 {
+		cfg.Protocol = ProtocolGRPC
+	}
+	cfg.Mode = strings.ToLower(cfg.Mode)
+	if len(cfg.Mode) == 0 {
+		cfg.Mode = ModeAll
+	}
+	cfg.Compression = strings.ToLower(cfg.Compression)
+	if len(cfg.Compression) == 0 {
+		cfg.Compression = CompressionNone
+	}
+	if len(cfg.Name) == 0 {
+		cfg.Name = cfg.Endpoint
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.SyncDuration <= 0 {
+		cfg.SyncDuration = defaultSyncDuration
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultQueueSize
+	}
+	// Retries are disabled with 0, so only a missing value gets the default.
+	if cfg.MaxRetries == nil {
+		maxRetries := defaultMaxRetries
+		cfg.MaxRetries = &maxRetries
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = defaultRetryInterval
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = defaultWorkers
+	}
+	return cfg
+}
+
+func (e *OtlpExporter) Name() string {
+	return e.cfg.Name
+}
+
+func (e *OtlpExporter) Mode() string {
+	return e.cfg.Mode
+}
+
+// Enqueue adds resource spans to the exporter queue without blocking. Spans are dropped and counted as
+// failed when the queue is full.
+func (e *OtlpExporter) Enqueue(resourceSpans []*tracev1.ResourceSpans) {
+	for _, resourceSpan := range resourceSpans {
+		spanCount := countSpans(resourceSpan)
+		select {
+		case e.queue <- resourceSpan:
+			promMetrics.ExporterSpansQueued.WithLabelValues(e.cfg.Name).Add(float64(spanCount))
+		default:
+			logger.Warn(otlpExporterLogTag, "Queue is full for exporter ", e.cfg.Name, ", dropping ", spanCount, " spans")
+			promMetrics.ExporterSpansFailed.WithLabelValues(e.cfg.Name).Add(float64(spanCount))
+		}
+	}
+}
+
+func (e *OtlpExporter) run() {
+	flushTicker := time.NewTicker(time.Duration(e.cfg.SyncDuration) * time.Second)
+	defer flushTicker.Stop()
+
+	for {
+		select {
+		case resourceSpan := <-e.queue:
+			e.batch.resourceSpans = append(e.batch.resourceSpans, resourceSpan)
+			e.batch.spanCount += countSpans(resourceSpan)
+			if e.batch.spanCount >= e.cfg.BatchSize {
+				e.flush()
+			}
+		case <-flushTicker.C:
+			e.flush()
+		}
+	}
+}
+
+func (e *OtlpExporter) flush() {
+	if len(e.batch.resourceSpans) == 0 {
+		return
+	}
+	batch := e.batch
+	e.batch = &pendingBatch{}
+	e.submit(batch)
+}
+
+// submit hands the batch to the workers without blocking, and drops it when all of them are behind.
+func (e *OtlpExporter) submit(batch *pendingBatch) {
+	select {
+	case e.batches <- batch:
+	default:
+		logger.Warn(otlpExporterLogTag, "Too many pending batches for exporter ", e.cfg.Name, ", dropping ", batch.spanCount, " spans")
+		e.drop(batch)
+	}
+}
+
+func (e *OtlpExporter) sendBatches() {
+	for batch := range e.batches {
+		e.export(batch)
+	}
+}
+
+func (e *OtlpExporter) export(batch *pendingBatch) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(e.cfg.Timeout)*time.Second)
+	defer cancel()
+
+	batch.attempts++
+	request := &pb.ExportTraceServiceRequest{ResourceSpans: batch.resourceSpans}
+	retryable, err := e.sender.send(ctx, request)
+	if err == nil {
+		promMetrics.ExporterSpansQueued.WithLabelValues(e.cfg.Name).Sub(float64(batch.spanCount))
+		promMetrics.ExporterSpansSent.WithLabelValues(e.cfg.Name).Add(float64(batch.spanCount))
+		return
+	}
+
+	if retryable && batch.attempts <= *e.cfg.MaxRetries && e.reserveRetry() {
+		// Exponential backoff on the configured retry interval, without holding the worker.
+		backoff := time.Duration(e.cfg.RetryInterval) * time.Second * time.Duration(1<<min(batch.attempts-1, maxBackoffShift))
+		logger.Warn(otlpExporterLogTag, "Export failed for exporter ", e.cfg.Name, ", retrying in ", backoff, " error: ", err)
+		time.AfterFunc(backoff, func() {
+			e.retrying.Add(-1)
+			e.submit(batch)
+		})
+		return
+	}
+	logger.Error(otlpExporterLogTag, "Export failed for exporter ", e.cfg.Name, ", dropping ", batch.spanCount, " spans after ", batch.attempts, " attempts, error: ", err)
+	e.drop(batch)
+}
+
+// reserveRetry tells if one more batch can wait for its backoff.
+func (e *OtlpExporter) reserveRetry() bool {
+	if int(e.retrying.Add(1)) <= e.maxBatches {
+		return true
+	}
+	e.retrying.Add(-1)
+	return false
+}
+
+func (e *OtlpExporter) drop(batch *pendingBatch) {
+	promMetrics.ExporterSpansQueued.WithLabelValues(e.cfg.Name).Sub(float64(batch.spanCount))
+	promMetrics.ExporterSpansFailed.WithLabelValues(e.cfg.Name).Add(float64(batch.spanCount))
+}
+
+func countSpans(resourceSpan *tracev1.ResourceSpans) int {
+	count := 0
+	for _, scopeSpans := range resourceSpan.ScopeSpans {
+		count += len(scopeSpans.Spans)
+	}
+	return count
+}
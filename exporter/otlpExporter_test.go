@@ -0,0 +1,220 @@
+package exporter
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/zerok-ai/zk-observer/config"
+	promMetrics "github.com/zerok-ai/zk-observer/metrics"
+	pb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testReceiver is an OTLP/HTTP receiver which records the number of spans of every export request.
+type testReceiver struct {
+	server *httptest.Server
+	mutex  sync.Mutex
+	// status returns the response status for the n-th request, starting at 1.
+	status   func(n int) int
+	requests []int
+}
+
+func newTestReceiver(t *testing.T, status func(n int) int) *testReceiver {
+	receiver := &testReceiver{status: status}
+	receiver.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading request body: %v", err)
+		}
+		request := &pb.ExportTraceServiceRequest{}
+		if err = proto.Unmarshal(body, request); err != nil {
+			t.Errorf("decoding export request: %v", err)
+		}
+		spanCount := 0
+		for _, resourceSpan := range request.ResourceSpans {
+			spanCount += countSpans(resourceSpan)
+		}
+
+		receiver.mutex.Lock()
+		receiver.requests = append(receiver.requests, spanCount)
+		n := len(receiver.requests)
+		receiver.mutex.Unlock()
+		w.WriteHeader(receiver.status(n))
+	}))
+	t.Cleanup(receiver.server.Close)
+	return receiver
+}
+
+func (r *testReceiver) received() []int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]int{}, r.requests...)
+}
+
+func newTestExporter(t *testing.T, cfg config.ExporterConfig) *OtlpExporter {
+	cfg.Name = t.Name()
+	cfg.Protocol = ProtocolHTTP
+	cfg.Workers = 1
+	cfg = withDefaults(cfg)
+	s, err := newHttpSender(cfg)
+	if err != nil {
+		t.Fatalf("newHttpSender() error = %v", err)
+	}
+	return newOtlpExporter(cfg, s)
+}
+
+func testResourceSpans(count int) []*tracev1.ResourceSpans {
+	resourceSpans := make([]*tracev1.ResourceSpans, 0, count)
+	for i := 0; i < count; i++ {
+		resourceSpans = append(resourceSpans, &tracev1.ResourceSpans{
+			ScopeSpans: []*tracev1.ScopeSpans{{Spans: []*tracev1.Span{{Name: "span"}}}},
+		})
+	}
+	return resourceSpans
+}
+
+func intPtr(value int) *int {
+	return &value
+}
+
+type exporterMetrics struct {
+	sent, failed, queued float64
+}
+
+func readExporterMetrics(t *testing.T, name string) exporterMetrics {
+	value := func(metric prometheus.Metric) *dto.Metric {
+		m := &dto.Metric{}
+		if err := metric.Write(m); err != nil {
+			t.Fatalf("reading metric: %v", err)
+		}
+		return m
+	}
+	return exporterMetrics{
+		sent:   value(promMetrics.ExporterSpansSent.WithLabelValues(name)).GetCounter().GetValue(),
+		failed: value(promMetrics.ExporterSpansFailed.WithLabelValues(name)).GetCounter().GetValue(),
+		queued: value(promMetrics.ExporterSpansQueued.WithLabelValues(name)).GetGauge().GetValue(),
+	}
+}
+
+// waitForMetrics waits until the metrics of the exporter are the wanted ones, or fails after the timeout.
+func waitForMetrics(t *testing.T, name string, want exporterMetrics, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		got := readExporterMetrics(t, name)
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("exporter metrics = %+v, want %+v", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExporterBatchesSpans(t *testing.T) {
+	receiver := newTestReceiver(t, func(n int) int { return http.StatusOK })
+	exporter := newTestExporter(t, config.ExporterConfig{Endpoint: receiver.server.URL, BatchSize: 3, SyncDuration: 1})
+	exporter.start()
+
+	exporter.Enqueue(testResourceSpans(7))
+	// Full batches are sent right away, and the rest on the next flush.
+	waitForMetrics(t, exporter.Name(), exporterMetrics{sent: 6, queued: 1}, 500*time.Millisecond)
+	waitForMetrics(t, exporter.Name(), exporterMetrics{sent: 7}, 2*time.Second)
+
+	if got, want := receiver.received(), []int{3, 3, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("spans per request = %v, want %v", got, want)
+	}
+}
+
+func TestExporterRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries *int
+		status     func(n int) int
+		want       exporterMetrics
+		// wantRequests is the number of export requests of the batch.
+		wantRequests int
+	}{
+		{
+			name:         "retried until max retries",
+			maxRetries:   intPtr(2),
+			status:       func(n int) int { return http.StatusServiceUnavailable },
+			want:         exporterMetrics{failed: 2},
+			wantRequests: 3,
+		},
+		{
+			name:       "sent after a retry",
+			maxRetries: intPtr(2),
+			status: func(n int) int {
+				if n == 1 {
+					return http.StatusTooManyRequests
+				}
+				return http.StatusOK
+			},
+			want:         exporterMetrics{sent: 2},
+			wantRequests: 2,
+		},
+		{
+			name:         "retries disabled",
+			maxRetries:   intPtr(0),
+			status:       func(n int) int { return http.StatusServiceUnavailable },
+			want:         exporterMetrics{failed: 2},
+			wantRequests: 1,
+		},
+		{
+			name:         "not retryable status",
+			status:       func(n int) int { return http.StatusBadRequest },
+			want:         exporterMetrics{failed: 2},
+			wantRequests: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receiver := newTestReceiver(t, test.status)
+			exporter := newTestExporter(t, config.ExporterConfig{
+				Endpoint:      receiver.server.URL,
+				BatchSize:     2,
+				MaxRetries:    test.maxRetries,
+				RetryInterval: 1,
+			})
+			exporter.start()
+
+			exporter.Enqueue(testResourceSpans(2))
+			// Two retries wait for the backoff of 1 and 2 seconds.
+			waitForMetrics(t, exporter.Name(), test.want, 5*time.Second)
+			if got := len(receiver.received()); got != test.wantRequests {
+				t.Errorf("export requests = %d, want %d", got, test.wantRequests)
+			}
+		})
+	}
+}
+
+func TestExporterDropsWhenQueueFull(t *testing.T) {
+	receiver := newTestReceiver(t, func(n int) int { return http.StatusOK })
+	// The exporter is not started, so nothing drains the queue.
+	exporter := newTestExporter(t, config.ExporterConfig{Endpoint: receiver.server.URL, BatchSize: 2, QueueSize: 2})
+
+	exporter.Enqueue(testResourceSpans(3))
+	if got, want := readExporterMetrics(t, exporter.Name()), (exporterMetrics{failed: 1, queued: 2}); got != want {
+		t.Errorf("exporter metrics = %+v, want %+v", got, want)
+	}
+
+	// The batches channel holds a single batch, so the second flushed batch is dropped too.
+	for i := 0; i < 2; i++ {
+		exporter.batch = &pendingBatch{resourceSpans: []*tracev1.ResourceSpans{<-exporter.queue}, spanCount: 1}
+		exporter.flush()
+	}
+	if got, want := readExporterMetrics(t, exporter.Name()), (exporterMetrics{failed: 2, queued: 1}); got != want {
+		t.Errorf("exporter metrics = %+v, want %+v", got, want)
+	}
+	if got := len(exporter.batches); got != 1 {
+		t.Errorf("pending batches = %d, want 1", got)
+	}
+}
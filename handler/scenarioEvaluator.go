@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/zerok-ai/zk-observer/common"
+	"github.com/zerok-ai/zk-observer/model"
+	"github.com/zerok-ai/zk-observer/proto/zkspan"
+	scenarioPkg "github.com/zerok-ai/zk-observer/scenario"
+	"github.com/zerok-ai/zk-observer/utils"
+	zkUtilsCommonModel "github.com/zerok-ai/zk-utils-go/common"
+	logger "github.com/zerok-ai/zk-utils-go/logs"
+	zkUtilsEnrichedSpan "github.com/zerok-ai/zk-utils-go/proto/enrichedSpan"
+	ExecutorModel "github.com/zerok-ai/zk-utils-go/scenario/model"
+	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"sort"
+)
+
+var ErrTraceNotFound = errors.New("trace not found")
+
+// EvaluateScenario evaluates the scenario on the spans of the payload, like for ingested spans, but without
+// storing anything. Exceptions of the spans are fingerprinted and not stored.
+func (th *TraceHandler) EvaluateScenario(scenario *ExecutorModel.Scenario, resourceSpans []*tracev1.ResourceSpans) model.ScenarioEvaluation {
+	result := model.ScenarioEvaluation{ScenarioId: scenario.Id, Spans: make([]model.SpanEvaluation, 0)}
+	for _, resourceSpan := range resourceSpans {
+		resource := th.processResource(resourceSpan)
+		for _, scopeSpans := range resourceSpan.ScopeSpans {
+			scope := th.processScope(scopeSpans, resource.schemaVersion)
+			for _, span := range scopeSpans.Spans {
+				spanJSON, spanAttributes, _, _, _ := th.buildSpanJSON(span, resource, scope, false)
+				target := scenarioPkg.NewSpanTarget(resource.serviceName, resource.attrMap, span.Kind, th.evaluationProtocol(span.Kind, spanAttributes))
+				result.Spans = append(result.Spans, th.evaluateSpan(scenario, span, spanJSON, target))
+			}
+		}
+	}
+	return result
+}
+
+// EvaluateScenarioForTrace evaluates the scenario on the spans of a trace stored in Badger. Spans are rebuilt
+// from the stored raw spans, or from the zk spans when raw spans are not stored.
+func (th *TraceHandler) EvaluateScenarioForTrace(scenario *ExecutorModel.Scenario, traceId string) (*model.ScenarioEvaluation, error) {
+	result := model.ScenarioEvaluation{ScenarioId: scenario.Id, Spans: make([]model.SpanEvaluation, 0)}
+	if th.storesRawSpans() {
+		rawSpans, err := th.traceBadgerHandler.GetBulkDataForPrefixList([]string{traceId + delimiter})
+		if err != nil {
+			logger.Error(traceLogTag, "Error while getting spans of trace ", traceId, " for scenario evaluation ", err)
+			return nil, err
+		}
+		for _, key := range sortedKeys(rawSpans) {
+			enrichedSpan := zkUtilsEnrichedSpan.GetEnrichedSpan(rawSpans[key])
+			if enrichedSpan == nil || enrichedSpan.Span == nil {
+				continue
+			}
+			_, errorFlag := enrichedSpan.SpanAttributes[common.OTelSpanAttrErrorReasonKey]
+			spanJSON, target := th.storedSpanJSON(enrichedSpan.Span, enrichedSpan.SpanAttributes, enrichedSpan.SpanEvents, errorFlag, enrichedSpan.ResourceAttributesHash, enrichedSpan.ScopeAttributesHash)
+			result.Spans = append(result.Spans, th.evaluateSpan(scenario, enrichedSpan.Span, spanJSON, target))
+		}
+	} else {
+		zkSpans, err := th.traceBadgerHandler.GetBulkZkSpansForPrefixList([]string{traceId + delimiter})
+		if err != nil {
+			logger.Error(traceLogTag, "Error while getting zk spans of trace ", traceId, " for scenario evaluation ", err)
+			return nil, err
+		}
+		for _, key := range sortedKeys(zkSpans) {
+			zkSpan := zkSpans[key]
+			span := spanFromZkSpan(zkSpan)
+			spanAttributes := utils.ConvertKVListToMap(zkSpan.SpanAttributes)
+			spanJSON, target := th.storedSpanJSON(span, spanAttributes, nil, len(zkSpan.Errors) > 0, zkSpan.ResourceAttributesHash, zkSpan.ScopeAttributesHash)
+			result.Spans = append(result.Spans, th.evaluateSpan(scenario, span, spanJSON, target))
+		}
+	}
+
+	if len(result.Spans) == 0 {
+		return nil, ErrTraceNotFound
+	}
+	return &result, nil
+}
+
+// GetScenarioStatus returns the loaded scenarios with their last match times.
+func (th *TraceHandler) GetScenarioStatus() []model.ScenarioStatus {
+	return th.spanFilteringHandler.GetScenarioStatus()
+}
+
+func (th *TraceHandler) evaluateSpan(scenario *ExecutorModel.Scenario, span *tracev1.Span, spanJSON map[string]interface{}, target scenarioPkg.SpanTarget) model.SpanEvaluation {
+	spanResult := th.spanFilteringHandler.EvaluateScenario(scenario, spanJSON, target)
+	spanResult.TraceId = hex.EncodeToString(span.TraceId)
+	spanResult.SpanId = hex.EncodeToString(span.SpanId)
+	spanResult.SpanName = span.Name
+	return spanResult
+}
+
+// storedSpanJSON returns the span as evaluated by the scenarios, with the resource and scope attributes stored
+// under their hashes, and its target for the workload selectors.
+func (th *TraceHandler) storedSpanJSON(span *tracev1.Span, spanAttributes map[string]interface{}, spanEvents []zkUtilsCommonModel.GenericMap, errorFlag bool, resourceAttrHash string, scopeAttrHash string) (map[string]interface{}, scenarioPkg.SpanTarget) {
+	resourceAttrMap, schemaUrl := th.storedAttributes(resourceAttrHash)
+	scopeAttrMap, _ := th.storedAttributes(scopeAttrHash)
+	if spanAttributes == nil {
+		spanAttributes = map[string]interface{}{}
+	}
+	if spanEvents == nil {
+		spanEvents = []zkUtilsCommonModel.GenericMap{}
+	}
+	if len(schemaUrl) == 0 {
+		schemaUrl = DefaultNodeJsSchemaUrl
+	}
+
+	serviceName := common.ScenarioWorkloadGenericServiceNameKey
+	if len(resourceAttrMap) > 0 {
+		serviceName = utils.GetServiceName(resourceAttrMap)
+	}
+	// Stored attributes were already translated, so the schema url of the resource is the target one.
+	spanJSON := newSpanJSON(span, spanAttributes, spanEvents, errorFlag, resourceAttrMap, scopeAttrMap, utils.GetSchemaVersion(schemaUrl))
+	return spanJSON, scenarioPkg.NewSpanTarget(serviceName, resourceAttrMap, span.Kind, th.evaluationProtocol(span.Kind, spanAttributes))
+}
+
+func (th *TraceHandler) storedAttributes(hash string) (map[string]interface{}, string) {
+	if len(hash) == 0 {
+		return map[string]interface{}{}, ""
+	}
+	infoMap, err := th.resourceAndScoperAttrHandler.GetResourceAndScopeAttrData(hash)
+	if err != nil || infoMap == nil {
+		logger.Warn(traceLogTag, "Attributes not found for hash ", hash, " during scenario evaluation")
+		return map[string]interface{}{}, ""
+	}
+	attrMap, _ := infoMap["attributes_map"].(map[string]interface{})
+	if attrMap == nil {
+		attrMap = map[string]interface{}{}
+	}
+	schemaUrl, _ := infoMap["schema_url"].(string)
+	return attrMap, schemaUrl
+}
+
+// spanFromZkSpan rebuilds the OTel span properties the scenarios can evaluate from a zk span.
+func spanFromZkSpan(zkSpan *zkspan.ZkSpan) *tracev1.Span {
+	span := &tracev1.Span{
+		Name:              zkSpan.SpanName,
+		Kind:              tracev1.Span_SpanKind(tracev1.Span_SpanKind_value["SPAN_KIND_"+zkSpan.SpanKind]),
+		StartTimeUnixNano: zkSpan.StartNs,
+		EndTimeUnixNano:   zkSpan.StartNs + zkSpan.LatencyNs,
+		Attributes:        zkSpan.SpanAttributes,
+	}
+	span.TraceId, _ = hex.DecodeString(zkSpan.TraceId)
+	span.SpanId, _ = hex.DecodeString(zkSpan.SpanId)
+	span.ParentSpanId, _ = hex.DecodeString(zkSpan.ParentSpanId)
+	if len(zkSpan.Errors) > 0 {
+		span.Status = &tracev1.Status{Code: tracev1.Status_STATUS_CODE_ERROR}
+	}
+	return span
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ParseTracesJSON parses OTLP JSON traces. OTLP JSON encodes trace and span ids in hex, while the protobuf JSON
+// mapping expects base64, so the ids are converted first.
+func ParseTracesJSON(data []byte) ([]*tracev1.ResourceSpans, error) {
+	// Numbers are kept as they are, as nanosecond timestamps do not fit in a float64.
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var traces map[string]interface{}
+	if err := decoder.Decode(&traces); err != nil {
+		return nil, err
+	}
+	for _, resourceSpan := range jsonObjects(traces["resourceSpans"]) {
+		for _, scopeSpans := range jsonObjects(resourceSpan["scopeSpans"]) {
+			for _, span := range jsonObjects(scopeSpans["spans"]) {
+				if err := hexIdsToBase64(span, "traceId", "spanId", "parentSpanId"); err != nil {
+					return nil, err
+				}
+				for _, link := range jsonObjects(span["links"]) {
+					if err := hexIdsToBase64(link, "traceId", "spanId"); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+
+	converted, err := json.Marshal(traces)
+	if err != nil {
+		return nil, err
+	}
+	var tracesData tracev1.TracesData
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(converted, &tracesData); err != nil {
+		return nil, err
+	}
+	return tracesData.ResourceSpans, nil
+}
+
+// jsonObjects returns the objects of a JSON array, skipping other values.
+func jsonObjects(value interface{}) []map[string]interface{} {
+	list, _ := value.([]interface{})
+	objects := make([]map[string]interface{}, 0, len(list))
+	for _, item := range list {
+		if object, ok := item.(map[string]interface{}); ok {
+			objects = append(objects, object)
+		}
+	}
+	return objects
+}
+
+func hexIdsToBase64(values map[string]interface{}, keys ...string) error {
+	for _, key := range keys {
+		id, ok := values[key].(string)
+		if !ok || len(id) == 0 {
+			continue
+		}
+		idBytes, err := hex.DecodeString(id)
+		if err != nil {
+			return fmt.Errorf("invalid %s %s", key, id)
+		}
+		values[key] = base64.StdEncoding.EncodeToString(idBytes)
+	}
+	return nil
+}
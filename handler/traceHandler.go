@@ -2,11 +2,21 @@ package handler
 
 import (
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/golang/protobuf/proto"
 	"github.com/kataras/iris/v12"
 	"github.com/zerok-ai/zk-observer/common"
 	"github.com/zerok-ai/zk-observer/config"
+	"github.com/zerok-ai/zk-observer/exception"
+	"github.com/zerok-ai/zk-observer/exporter"
+	"github.com/zerok-ai/zk-observer/k8s"
 	"github.com/zerok-ai/zk-observer/model"
+	"github.com/zerok-ai/zk-observer/processor"
+	"github.com/zerok-ai/zk-observer/proto/zkspan"
+	"github.com/zerok-ai/zk-observer/sampling"
+	"github.com/zerok-ai/zk-observer/scenario"
+	"github.com/zerok-ai/zk-observer/servicegraph"
 	"github.com/zerok-ai/zk-observer/stores/badger"
 	"github.com/zerok-ai/zk-observer/stores/redis"
 	"github.com/zerok-ai/zk-observer/utils"
@@ -23,12 +33,15 @@ import (
 	"io"
 	"strings"
 	"sync"
+	"time"
 )
 
 var traceLogTag = "TraceHandler"
 var delimiter = "__"
 var DefaultNodeJsSchemaUrl = "https://opentelemetry.io/schemas/1.7.0"
 
+var ErrServiceGraphDisabled = errors.New("service graph is disabled")
+
 type SpanForStorage interface {
 	zkUtilsEnrichedSpan.OtelEnrichedRawSpan | model.OTelSpanDetails
 }
@@ -39,11 +52,25 @@ type TraceHandler struct {
 	traceRedisHandler            *redis.TraceRedisHandler
 	traceBadgerHandler           *badger.TraceBadgerHandler
 	exceptionHandler             *redis.ExceptionRedisHandler
+	fingerprinter                *exception.Fingerprinter
 	resourceDetailsHandler       *redis.ResourceRedisHandler
 	serviceListHandler           *redis.ServiceListRedisHandler
 	resourceAndScoperAttrHandler *redis.ResourceAndScopeAttributesHandler
 	otlpConfig                   *config.OtlpConfig
 	spanFilteringHandler         *redis.SpanFilteringHandler
+	exportHandler                *exporter.ExportHandler
+	metadataCache                *k8s.MetadataCache
+	dnsCache                     *utils.DnsCache
+	podIpLookup                  *utils.PodIpLookup
+	protocolDetector             *utils.ProtocolDetector
+	schemaTranslator             *processor.SchemaTranslator
+	transformProcessor           *processor.TransformProcessor
+	redactionProcessor           *processor.RedactionProcessor
+	spanMetricsProcessor         *processor.SpanMetricsProcessor
+	serviceGraph                 *servicegraph.ServiceGraph
+	headSampler                  *sampling.HeadSampler
+	tailSampler                  *sampling.TailSampler
+	samplingDecisionHandler      *redis.SamplingDecisionRedisHandler
 	factory                      stores.StoreFactory
 }
 
@@ -73,6 +100,13 @@ func NewTraceHandler(config *config.OtlpConfig, factory stores.StoreFactory) (*T
 		return nil, err
 	}
 
+	fingerprinter, err := exception.NewFingerprinter(config.Exception.Fingerprint)
+	if err != nil {
+		logger.Error(traceLogTag, "Error while creating exception fingerprinter:", err)
+		return nil, err
+	}
+	handler.fingerprinter = fingerprinter
+
 	resourceHandler, err := redis.NewResourceDetailsHandler(config)
 	if err != nil {
 		logger.Error(traceLogTag, "Error while creating resource details handler:", err)
@@ -85,6 +119,16 @@ func NewTraceHandler(config *config.OtlpConfig, factory stores.StoreFactory) (*T
 		return nil, err
 	}
 
+	switch config.Traces.StorageFormat {
+	case "":
+		config.Traces.StorageFormat = common.StorageFormatRaw
+	case common.StorageFormatRaw, common.StorageFormatZk, common.StorageFormatBoth:
+	default:
+		err = fmt.Errorf("unknown trace storage format %s", config.Traces.StorageFormat)
+		logger.Error(traceLogTag, "Invalid traces config:", err)
+		return nil, err
+	}
+
 	executorAttrStore := factory.GetExecutorAttrStore()
 	podDetailsStore := factory.GetPodDetailsStore()
 
@@ -104,6 +148,96 @@ func NewTraceHandler(config *config.OtlpConfig, factory stores.StoreFactory) (*T
 	}
 	handler.spanFilteringHandler = spanFilteringHandler
 
+	handler.dnsCache = utils.NewDnsCache(config.Dns)
+
+	protocolDetector, err := utils.NewProtocolDetector(config.ProtocolDetection)
+	if err != nil {
+		logger.Error(traceLogTag, "Error while creating protocol detector:", err)
+		return nil, err
+	}
+	handler.protocolDetector = protocolDetector
+
+	if config.K8sMetadata.Enabled {
+		metadataCache, err := k8s.NewMetadataCache(config.K8sMetadata)
+		if err != nil {
+			logger.Error(traceLogTag, "Error while creating k8s metadata cache:", err)
+			return nil, err
+		}
+		handler.metadataCache = metadataCache
+	} else {
+		// Without the metadata cache, the pod IPs not set by the SDKs are looked up by pod name.
+		podIpLookup, err := utils.NewPodIpLookup()
+		if err != nil {
+			logger.Warn(traceLogTag, "Pod IPs will not be looked up, error while creating pod IP lookup:", err)
+		} else {
+			handler.podIpLookup = podIpLookup
+		}
+	}
+
+	schemaTranslator, err := processor.NewSchemaTranslator(config.SchemaTranslation)
+	if err != nil {
+		logger.Error(traceLogTag, "Error while creating schema translator:", err)
+		return nil, err
+	}
+	handler.schemaTranslator = schemaTranslator
+
+	transformProcessor, err := processor.NewTransformProcessor(config.Transform)
+	if err != nil {
+		logger.Error(traceLogTag, "Error while creating transform processor:", err)
+		return nil, err
+	}
+	handler.transformProcessor = transformProcessor
+
+	redactionProcessor, err := processor.NewRedactionProcessor(config.Redaction)
+	if err != nil {
+		logger.Error(traceLogTag, "Error while creating redaction processor:", err)
+		return nil, err
+	}
+	handler.redactionProcessor = redactionProcessor
+
+	// Exporters forward copies of the received spans, which are redacted like the stored spans.
+	exportHandler, err := exporter.NewExportHandler(config, redactionProcessor)
+	if err != nil {
+		logger.Error(traceLogTag, "Error while creating export handler:", err)
+		return nil, err
+	}
+	handler.exportHandler = exportHandler
+
+	spanMetricsProcessor, err := processor.NewSpanMetricsProcessor(config.SpanMetrics, protocolDetector)
+	if err != nil {
+		logger.Error(traceLogTag, "Error while creating span metrics processor:", err)
+		return nil, err
+	}
+	handler.spanMetricsProcessor = spanMetricsProcessor
+
+	if config.HeadSampling.Enabled {
+		headSampler, err := sampling.NewHeadSampler(config.HeadSampling)
+		if err != nil {
+			logger.Error(traceLogTag, "Error while creating head sampler:", err)
+			return nil, err
+		}
+		handler.headSampler = headSampler
+	}
+
+	if config.TailSampling.Enabled {
+		samplingDecisionHandler, err := redis.NewSamplingDecisionRedisHandler(config)
+		if err != nil {
+			logger.Error(traceLogTag, "Error while creating sampling decision handler:", err)
+			return nil, err
+		}
+		handler.samplingDecisionHandler = samplingDecisionHandler
+		handler.tailSampler = sampling.NewTailSampler(config.TailSampling, samplingDecisionHandler, handler.storeSampledSpans)
+	}
+
+	if config.ServiceGraph.Enabled {
+		serviceGraphRedisHandler, err := redis.NewServiceGraphRedisHandler(config)
+		if err != nil {
+			logger.Error(traceLogTag, "Error while creating service graph redis handler:", err)
+			return nil, err
+		}
+		handler.serviceGraph = servicegraph.NewServiceGraph(config.ServiceGraph, serviceGraphRedisHandler)
+	}
+
 	return &handler, nil
 }
 
@@ -145,39 +279,82 @@ func (th *TraceHandler) PushDataToRedis() {
 	th.spanFilteringHandler.SyncPipeline()
 	th.resourceAndScoperAttrHandler.SyncPipeline()
 	th.serviceListHandler.SyncPipeline()
+	if th.samplingDecisionHandler != nil {
+		th.samplingDecisionHandler.SyncPipeline()
+	}
 }
 
-func (th *TraceHandler) processOTelSpanEvents(span *tracev1.Span) ([]zkUtilsCommonModel.GenericMap, bool) {
+// storeSampledSpans stores the spans of the traces kept by the tail sampler once their decision window ends.
+func (th *TraceHandler) storeSampledSpans(spans []sampling.BufferedSpan) {
+	for _, span := range spans {
+		th.addSpanProtoToTraceStore(span.Key, span.SpanProto)
+	}
+	th.PushDataToRedis()
+}
+
+func (th *TraceHandler) processOTelSpanEvents(span *tracev1.Span, schemaVersion string, serviceName string, sdkLanguage string, storeExceptions bool) ([]zkUtilsCommonModel.GenericMap, []model.SpanErrorInfo) {
 	var spanEventsList []zkUtilsCommonModel.GenericMap
-	var errorFlag bool
+	var spanErrors []model.SpanErrorInfo
 	if len(span.Events) > 0 {
 		for _, event := range span.Events {
 			eventMap := utils.ObjectToInterfaceMap(event)
 			if event.Name == common.OTelSpanEventException {
-				hash := th.processOTelSpanException(hex.EncodeToString(span.SpanId), event)
+				spanError := th.processOTelSpanException(hex.EncodeToString(span.TraceId), hex.EncodeToString(span.SpanId), event, serviceName, sdkLanguage, storeExceptions)
 				// override attributes with nil as data is saved to other db
 				eventMap[common.OTelSpanEventAttrKey] = nil
-				eventMap[common.OTelSpanEventExceptionHashKey] = hash
-				errorFlag = true
+				eventMap[common.OTelSpanEventExceptionHashKey] = spanError.Hash
+				eventMap[common.OTelSpanEventExceptionGroupHashKey] = spanError.GroupHash
+				spanErrors = append(spanErrors, spanError)
 				spanEventsList = append(spanEventsList, eventMap)
 			} else {
 				eventAttributes := utils.ConvertKVListToMap(event.Attributes)
+				eventMap[common.OTelSpanEventNameKey] = th.schemaTranslator.TranslateEvent(schemaVersion, event.Name, eventAttributes)
 				// override attributes with event attributes hashmap
 				eventMap[common.OTelSpanEventAttrKey] = eventAttributes
 				spanEventsList = append(spanEventsList, eventMap)
 			}
 		}
 	}
-	return spanEventsList, errorFlag
+	return spanEventsList, spanErrors
 }
 
-func (th *TraceHandler) processOTelSpanException(spanIdStr string, event *tracev1.Span_Event) string {
+func (th *TraceHandler) processOTelSpanException(traceIdStr string, spanIdStr string, event *tracev1.Span_Event, serviceName string, sdkLanguage string, storeException bool) model.SpanErrorInfo {
 	exceptionDetails := redis.CreateExceptionDetails(event)
-	hash, err := th.exceptionHandler.SyncExceptionData(exceptionDetails, spanIdStr)
+	exceptionGroup := th.fingerprinter.Fingerprint(exceptionDetails, sdkLanguage)
+	// Exceptions are grouped before redaction, so that their group does not change with the redaction rules.
+	exceptionDetails = th.redactionProcessor.RedactException(exceptionDetails)
+	exceptionGroup = th.redactionProcessor.RedactExceptionGroup(exceptionGroup)
+	if !storeException {
+		return model.SpanErrorInfo{
+			ErrorType:     model.ErrorTypeException,
+			Hash:          redis.ExceptionHash(exceptionDetails),
+			GroupHash:     exceptionDetails.GroupHash,
+			ExceptionType: exceptionDetails.Type,
+			Message:       exceptionDetails.Message,
+		}
+	}
+	hash, err := th.exceptionHandler.SyncExceptionData(exceptionDetails, exceptionGroup, spanIdStr)
 	if err != nil {
 		logger.Error(traceLogTag, "Error while syncing exception data for spanId ", spanIdStr, " with error ", err)
+	} else {
+		timestamp := time.Now().Unix()
+		if event.TimeUnixNano > 0 {
+			timestamp = int64(event.TimeUnixNano / uint64(time.Second))
+		}
+		th.exceptionHandler.RecordOccurrence(exceptionGroup, model.ExceptionSample{
+			TraceId:     traceIdStr,
+			SpanId:      spanIdStr,
+			ServiceName: serviceName,
+			Timestamp:   timestamp,
+		})
+	}
+	return model.SpanErrorInfo{
+		ErrorType:     model.ErrorTypeException,
+		Hash:          hash,
+		GroupHash:     exceptionDetails.GroupHash,
+		ExceptionType: exceptionDetails.Type,
+		Message:       exceptionDetails.Message,
 	}
-	return hash
 }
 
 func (th *TraceHandler) ProcessTraceData(resourceSpans []*tracev1.ResourceSpans) {
@@ -186,47 +363,19 @@ func (th *TraceHandler) ProcessTraceData(resourceSpans []*tracev1.ResourceSpans)
 		logger.Info(traceLogTag, "No resources found in the call")
 		return
 	}
-	for _, resourceSpan := range resourceSpans {
-		schemaUrl := resourceSpan.SchemaUrl
-		if len(schemaUrl) == 0 {
-			schemaUrl = DefaultNodeJsSchemaUrl
-		}
 
-		schemaVersion := utils.GetSchemaVersion(schemaUrl)
-		resourceInfo := model.ResourceInfo{
-			SchemaUrl: schemaUrl,
-		}
+	// Spans are modified while processing, so the exporters get a redacted copy of the received data.
+	th.exportHandler.ExportAll(resourceSpans)
+	var filteredSpansBuilder *exporter.FilteredSpansBuilder
+	if th.exportHandler.ExportsFiltered() {
+		filteredSpansBuilder = exporter.NewFilteredSpansBuilder()
+	}
 
-		resourceInfo.AttributesMap = map[string]interface{}{}
-		var resourceAttrHash string
-		var resourceAttrMap map[string]interface{}
-		var resourceInfoMap map[string]interface{}
-		serviceName := common.ScenarioWorkloadGenericServiceNameKey
-		if resourceSpan.Resource != nil {
-			resourceInfoMap = utils.ObjectToInterfaceMap(resourceInfo)
-			resourceAttrMap = utils.ConvertKVListToMap(resourceSpan.Resource.Attributes)
-			resourceInfoMap["attributes_map"] = resourceAttrMap
-			resourceAttrHash = utils.ResourceAttributeHashPrefix + utils.GetMD5OfMap(resourceInfoMap)
-			serviceName = utils.GetServiceName(resourceAttrMap)
-		}
+	for _, resourceSpan := range resourceSpans {
+		resource := th.processResource(resourceSpan)
+		serviceName := resource.serviceName
 		for _, scopeSpans := range resourceSpan.ScopeSpans {
-			scopeInfo := model.ScopeInfo{
-				Name:      scopeSpans.Scope.Name,
-				Version:   scopeSpans.Scope.Version,
-				SchemaUrl: scopeSpans.SchemaUrl,
-			}
-			scopeInfo.AttributesMap = map[string]interface{}{}
-
-			var scopeAttrHash string
-			var scopeInfoMap map[string]interface{}
-			var scopeAttrMap map[string]interface{}
-			if scopeSpans.Scope != nil {
-				scopeInfoMap = utils.ObjectToInterfaceMap(scopeInfo)
-				scopeAttrMap = utils.ConvertKVListToMap(scopeSpans.Scope.Attributes)
-				scopeInfoMap["attributes_map"] = scopeAttrMap
-				scopeAttrHash = utils.ScopeAttributeHashPrefix + utils.GetMD5OfMap(scopeInfoMap)
-			}
-
+			scope := th.processScope(scopeSpans, resource.schemaVersion)
 			for _, span := range scopeSpans.Spans {
 				//TODO: remove this later
 				span.Links = nil
@@ -239,81 +388,213 @@ func (th *TraceHandler) ProcessTraceData(resourceSpans []*tracev1.ResourceSpans)
 					continue
 				}
 
+				if th.headSampler != nil && !th.headSampler.Sample(span, serviceName) {
+					continue
+				}
+
 				key := traceId + delimiter + spanId
 				var resourceIp string
-				spanAttributes := utils.ConvertKVListToMap(span.Attributes)
-				spanJSON := utils.ObjectToInterfaceMap(span)
-				spanJSON[common.OTelLatencyNsKey] = span.EndTimeUnixNano - span.StartTimeUnixNano
-				spanJSON[common.OTelSpanAttrKey] = spanAttributes
-				spanJSON[common.OTelResourceAttrKey] = resourceAttrMap
-				spanJSON[common.OTelScopeAttrKey] = scopeAttrMap
-				spanJSON[common.OTelSchemaVersionKey] = schemaVersion
-				spanEvents, errorFlag := th.processOTelSpanEvents(span)
-				spanJSON[common.OTelSpanEventsKey] = spanEvents
-				spanJSON[common.OTelSpanErrorKey] = errorFlag
+				spanJSON, spanAttributes, spanEvents, spanErrors, errorReason := th.buildSpanJSON(span, resource, scope, true)
+				errorFlag := len(spanErrors) > 0
 				// Evaluating and storing data in Otel span format.
-				workloadIds, groupBy := th.spanFilteringHandler.FilterSpans(traceId, spanJSON, serviceName)
+				workloadIds, groupBy := th.spanFilteringHandler.FilterSpans(traceId, spanJSON, scenario.NewSpanTarget(serviceName, resource.attrMap, span.Kind, th.evaluationProtocol(span.Kind, spanAttributes)))
+				if filteredSpansBuilder != nil && len(workloadIds) > 0 {
+					filteredSpansBuilder.Add(resourceSpan, scopeSpans, span)
+				}
 
 				spanKind := model.NewFromOTelSpan(span.Kind)
-				sourceIP, destIP := utils.GetSourceDestIPPair(spanKind, spanAttributes, resourceAttrMap)
+				th.spanMetricsProcessor.RecordSpan(traceId, serviceName, span, spanKind, errorFlag, spanAttributes, resource.attrMap)
+				if th.serviceGraph != nil {
+					th.serviceGraph.ConsumeSpan(traceId, spanId, hex.EncodeToString(span.ParentSpanId), spanKind, serviceName, errorFlag, span.EndTimeUnixNano-span.StartTimeUnixNano, spanAttributes)
+				}
+				sourceIP, destIP := utils.GetSourceDestIPPair(spanKind, spanAttributes, resource.attrMap, th.dnsCache, th.podIpLookup)
 				resourceIp = utils.GetResourceIp(spanKind, sourceIP, destIP)
 
+				verdict := sampling.SpanVerdict{
+					MatchedWorkload: len(workloadIds) > 0,
+					Error:           errorFlag,
+					LatencyNs:       span.EndTimeUnixNano - span.StartTimeUnixNano,
+				}
+				// Only the stored copies are redacted, the received attributes are used until here.
+				storedSpanAttributes := th.redactionProcessor.RedactSpanAttributes(spanAttributes)
+				if th.storesZkSpans() {
+					spanDetails := th.generateSpanDetails(span, th.schemaTranslator.TargetVersion(scope.schemaVersion), spanAttributes, storedSpanAttributes, spanErrors, resource, scope)
+					spanDetails.ErrorReason = errorReason
+					spanDetails.WorkloadIdList = workloadIds
+					spanDetails.GroupBy = groupBy
+					th.storeSpan(traceId, common.ZkSpanKeyPrefix+key, spanDetails.ToProto(traceId, spanId), verdict)
+				}
+
 				span.Attributes = nil
 				span.Events = nil
 				enrichedRawSpan := zkUtilsEnrichedSpan.OtelEnrichedRawSpan{
 					Span:                   span,
-					SpanEvents:             spanEvents,
-					SpanAttributes:         spanAttributes,
-					ResourceAttributesHash: resourceAttrHash,
-					ScopeAttributesHash:    scopeAttrHash,
+					SpanEvents:             th.redactionProcessor.RedactSpanEvents(spanEvents),
+					SpanAttributes:         storedSpanAttributes,
+					ResourceAttributesHash: resource.attrHash,
+					ScopeAttributesHash:    scope.attrHash,
 					WorkloadIdList:         workloadIds,
 					GroupBy:                groupBy,
 				}
 
-				spanDetailsProtobufType := enrichedRawSpan.GetProtoEnrichedSpan()
-				th.addEnrichedSpanToTraceStore(key, spanDetailsProtobufType)
-				if err := th.resourceDetailsHandler.SyncResourceData(resourceIp, resourceAttrMap); err != nil {
+				if th.storesRawSpans() {
+					th.storeSpan(traceId, key, enrichedRawSpan.GetProtoEnrichedSpan(), verdict)
+				}
+				if err := th.resourceDetailsHandler.SyncResourceData(resourceIp, resource.storedAttrMap); err != nil {
 					logger.Error(traceLogTag, "Error while saving resource data to redis for spanId ", spanId, " error: ", err)
 				}
 
-				if err := th.resourceAndScoperAttrHandler.SyncResourceAndScopeAttrData(resourceAttrHash, resourceInfoMap); err != nil {
+				if err := th.resourceAndScoperAttrHandler.SyncResourceAndScopeAttrData(resource.attrHash, resource.infoMap); err != nil {
 					logger.Error(traceLogTag, "Error while saving resource  data to redis for spanId ", spanId, " error: ", err)
 				}
 
-				if err := th.resourceAndScoperAttrHandler.SyncResourceAndScopeAttrData(scopeAttrHash, scopeInfoMap); err != nil {
+				if err := th.resourceAndScoperAttrHandler.SyncResourceAndScopeAttrData(scope.attrHash, scope.infoMap); err != nil {
 					logger.Error(traceLogTag, "Error while saving  scope data to redis for spanId ", spanId, " error: ", err)
 				}
 
 				logger.Debug(traceLogTag, "service name:", serviceName)
 				if serviceName == common.ScenarioWorkloadGenericServiceNameKey {
 					logger.ErrorF(traceLogTag, "Service name could not be fetched for spanId %s, traceId %s", spanId, traceId)
-				} else if err := th.serviceListHandler.PutServiceListData(common.ServiceListKey, serviceName); err != nil {
-					logger.Error(traceLogTag, "Error while saving service list data to redis for spanId ", spanId, " error: ", err)
+				} else {
+					th.serviceListHandler.RecordService(serviceName, resource.storedAttrMap)
 				}
 			}
 		}
 	}
+	th.exportHandler.ExportFiltered(filteredSpansBuilder)
 	defer logger.InfoF(traceLogTag, "Processed %v spans", processedSpanCount)
 }
 
-func (th *TraceHandler) EvalAndStoreZkSpan() {
+// processedResource holds the attributes of the resource of a ResourceSpans, after the attribute processors.
+type processedResource struct {
+	schemaVersion string
+	attrMap       map[string]interface{}
+	// storedAttrMap is the redacted copy of attrMap, which is stored with the spans and hashed in attrHash.
+	storedAttrMap map[string]interface{}
+	attrHash      string
+	infoMap       map[string]interface{}
+	serviceName   string
+	sdkLanguage   string
+}
+
+// processedScope holds the attributes of the scope of a ScopeSpans, after the attribute processors.
+type processedScope struct {
+	schemaVersion string
+	attrMap       map[string]interface{}
+	// storedAttrMap is the redacted copy of attrMap, which is stored with the spans and hashed in attrHash.
+	storedAttrMap map[string]interface{}
+	attrHash      string
+	infoMap       map[string]interface{}
+}
+
+func (th *TraceHandler) processResource(resourceSpan *tracev1.ResourceSpans) processedResource {
+	schemaUrl := resourceSpan.SchemaUrl
+	if len(schemaUrl) == 0 {
+		schemaUrl = DefaultNodeJsSchemaUrl
+	}
+
+	schemaVersion := utils.GetSchemaVersion(schemaUrl)
+	resourceInfo := model.ResourceInfo{
+		SchemaUrl: th.schemaTranslator.TargetSchemaUrl(schemaUrl, schemaVersion),
+	}
+
+	resourceInfo.AttributesMap = map[string]interface{}{}
+	resource := processedResource{
+		schemaVersion: schemaVersion,
+		serviceName:   common.ScenarioWorkloadGenericServiceNameKey,
+	}
+	if resourceSpan.Resource != nil {
+		resource.infoMap = utils.ObjectToInterfaceMap(resourceInfo)
+		resource.attrMap = utils.ConvertKVListToMap(resourceSpan.Resource.Attributes)
+		th.schemaTranslator.TranslateResourceAttributes(schemaVersion, resource.attrMap)
+		if th.metadataCache != nil {
+			th.metadataCache.EnrichResourceAttributes(resource.attrMap)
+		}
+		th.transformProcessor.TransformResourceAttributes(resource.attrMap)
+		resource.storedAttrMap = th.redactionProcessor.RedactResourceAttributes(resource.attrMap)
+		resource.infoMap["attributes_map"] = resource.storedAttrMap
+		resource.attrHash = utils.ResourceAttributeHashPrefix + utils.GetMD5OfMap(resource.infoMap)
+		resource.serviceName = utils.GetServiceName(resource.attrMap)
+	}
+	resource.sdkLanguage, _ = resource.attrMap[model.TelemetrySdkLanguageKey].(string)
+	return resource
+}
+
+func (th *TraceHandler) processScope(scopeSpans *tracev1.ScopeSpans, schemaVersion string) processedScope {
+	scopeInfo := model.ScopeInfo{
+		Name:      scopeSpans.GetScope().GetName(),
+		Version:   scopeSpans.GetScope().GetVersion(),
+		SchemaUrl: scopeSpans.SchemaUrl,
+	}
+	// The schema url of the scope applies to its spans and events, and defaults to the one of the resource.
+	scope := processedScope{schemaVersion: schemaVersion}
+	if len(scopeSpans.SchemaUrl) > 0 {
+		scope.schemaVersion = utils.GetSchemaVersion(scopeSpans.SchemaUrl)
+		scopeInfo.SchemaUrl = th.schemaTranslator.TargetSchemaUrl(scopeSpans.SchemaUrl, scope.schemaVersion)
+	}
+	scopeInfo.AttributesMap = map[string]interface{}{}
+
+	if scopeSpans.Scope != nil {
+		scope.infoMap = utils.ObjectToInterfaceMap(scopeInfo)
+		scope.attrMap = utils.ConvertKVListToMap(scopeSpans.Scope.Attributes)
+		th.transformProcessor.TransformScopeAttributes(scope.attrMap)
+		scope.storedAttrMap = th.redactionProcessor.RedactScopeAttributes(scope.attrMap)
+		scope.infoMap["attributes_map"] = scope.storedAttrMap
+		scope.attrHash = utils.ScopeAttributeHashPrefix + utils.GetMD5OfMap(scope.infoMap)
+	}
+	return scope
+}
 
+// buildSpanJSON processes the attributes and events of the span, and returns the span as evaluated by the
+// scenarios. The exceptions of the span are only stored when storeExceptions is set.
+func (th *TraceHandler) buildSpanJSON(span *tracev1.Span, resource processedResource, scope processedScope, storeExceptions bool) (map[string]interface{}, map[string]interface{}, []zkUtilsCommonModel.GenericMap, []model.SpanErrorInfo, model.ErrorType) {
+	spanAttributes := utils.ConvertKVListToMap(span.Attributes)
+	th.schemaTranslator.TranslateSpanAttributes(scope.schemaVersion, span.Name, spanAttributes)
+	th.transformProcessor.TransformSpanAttributes(spanAttributes)
+	spanEvents, exceptionErrors := th.processOTelSpanEvents(span, scope.schemaVersion, resource.serviceName, resource.sdkLanguage, storeExceptions)
+	spanErrors, errorReason := exception.ClassifySpanErrors(span, spanAttributes, exceptionErrors)
+	if len(spanErrors) > 0 {
+		spanAttributes[common.OTelSpanAttrErrorReasonKey] = string(errorReason)
+	}
+	spanJSON := newSpanJSON(span, spanAttributes, spanEvents, len(spanErrors) > 0, resource.attrMap, scope.attrMap, th.schemaTranslator.TargetVersion(scope.schemaVersion))
+	return spanJSON, spanAttributes, spanEvents, spanErrors, errorReason
 }
 
-// Generate Span details from the span.
-func (th *TraceHandler) generateSpanDetails(span *tracev1.Span, schemaVersion string, resourceAttrMap zkUtilsCommonModel.GenericMap, resourceAttrHash string, scopeAttrMap zkUtilsCommonModel.GenericMap, scopeAttrHash string) model.OTelSpanDetails {
-	spanAttrMap := utils.ConvertKVListToMap(span.Attributes)
-	spanDetails := th.createSpanDetails(span, resourceAttrMap, spanAttrMap)
+// evaluationProtocol returns the executor protocol of the span for the workload selectors, detected from the span
+// attributes like for the span metrics. Protocols only guessed by the fallbacks are not known, so that no
+// workload is skipped for them.
+func (th *TraceHandler) evaluationProtocol(kind tracev1.Span_SpanKind, spanAttributes map[string]interface{}) ExecutorModel.ProtocolName {
+	protocol, rule := th.protocolDetector.DetectProtocolFromAttributes(utils.GetSpanKind(kind), spanAttributes)
+	if protocol == model.ProtocolTypeUnknown || rule == utils.ProtocolRuleSpanKindFallback || rule == utils.ProtocolRuleAttributePrefixFallback {
+		return ""
+	}
+	return utils.GetExecutorProtocolFromSpanProtocol(protocol)
+}
+
+// newSpanJSON returns the span with its processed attributes and events, as evaluated by the scenarios.
+func newSpanJSON(span *tracev1.Span, spanAttributes map[string]interface{}, spanEvents []zkUtilsCommonModel.GenericMap, errorFlag bool, resourceAttrMap map[string]interface{}, scopeAttrMap map[string]interface{}, schemaVersion string) map[string]interface{} {
+	spanJSON := utils.SpanToInterfaceMap(span)
+	spanJSON[common.OTelLatencyNsKey] = span.EndTimeUnixNano - span.StartTimeUnixNano
+	spanJSON[common.OTelSpanAttrKey] = spanAttributes
+	spanJSON[common.OTelResourceAttrKey] = resourceAttrMap
+	spanJSON[common.OTelScopeAttrKey] = scopeAttrMap
+	spanJSON[common.OTelSchemaVersionKey] = schemaVersion
+	spanJSON[common.OTelSpanEventsKey] = spanEvents
+	spanJSON[common.OTelSpanErrorKey] = errorFlag
+	return spanJSON
+}
+
+// Generate Span details from the span. The IPs and the protocol are detected from the received attributes, and the
+// stored attributes and protocol properties are taken from the redacted ones.
+func (th *TraceHandler) generateSpanDetails(span *tracev1.Span, schemaVersion string, spanAttrMap map[string]interface{}, storedSpanAttrMap map[string]interface{}, spanErrors []model.SpanErrorInfo, resource processedResource, scope processedScope) model.OTelSpanDetails {
+	spanDetails := th.createSpanDetails(span, resource.attrMap, spanAttrMap, spanErrors)
 	spanDetails.SchemaVersion = schemaVersion
 
 	/* Populate attributes */
 	if th.otlpConfig.SetSpanAttributes {
-		spanDetails.SpanAttributes = model.GenericMapPtrFromMap(spanAttrMap)
-		spanDetails.ResourceAttributes = &resourceAttrMap
-		spanDetails.ScopeAttributes = &scopeAttrMap
-
-		spanDetails.ScopeAttributesHash = scopeAttrHash
-		spanDetails.ResourceAttributesHash = resourceAttrHash
+		th.setSpanDetailsAttributes(&spanDetails, spanAttrMap, resource.attrMap, scope.attrMap)
+		spanDetails.ScopeAttributesHash = scope.attrHash
+		spanDetails.ResourceAttributesHash = resource.attrHash
 	}
 
 	spanDetailsMap := utils.ObjectToInterfaceMap(spanDetails)
@@ -322,20 +603,31 @@ func (th *TraceHandler) generateSpanDetails(span *tracev1.Span, schemaVersion st
 	executorAttrStore := th.factory.GetExecutorAttrStore()
 	podDetailsStore := th.factory.GetPodDetailsStore()
 	protocolIdentifierStoreKey, _ := cache.CreateKey(ExecutorModel.ExecutorOTel, spanDetails.SchemaVersion, ExecutorModel.ProtocolIdentifier)
-	identifierProtocolUtil := utils.NewSpanProtocolUtil(&spanDetails, &spanDetailsMap, executorAttrStore, podDetailsStore, &protocolIdentifierStoreKey)
-	spanDetails.Protocol = identifierProtocolUtil.DetectSpanProtocol()
+	identifierProtocolUtil := utils.NewSpanProtocolUtil(&spanDetails, &spanDetailsMap, spanAttrMap, executorAttrStore, podDetailsStore, &protocolIdentifierStoreKey)
+	spanDetails.Protocol, spanDetails.ProtocolRule = identifierProtocolUtil.DetectSpanProtocol(th.protocolDetector)
+
+	if th.otlpConfig.SetSpanAttributes && th.redactionProcessor.Enabled() {
+		th.setSpanDetailsAttributes(&spanDetails, storedSpanAttrMap, resource.storedAttrMap, scope.storedAttrMap)
+		spanDetailsMap = utils.ObjectToInterfaceMap(spanDetails)
+	}
 
 	/* Populate Span protocol attributes */
 	executorProtocol := utils.GetExecutorProtocolFromSpanProtocol(spanDetails.Protocol)
 	attrStoreKey, _ := cache.CreateKey(ExecutorModel.ExecutorOTel, spanDetails.SchemaVersion, executorProtocol)
-	spanProtocolUtil := utils.NewSpanProtocolUtil(&spanDetails, &spanDetailsMap, executorAttrStore, podDetailsStore, &attrStoreKey)
+	spanProtocolUtil := utils.NewSpanProtocolUtil(&spanDetails, &spanDetailsMap, storedSpanAttrMap, executorAttrStore, podDetailsStore, &attrStoreKey)
 	spanProtocolUtil.AddSpanProtocolProperties()
 
 	return spanDetails
 }
 
+func (th *TraceHandler) setSpanDetailsAttributes(spanDetails *model.OTelSpanDetails, spanAttrMap map[string]interface{}, resourceAttrMap zkUtilsCommonModel.GenericMap, scopeAttrMap zkUtilsCommonModel.GenericMap) {
+	spanDetails.SpanAttributes = model.GenericMapPtrFromMap(spanAttrMap)
+	spanDetails.ResourceAttributes = &resourceAttrMap
+	spanDetails.ScopeAttributes = &scopeAttrMap
+}
+
 // Populate Span common properties.
-func (th *TraceHandler) createSpanDetails(span *tracev1.Span, resourceAttrMap map[string]interface{}, spanAttrMap map[string]interface{}) model.OTelSpanDetails {
+func (th *TraceHandler) createSpanDetails(span *tracev1.Span, resourceAttrMap map[string]interface{}, spanAttrMap map[string]interface{}, spanErrors []model.SpanErrorInfo) model.OTelSpanDetails {
 	spanDetail := model.OTelSpanDetails{}
 	//spanDetail.TraceId = hex.EncodeToString(span.TraceId)
 	//spanDetail.SpanId = hex.EncodeToString(span.SpanId)
@@ -351,16 +643,10 @@ func (th *TraceHandler) createSpanDetails(span *tracev1.Span, resourceAttrMap ma
 		}
 	}
 
-	if len(span.Events) > 0 {
-		for _, event := range span.Events {
-			if event.Name == common.OTelSpanEventException {
-				spanExceptionDetails := th.createExceptionDetails(span, event)
-				spanDetail.Errors = append(spanDetail.Errors, spanExceptionDetails)
-			}
-		}
-	}
+	spanDetail.Errors = spanErrors
 
-	sourceIp, destIp := utils.GetSourceDestIPPair(spanDetail.SpanKind, spanAttrMap, resourceAttrMap)
+	// The IPs are normalized, which is also the form of the pod details keys for IPv4, IPv6 and IPv4-mapped IPv6.
+	sourceIp, destIp := utils.GetSourceDestIPPair(spanDetail.SpanKind, spanAttrMap, resourceAttrMap, th.dnsCache, th.podIpLookup)
 	podDetailsStore := th.factory.GetPodDetailsStore()
 	if len(sourceIp) > 0 {
 		spanDetail.SourceIp = &sourceIp
@@ -380,21 +666,6 @@ func (th *TraceHandler) createSpanDetails(span *tracev1.Span, resourceAttrMap ma
 	return spanDetail
 }
 
-func (th *TraceHandler) createExceptionDetails(span *tracev1.Span, event *tracev1.Span_Event) model.SpanErrorInfo {
-	exceptionDetails := redis.CreateExceptionDetails(event)
-	spanIdStr := hex.EncodeToString(span.SpanId)
-	hash, err := th.exceptionHandler.SyncExceptionData(exceptionDetails, spanIdStr)
-	if err != nil {
-		logger.Error(traceLogTag, "Error while syncing exception data for spanId ", spanIdStr, " with error ", err)
-	}
-	spanExceptionDetails := model.SpanErrorInfo{}
-	spanExceptionDetails.ErrorType = model.ErrorTypeException
-	spanExceptionDetails.Hash = hash
-	spanExceptionDetails.ExceptionType = exceptionDetails.Type
-	spanExceptionDetails.Message = exceptionDetails.Message
-	return spanExceptionDetails
-}
-
 func (th *TraceHandler) deleteFromTraceStore(keysToDelete []string) {
 	th.traceStoreMutex.Lock()
 	defer th.traceStoreMutex.Unlock()
@@ -403,25 +674,28 @@ func (th *TraceHandler) deleteFromTraceStore(keysToDelete []string) {
 	}
 }
 
-func (th *TraceHandler) addEnrichedSpanToTraceStore(key string, spanDetails *zkUtilsOtel.OtelEnrichedRawSpanForProto) {
-	th.traceStoreMutex.Lock()
-	defer th.traceStoreMutex.Unlock()
-	spanProto, err := proto.Marshal(spanDetails)
+// storeSpan adds the span to the trace store, or hands it to the tail sampler when tail sampling is enabled.
+func (th *TraceHandler) storeSpan(traceId string, key string, span proto.Message, verdict sampling.SpanVerdict) {
+	spanProto, err := proto.Marshal(span)
 	if err != nil {
-		logger.ErrorF(traceLogTag, "Error encoding SpanDetails for spanID %s: %v\n", spanDetails.Span.SpanId, err)
+		logger.ErrorF(traceLogTag, "Error encoding span for key %s: %v\n", key, err)
 		return
 	}
 
-	th.traceStore.Store(key, spanProto)
+	if th.tailSampler == nil {
+		th.addSpanProtoToTraceStore(key, spanProto)
+		return
+	}
+	spansToStore := th.tailSampler.Offer(traceId, sampling.BufferedSpan{Key: key, SpanProto: spanProto}, verdict)
+	for _, spanToStore := range spansToStore {
+		th.addSpanProtoToTraceStore(spanToStore.Key, spanToStore.SpanProto)
+	}
 }
 
-func (th *TraceHandler) addZkSpanToTraceStore(key string, spanDetails model.OTelSpanDetails) {
+func (th *TraceHandler) addSpanProtoToTraceStore(key string, spanProto []byte) {
 	th.traceStoreMutex.Lock()
 	defer th.traceStoreMutex.Unlock()
-	// Clear scope and Resource attributes from TraceStore as they are not required in zk span.
-	spanDetails.ResourceAttributes = nil
-	spanDetails.ScopeAttributes = nil
-	th.traceStore.Store(key, spanDetails)
+	th.traceStore.Store(key, spanProto)
 }
 
 func (th *TraceHandler) pushSpansToRedisPipeline() []string {
@@ -439,21 +713,29 @@ func (th *TraceHandler) pushSpansToRedisPipeline() []string {
 			logger.Error(traceLogTag, "Error while splitting key ", keyStr)
 			return true
 		}
-		traceIDStr := ids[0]
+		traceIDStr, isZkSpan := strings.CutPrefix(ids[0], common.ZkSpanKeyPrefix)
 		spanIDStr := ids[1]
 
-		err := th.traceBadgerHandler.PutTraceData(traceIDStr, spanIDStr, value.([]byte))
+		var err error
+		if isZkSpan {
+			err = th.traceBadgerHandler.PutZkSpanData(traceIDStr, spanIDStr, value.([]byte))
+		} else {
+			err = th.traceBadgerHandler.PutTraceData(traceIDStr, spanIDStr, value.([]byte))
+		}
 		if err != nil {
 			logger.Debug(traceLogTag, "Error while putting trace data to badger ", err)
 			// Returning false to stop the iteration
 			return false
 		}
 
-		err = th.traceRedisHandler.PutTraceSource(traceIDStr, spanIDStr)
-		if err != nil {
-			logger.Debug(traceLogTag, "Error while putting trace source to redis ", err)
-			// Returning false to stop the iteration
-			return false
+		// The trace source is added once per span, with the raw span when both formats are stored.
+		if !isZkSpan || !th.storesRawSpans() {
+			err = th.traceRedisHandler.PutTraceSource(traceIDStr, spanIDStr)
+			if err != nil {
+				logger.Debug(traceLogTag, "Error while putting trace source to redis ", err)
+				// Returning false to stop the iteration
+				return false
+			}
 		}
 
 		keysToDelete = append(keysToDelete, keyStr)
@@ -462,6 +744,38 @@ func (th *TraceHandler) pushSpansToRedisPipeline() []string {
 	return keysToDelete
 }
 
+func (th *TraceHandler) storesRawSpans() bool {
+	return th.otlpConfig.Traces.StorageFormat != common.StorageFormatZk
+}
+
+func (th *TraceHandler) storesZkSpans() bool {
+	storageFormat := th.otlpConfig.Traces.StorageFormat
+	return storageFormat == common.StorageFormatZk || storageFormat == common.StorageFormatBoth
+}
+
+func (th *TraceHandler) GetServices() ([]model.ServiceMetadata, error) {
+	return th.serviceListHandler.GetServices()
+}
+
+// GetExceptions returns the exception groups seen between from and to, by the service when it is not empty.
+func (th *TraceHandler) GetExceptions(serviceName string, from time.Time, to time.Time, limit int) ([]model.ExceptionSummary, error) {
+	return th.exceptionHandler.GetExceptions(serviceName, from, to, limit)
+}
+
+// GetException returns the occurrences of an exception group, or nil when there are none.
+func (th *TraceHandler) GetException(groupHash string) (*model.ExceptionOccurrences, error) {
+	return th.exceptionHandler.GetException(groupHash)
+}
+
+// GetServiceDependencies returns the service graph edges between from and to. It fails when the service
+// graph is disabled.
+func (th *TraceHandler) GetServiceDependencies(from time.Time, to time.Time) ([]model.ServiceDependency, error) {
+	if th.serviceGraph == nil {
+		return nil, ErrServiceGraphDisabled
+	}
+	return th.serviceGraph.GetDependencies(from, to)
+}
+
 func (th *TraceHandler) GetBulkDataFromBadgerForPrefix(prefixList []string) (*zkUtilsOtel.BadgerResponseList, error) {
 	traceToDataMap, err := th.traceBadgerHandler.GetBulkDataForPrefixList(prefixList)
 	var resp *zkUtilsOtel.BadgerResponseList
@@ -484,3 +798,22 @@ func (th *TraceHandler) GetBulkDataFromBadgerForPrefix(prefixList []string) (*zk
 	return resp, nil
 
 }
+
+func (th *TraceHandler) GetBulkZkDataFromBadgerForPrefix(prefixList []string) (*zkspan.ZkSpanResponseList, error) {
+	traceToZkSpanMap, err := th.traceBadgerHandler.GetBulkZkSpansForPrefixList(prefixList)
+	var resp *zkspan.ZkSpanResponseList
+	if err != nil {
+		logger.Error(traceLogTag, "Error while getting zk spans from badger for prefix list ", prefixList, " error is ", err)
+		return resp, err
+	}
+
+	if len(traceToZkSpanMap) > 0 {
+		resp = zkUtilsCommonModel.ToPtr(zkspan.ZkSpanResponseList{ResponseList: make([]*zkspan.ZkSpanResponse, 0)})
+	}
+
+	for k, v := range traceToZkSpanMap {
+		resp.ResponseList = append(resp.ResponseList, &zkspan.ZkSpanResponse{Key: k, Value: v})
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,423 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"github.com/zerok-ai/zk-observer/common"
+	"github.com/zerok-ai/zk-observer/config"
+	"github.com/zerok-ai/zk-observer/utils"
+	logger "github.com/zerok-ai/zk-utils-go/logs"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"os"
+	"sync"
+	"time"
+)
+
+var metadataCacheLogTag = "MetadataCache"
+
+const (
+	defaultResyncPeriod = 600
+	defaultSyncTimeout  = 60
+	apiTimeout          = 10 * time.Second
+
+	podIpIndex         = "podIp"
+	podUidIndex        = "podUid"
+	podReplicaSetIndex = "podReplicaSet"
+
+	kindReplicaSet  = "ReplicaSet"
+	kindDeployment  = "Deployment"
+	kindStatefulSet = "StatefulSet"
+	kindDaemonSet   = "DaemonSet"
+	kindJob         = "Job"
+)
+
+// PodMetadata is the part of a pod and its owners which is added to span resources.
+type PodMetadata struct {
+	Name           string
+	Uid            string
+	Namespace      string
+	NodeName       string
+	Ip             string
+	ReplicaSetName string
+	ReplicaSetUid  string
+	DeploymentName string
+	DeploymentUid  string
+	StatefulSet    string
+	DaemonSet      string
+	Job            string
+}
+
+// replicaSetOwner is the Deployment owning a ReplicaSet, empty for ReplicaSets without one.
+type replicaSetOwner struct {
+	deploymentName string
+	deploymentUid  string
+}
+
+// MetadataCache keeps the pods of this node in an informer cache. ReplicaSets cannot be watched by node, so
+// only the ones owning the pods of this node are fetched, once when their first pod is seen, and dropped with
+// their last pod. Their Deployment is taken from their owner reference. The fetches run from a workqueue, so that
+// a slow API server does not hold the pod events, and failed ones are retried with a backoff. Until then, pods
+// have no Deployment. Lookups never call the API server.
+type MetadataCache struct {
+	clientset        kubernetes.Interface
+	podIndexer       cache.Indexer
+	podLister        corev1listers.PodLister
+	replicaSetQueue  workqueue.RateLimitingInterface
+	replicaSetMutex  sync.RWMutex
+	replicaSetOwners map[string]replicaSetOwner
+	stopCh           chan struct{}
+}
+
+func NewMetadataCache(cfg config.K8sMetadataConfig) (*MetadataCache, error) {
+	clientset, err := utils.GetK8sClient()
+	if err != nil {
+		logger.Error(metadataCacheLogTag, "Error while creating k8s client ", err)
+		return nil, err
+	}
+	return NewMetadataCacheWithClient(cfg, clientset, os.Getenv("NODE_NAME"))
+}
+
+// NewMetadataCacheWithClient starts the informers and waits for their caches to sync. Pods are limited to
+// the given node, unless nodeName is empty.
+func NewMetadataCacheWithClient(cfg config.K8sMetadataConfig, clientset kubernetes.Interface, nodeName string) (*MetadataCache, error) {
+	if cfg.ResyncPeriod <= 0 {
+		cfg.ResyncPeriod = defaultResyncPeriod
+	}
+	if cfg.SyncTimeout <= 0 {
+		cfg.SyncTimeout = defaultSyncTimeout
+	}
+	resyncPeriod := time.Duration(cfg.ResyncPeriod) * time.Second
+
+	var podFactoryOptions []informers.SharedInformerOption
+	if len(nodeName) > 0 {
+		podFactoryOptions = append(podFactoryOptions, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", nodeName).String()
+		}))
+	} else {
+		logger.Warn(metadataCacheLogTag, "NODE_NAME is not set, watching the pods of all nodes")
+	}
+	podFactory := informers.NewSharedInformerFactoryWithOptions(clientset, resyncPeriod, podFactoryOptions...)
+	podInformer := podFactory.Core().V1().Pods()
+
+	if err := podInformer.Informer().SetTransform(stripManagedFields); err != nil {
+		return nil, err
+	}
+	err := podInformer.Informer().AddIndexers(cache.Indexers{
+		podIpIndex:         podIpIndexFunc,
+		podUidIndex:        podUidIndexFunc,
+		podReplicaSetIndex: podReplicaSetIndexFunc,
+	})
+	if err != nil {
+		logger.Error(metadataCacheLogTag, "Error while adding pod indexers ", err)
+		return nil, err
+	}
+
+	metadataCache := &MetadataCache{
+		clientset:        clientset,
+		podIndexer:       podInformer.Informer().GetIndexer(),
+		podLister:        podInformer.Lister(),
+		replicaSetQueue:  workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		replicaSetOwners: map[string]replicaSetOwner{},
+		stopCh:           make(chan struct{}),
+	}
+	registration, err := podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: metadataCache.queueReplicaSet,
+		UpdateFunc: func(_, newObj interface{}) {
+			metadataCache.queueReplicaSet(newObj)
+		},
+		DeleteFunc: metadataCache.releaseReplicaSet,
+	})
+	if err != nil {
+		logger.Error(metadataCacheLogTag, "Error while adding pod event handler ", err)
+		return nil, err
+	}
+	podFactory.Start(metadataCache.stopCh)
+	go metadataCache.resolveReplicaSets()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.SyncTimeout)*time.Second)
+	defer cancel()
+	synced := cache.WaitForCacheSync(ctx.Done(), podInformer.Informer().HasSynced, registration.HasSynced)
+	if !synced {
+		metadataCache.Stop()
+		err = fmt.Errorf("k8s informer caches did not sync within %d seconds", cfg.SyncTimeout)
+		logger.Error(metadataCacheLogTag, err)
+		return nil, err
+	}
+	logger.Info(metadataCacheLogTag, "K8s metadata cache synced")
+	return metadataCache, nil
+}
+
+func (c *MetadataCache) Stop() {
+	close(c.stopCh)
+	c.replicaSetQueue.ShutDown()
+}
+
+func (c *MetadataCache) GetPod(namespace string, podName string) (*PodMetadata, bool) {
+	pod, err := c.podLister.Pods(namespace).Get(podName)
+	if err != nil {
+		return nil, false
+	}
+	return c.podMetadata(pod), true
+}
+
+// GetPodByIp returns the pod with the given IP. Pods on the host network are not indexed, as they share
+// the IP of the node.
+func (c *MetadataCache) GetPodByIp(ip string) (*PodMetadata, bool) {
+	return c.getPodByIndex(podIpIndex, utils.NormalizeIP(ip))
+}
+
+func (c *MetadataCache) GetPodByUid(uid string) (*PodMetadata, bool) {
+	return c.getPodByIndex(podUidIndex, uid)
+}
+
+// EnrichResourceAttributes adds the k8s attributes of the pod which sent the spans. The pod is identified
+// by its uid, its name and namespace, or its IP, in that order. Attributes set by the SDK are kept.
+func (c *MetadataCache) EnrichResourceAttributes(attributes map[string]interface{}) {
+	if attributes == nil {
+		return
+	}
+
+	var pod *PodMetadata
+	var ok bool
+	if uid, isString := attributes[common.OTelResourceAttrPodUidKey].(string); isString && len(uid) > 0 {
+		pod, ok = c.GetPodByUid(uid)
+	}
+	if !ok {
+		podName, nameOk := attributes[common.OTelResourceAttrPodNameKey].(string)
+		namespace, namespaceOk := attributes[common.OTelResourceAttrNamespaceKey].(string)
+		if nameOk && namespaceOk {
+			pod, ok = c.GetPod(namespace, podName)
+		}
+	}
+	if !ok {
+		if ip, isString := attributes[common.OTelResourceAttrPodIpKey].(string); isString && len(ip) > 0 {
+			pod, ok = c.GetPodByIp(ip)
+		}
+	}
+	if !ok {
+		return
+	}
+
+	for key, value := range pod.Attributes() {
+		if _, exists := attributes[key]; !exists {
+			attributes[key] = value
+		}
+	}
+}
+
+// Attributes returns the non-empty metadata as k8s resource attributes.
+func (p *PodMetadata) Attributes() map[string]interface{} {
+	attributes := map[string]interface{}{}
+	for key, value := range map[string]string{
+		common.OTelResourceAttrPodNameKey:        p.Name,
+		common.OTelResourceAttrPodUidKey:         p.Uid,
+		common.OTelResourceAttrPodIpKey:          p.Ip,
+		common.OTelResourceAttrNamespaceKey:      p.Namespace,
+		common.OTelResourceAttrNodeNameKey:       p.NodeName,
+		common.OTelResourceAttrReplicaSetNameKey: p.ReplicaSetName,
+		common.OTelResourceAttrReplicaSetUidKey:  p.ReplicaSetUid,
+		common.OTelResourceAttrDeploymentNameKey: p.DeploymentName,
+		common.OTelResourceAttrDeploymentUidKey:  p.DeploymentUid,
+		common.OTelResourceAttrStatefulSetKey:    p.StatefulSet,
+		common.OTelResourceAttrDaemonSetKey:      p.DaemonSet,
+		common.OTelResourceAttrJobKey:            p.Job,
+	} {
+		if len(value) > 0 {
+			attributes[key] = value
+		}
+	}
+	return attributes
+}
+
+func (c *MetadataCache) getPodByIndex(indexName string, value string) (*PodMetadata, bool) {
+	objects, err := c.podIndexer.ByIndex(indexName, value)
+	if err != nil || len(objects) == 0 {
+		return nil, false
+	}
+
+	// An IP can still be held by a terminated pod when its replacement already got it.
+	pod := objects[0].(*corev1.Pod)
+	for _, object := range objects[1:] {
+		candidate := object.(*corev1.Pod)
+		if candidate.Status.Phase == corev1.PodRunning && (pod.Status.Phase != corev1.PodRunning || candidate.CreationTimestamp.After(pod.CreationTimestamp.Time)) {
+			pod = candidate
+		}
+	}
+	return c.podMetadata(pod), true
+}
+
+func (c *MetadataCache) podMetadata(pod *corev1.Pod) *PodMetadata {
+	metadata := PodMetadata{
+		Name:      pod.Name,
+		Uid:       string(pod.UID),
+		Namespace: pod.Namespace,
+		NodeName:  pod.Spec.NodeName,
+		Ip:        pod.Status.PodIP,
+	}
+
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return &metadata
+	}
+	switch owner.Kind {
+	case kindReplicaSet:
+		metadata.ReplicaSetName = owner.Name
+		metadata.ReplicaSetUid = string(owner.UID)
+		c.replicaSetMutex.RLock()
+		replicaSet, ok := c.replicaSetOwners[pod.Namespace+"/"+owner.Name]
+		c.replicaSetMutex.RUnlock()
+		if ok {
+			metadata.DeploymentName = replicaSet.deploymentName
+			metadata.DeploymentUid = replicaSet.deploymentUid
+		}
+	case kindStatefulSet:
+		metadata.StatefulSet = owner.Name
+	case kindDaemonSet:
+		metadata.DaemonSet = owner.Name
+	case kindJob:
+		metadata.Job = owner.Name
+	}
+	return &metadata
+}
+
+// queueReplicaSet queues the ReplicaSet owning the pod, the first time one of its pods is seen.
+func (c *MetadataCache) queueReplicaSet(obj interface{}) {
+	keys, _ := podReplicaSetIndexFunc(obj)
+	if len(keys) == 0 {
+		return
+	}
+	c.replicaSetMutex.RLock()
+	_, ok := c.replicaSetOwners[keys[0]]
+	c.replicaSetMutex.RUnlock()
+	if !ok {
+		c.replicaSetQueue.Add(keys[0])
+	}
+}
+
+// resolveReplicaSets fetches the queued ReplicaSets until the cache is stopped.
+func (c *MetadataCache) resolveReplicaSets() {
+	for {
+		key, shutdown := c.replicaSetQueue.Get()
+		if shutdown {
+			return
+		}
+		if err := c.resolveReplicaSet(key.(string)); err != nil {
+			logger.Debug(metadataCacheLogTag, "Could not get ReplicaSet ", key, ", retrying, error: ", err)
+			c.replicaSetQueue.AddRateLimited(key)
+		} else {
+			c.replicaSetQueue.Forget(key)
+		}
+		c.replicaSetQueue.Done(key)
+	}
+}
+
+// resolveReplicaSet fetches the ReplicaSet of the key, unless it is already known or none of the pods of this
+// node refer to it anymore.
+func (c *MetadataCache) resolveReplicaSet(key string) error {
+	c.replicaSetMutex.RLock()
+	_, ok := c.replicaSetOwners[key]
+	c.replicaSetMutex.RUnlock()
+	if ok {
+		return nil
+	}
+	if pods, err := c.podIndexer.ByIndex(podReplicaSetIndex, key); err != nil || len(pods) == 0 {
+		return nil
+	}
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	replicaSet, err := c.clientset.AppsV1().ReplicaSets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		// The pods of a deleted ReplicaSet queue it again if they are updated.
+		logger.Debug(metadataCacheLogTag, "ReplicaSet ", key, " not found")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	owner := replicaSetOwner{}
+	if deploymentOwner := metav1.GetControllerOf(replicaSet); deploymentOwner != nil && deploymentOwner.Kind == kindDeployment {
+		owner.deploymentName = deploymentOwner.Name
+		owner.deploymentUid = string(deploymentOwner.UID)
+	}
+	c.replicaSetMutex.Lock()
+	c.replicaSetOwners[key] = owner
+	c.replicaSetMutex.Unlock()
+	return nil
+}
+
+// releaseReplicaSet drops the ReplicaSet of a deleted pod once none of the pods of this node refer to it.
+func (c *MetadataCache) releaseReplicaSet(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	keys, _ := podReplicaSetIndexFunc(obj)
+	if len(keys) == 0 {
+		return
+	}
+	// The indexer is updated before the handlers are called, so the deleted pod is not in it anymore.
+	if pods, err := c.podIndexer.ByIndex(podReplicaSetIndex, keys[0]); err != nil || len(pods) > 0 {
+		return
+	}
+	c.replicaSetMutex.Lock()
+	delete(c.replicaSetOwners, keys[0])
+	c.replicaSetMutex.Unlock()
+}
+
+func podIpIndexFunc(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.HostNetwork {
+		return nil, nil
+	}
+	var ips []string
+	for _, podIp := range pod.Status.PodIPs {
+		if len(podIp.IP) > 0 {
+			ips = append(ips, utils.NormalizeIP(podIp.IP))
+		}
+	}
+	if len(ips) == 0 && len(pod.Status.PodIP) > 0 {
+		ips = append(ips, utils.NormalizeIP(pod.Status.PodIP))
+	}
+	return ips, nil
+}
+
+func podUidIndexFunc(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, nil
+	}
+	return []string{string(pod.UID)}, nil
+}
+
+// podReplicaSetIndexFunc indexes pods by namespace/name of the ReplicaSet controlling them.
+func podReplicaSetIndexFunc(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, nil
+	}
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil || owner.Kind != kindReplicaSet {
+		return nil, nil
+	}
+	return []string{pod.Namespace + "/" + owner.Name}, nil
+}
+
+// stripManagedFields reduces the memory held by the caches.
+func stripManagedFields(obj interface{}) (interface{}, error) {
+	if accessor, ok := obj.(metav1.Object); ok {
+		accessor.SetManagedFields(nil)
+	}
+	return obj, nil
+}
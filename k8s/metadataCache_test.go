@@ -0,0 +1,181 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"github.com/zerok-ai/zk-observer/common"
+	"github.com/zerok-ai/zk-observer/config"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"testing"
+	"time"
+)
+
+func controllerRef(kind string, name string, uid string) []metav1.OwnerReference {
+	controller := true
+	return []metav1.OwnerReference{{Kind: kind, Name: name, UID: types.UID(uid), Controller: &controller}}
+}
+
+func newPod(name string, nodeName string, ip string, owners []metav1.OwnerReference) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop", UID: types.UID(name + "-uid"), OwnerReferences: owners},
+		Spec:       corev1.PodSpec{NodeName: nodeName},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning, PodIP: ip, PodIPs: []corev1.PodIP{{IP: ip}}},
+	}
+}
+
+func newReplicaSet(name string) *appsv1.ReplicaSet {
+	return &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name:            name,
+		Namespace:       "shop",
+		UID:             types.UID(name + "-uid"),
+		OwnerReferences: controllerRef(kindDeployment, "cart", "cart-uid"),
+	}}
+}
+
+func newTestCache(t *testing.T, clientset *fake.Clientset, nodeName string) *MetadataCache {
+	t.Helper()
+	metadataCache, err := NewMetadataCacheWithClient(config.K8sMetadataConfig{SyncTimeout: 5}, clientset, nodeName)
+	if err != nil {
+		t.Fatalf("NewMetadataCacheWithClient() error = %v", err)
+	}
+	t.Cleanup(metadataCache.Stop)
+	return metadataCache
+}
+
+func waitFor(t *testing.T, description string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", description)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPodOwnersAreResolved(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		newPod("cart-7d9f-abcde", "node-a", "10.0.0.5", controllerRef(kindReplicaSet, "cart-7d9f", "cart-7d9f-uid")),
+		newReplicaSet("cart-7d9f"),
+		newPod("db-0", "node-a", "10.0.0.6", controllerRef(kindStatefulSet, "db", "db-uid")),
+	)
+	metadataCache := newTestCache(t, clientset, "")
+	waitFor(t, "the ReplicaSet", func() bool {
+		metadata, ok := metadataCache.GetPod("shop", "cart-7d9f-abcde")
+		return ok && len(metadata.DeploymentName) > 0
+	})
+
+	attributes := map[string]interface{}{
+		common.OTelResourceAttrPodNameKey:   "cart-7d9f-abcde",
+		common.OTelResourceAttrNamespaceKey: "shop",
+	}
+	metadataCache.EnrichResourceAttributes(attributes)
+	expected := map[string]interface{}{
+		common.OTelResourceAttrPodIpKey:          "10.0.0.5",
+		common.OTelResourceAttrNodeNameKey:       "node-a",
+		common.OTelResourceAttrReplicaSetNameKey: "cart-7d9f",
+		common.OTelResourceAttrReplicaSetUidKey:  "cart-7d9f-uid",
+		common.OTelResourceAttrDeploymentNameKey: "cart",
+		common.OTelResourceAttrDeploymentUidKey:  "cart-uid",
+	}
+	for key, value := range expected {
+		if attributes[key] != value {
+			t.Errorf("attribute %s = %v, want %v", key, attributes[key], value)
+		}
+	}
+
+	pod, ok := metadataCache.GetPodByIp("::ffff:10.0.0.6")
+	if !ok || pod.StatefulSet != "db" || len(pod.DeploymentName) > 0 {
+		t.Errorf("GetPodByIp() = %+v, %v, want the db-0 pod of the db StatefulSet", pod, ok)
+	}
+}
+
+func TestPodsAreScopedToNode(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	var podListSelectors []string
+	clientset.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		podListSelectors = append(podListSelectors, action.(k8stesting.ListAction).GetListRestrictions().Fields.String())
+		return false, nil, nil
+	})
+	var replicaSetVerbs []string
+	clientset.PrependReactor("*", "replicasets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		replicaSetVerbs = append(replicaSetVerbs, action.GetVerb())
+		return false, nil, nil
+	})
+	newTestCache(t, clientset, "node-a")
+
+	if len(podListSelectors) == 0 || podListSelectors[0] != "spec.nodeName=node-a" {
+		t.Errorf("pod list field selectors = %v, want spec.nodeName=node-a", podListSelectors)
+	}
+	if len(replicaSetVerbs) > 0 {
+		t.Errorf("ReplicaSets were requested without pods: %v", replicaSetVerbs)
+	}
+}
+
+func TestInformerUpdates(t *testing.T) {
+	clientset := fake.NewSimpleClientset(newReplicaSet("cart-7d9f"))
+	metadataCache := newTestCache(t, clientset, "")
+	pods := clientset.CoreV1().Pods("shop")
+	ctx := context.Background()
+
+	pod := newPod("cart-7d9f-abcde", "node-a", "10.0.0.5", controllerRef(kindReplicaSet, "cart-7d9f", "cart-7d9f-uid"))
+	if _, err := pods.Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the created pod", func() bool {
+		metadata, ok := metadataCache.GetPodByUid("cart-7d9f-abcde-uid")
+		return ok && metadata.DeploymentName == "cart"
+	})
+
+	pod.Status.PodIP = "10.0.0.7"
+	pod.Status.PodIPs = []corev1.PodIP{{IP: "10.0.0.7"}}
+	if _, err := pods.UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the new pod IP", func() bool {
+		_, found := metadataCache.GetPodByIp("10.0.0.7")
+		_, stale := metadataCache.GetPodByIp("10.0.0.5")
+		return found && !stale
+	})
+
+	if err := pods.Delete(ctx, pod.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the deleted pod and its ReplicaSet", func() bool {
+		_, found := metadataCache.GetPodByUid("cart-7d9f-abcde-uid")
+		metadataCache.replicaSetMutex.RLock()
+		defer metadataCache.replicaSetMutex.RUnlock()
+		return !found && len(metadataCache.replicaSetOwners) == 0
+	})
+}
+
+func TestReplicaSetFetchesAreRetried(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		newPod("cart-7d9f-abcde", "node-a", "10.0.0.5", controllerRef(kindReplicaSet, "cart-7d9f", "cart-7d9f-uid")),
+		newReplicaSet("cart-7d9f"),
+	)
+	failures := 2
+	clientset.PrependReactor("get", "replicasets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if failures > 0 {
+			failures--
+			return true, nil, errors.New("api server unavailable")
+		}
+		return false, nil, nil
+	})
+	metadataCache := newTestCache(t, clientset, "")
+
+	// The pod is cached while its ReplicaSet is fetched.
+	if _, ok := metadataCache.GetPodByUid("cart-7d9f-abcde-uid"); !ok {
+		t.Errorf("GetPodByUid() did not find the pod")
+	}
+	waitFor(t, "the retried ReplicaSet", func() bool {
+		metadata, ok := metadataCache.GetPodByUid("cart-7d9f-abcde-uid")
+		return ok && metadata.DeploymentName == "cart"
+	})
+}
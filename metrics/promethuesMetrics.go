@@ -48,6 +48,158 @@ var (
 		Help: "Total spans filtered by the receiver.",
 	},
 		[]string{"podIp"})
+
+	// ExporterSpansSent is the total number of spans sent to a downstream OTLP exporter.
+	ExporterSpansSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zerok_receiver_exporter_spans_sent_total",
+		Help: "Total spans sent to the downstream exporter.",
+	},
+		[]string{"exporter"})
+
+	// ExporterSpansFailed is the total number of spans which could not be sent to a downstream OTLP exporter.
+	ExporterSpansFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zerok_receiver_exporter_spans_failed_total",
+		Help: "Total spans dropped after failing to send them to the downstream exporter.",
+	},
+		[]string{"exporter"})
+
+	// ExporterSpansQueued is the number of spans waiting to be sent to a downstream OTLP exporter.
+	ExporterSpansQueued = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "zerok_receiver_exporter_spans_queued",
+		Help: "Spans queued or waiting for a retry for the downstream exporter.",
+	},
+		[]string{"exporter"})
+
+	// TailSamplingTraces is the total number of tail sampling decisions by the reason of the decision.
+	TailSamplingTraces = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zerok_receiver_tail_sampling_traces_total",
+		Help: "Total traces decided by the tail sampler.",
+	},
+		[]string{"podIp", "decision"})
+
+	// TailSamplingSpansDropped is the total number of spans dropped by the tail sampler.
+	TailSamplingSpansDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zerok_receiver_tail_sampling_spans_dropped_total",
+		Help: "Total spans dropped by the tail sampler before being stored.",
+	},
+		[]string{"podIp"})
+
+	// HeadSamplingSpansDropped is the total number of spans sampled out at ingest per service and sampler.
+	HeadSamplingSpansDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zerok_receiver_head_sampling_spans_dropped_total",
+		Help: "Total spans sampled out by the head samplers.",
+	},
+		[]string{"service", "sampler"})
+
+	// DnsCacheHits is the total number of peer name lookups answered from the DNS cache, negative entries included.
+	DnsCacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zerok_receiver_dns_cache_hits_total",
+		Help: "Total peer name lookups answered from the DNS cache.",
+	},
+		[]string{"podIp"})
+
+	// DnsCacheMisses is the total number of peer name lookups which needed a DNS query.
+	DnsCacheMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zerok_receiver_dns_cache_misses_total",
+		Help: "Total peer name lookups which needed a DNS query.",
+	},
+		[]string{"podIp"})
+
+	// The service graph metrics follow the names of Tempo's service graph processor.
+
+	// ServiceGraphRequests is the total number of requests between two services.
+	ServiceGraphRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "traces_service_graph_request_total",
+		Help: "Total count of requests between two nodes.",
+	},
+		[]string{"client", "server", "connection_type"})
+
+	// ServiceGraphRequestsFailed is the total number of failed requests between two services.
+	ServiceGraphRequestsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "traces_service_graph_request_failed_total",
+		Help: "Total count of failed requests between two nodes.",
+	},
+		[]string{"client", "server", "connection_type"})
+
+	// ServiceGraphRequestServerSeconds is the duration of requests between two services seen by the server.
+	ServiceGraphRequestServerSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "traces_service_graph_request_server_seconds",
+		Help:    "Time for a request between two nodes as seen from the server.",
+		Buckets: prometheus.ExponentialBuckets(0.002, 2, 14),
+	},
+		[]string{"client", "server", "connection_type"})
+
+	// ServiceGraphRequestClientSeconds is the duration of requests between two services seen by the client.
+	ServiceGraphRequestClientSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "traces_service_graph_request_client_seconds",
+		Help:    "Time for a request between two nodes as seen from the client.",
+		Buckets: prometheus.ExponentialBuckets(0.002, 2, 14),
+	},
+		[]string{"client", "server", "connection_type"})
+
+	// ServiceGraphUnpairedSpans is the total number of client and server spans which expired without a pair.
+	ServiceGraphUnpairedSpans = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "traces_service_graph_unpaired_spans_total",
+		Help: "Total count of unpaired spans.",
+	},
+		[]string{"client", "server"})
+
+	// ServiceGraphDroppedSpans is the total number of spans not paired as the pending edges were at capacity.
+	ServiceGraphDroppedSpans = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "traces_service_graph_dropped_spans_total",
+		Help: "Total count of spans dropped as the pending edges were at capacity.",
+	},
+		[]string{"client", "server"})
+
+	// ScenarioFileScenarios is the number of scenarios loaded from the scenario directory.
+	ScenarioFileScenarios = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "zerok_receiver_file_scenarios",
+		Help: "Number of scenarios loaded from the scenario directory.",
+	})
+
+	// ScenarioFileErrors is the total number of failed loads of scenario files.
+	ScenarioFileErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zerok_receiver_file_scenario_errors_total",
+		Help: "Total count of scenario files which failed to load.",
+	},
+		[]string{"file"})
+
+	// ScenarioWorkloadEvaluations is the total number of spans evaluated by a workload rule.
+	ScenarioWorkloadEvaluations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zerok_receiver_workload_evaluations_total",
+		Help: "Total count of spans evaluated by a workload rule.",
+	},
+		[]string{"scenario", "workload"})
+
+	// ScenarioWorkloadMatches is the total number of spans matched by a workload rule.
+	ScenarioWorkloadMatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zerok_receiver_workload_matches_total",
+		Help: "Total count of spans matched by a workload rule.",
+	},
+		[]string{"scenario", "workload"})
+
+	// ScenarioWorkloadErrors is the total number of failed evaluations of a workload rule, by error class.
+	ScenarioWorkloadErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zerok_receiver_workload_evaluation_errors_total",
+		Help: "Total count of failed evaluations of a workload rule.",
+	},
+		[]string{"scenario", "workload", "class"})
+
+	// ScenarioWorkloadCaptureDrops is the total number of matched trace ids not captured for a workload by the per pod
+	// capture limits, by reason.
+	ScenarioWorkloadCaptureDrops = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zerok_receiver_workload_capture_drops_total",
+		Help: "Total count of matched trace ids not captured for a workload by the capture limits of this pod.",
+	},
+		[]string{"scenario", "workload", "reason"})
+
+	// ScenarioWorkloadEvaluationSeconds is the duration of the evaluation of a workload rule on a span.
+	ScenarioWorkloadEvaluationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "zerok_receiver_workload_evaluation_seconds",
+		Help:    "Time to evaluate a workload rule on a span.",
+		Buckets: prometheus.ExponentialBuckets(0.000005, 4, 10),
+	},
+		[]string{"scenario", "workload"})
 )
 
 func BadgerCollector(namespace string) prometheus.Collector {
@@ -2,7 +2,9 @@ package model
 
 import (
 	"github.com/zerok-ai/zk-observer/common"
+	"github.com/zerok-ai/zk-observer/proto/zkspan"
 	zkUtilsCommonModel "github.com/zerok-ai/zk-utils-go/common"
+	"github.com/zerok-ai/zk-utils-go/proto/enrichedSpan"
 )
 
 func GenericMapPtrFromMap(inputMap map[string]interface{}) *zkUtilsCommonModel.GenericMap {
@@ -18,6 +20,8 @@ type OTelSpanDetails struct {
 	LatencyNs     uint64          `json:"latency_ns"`
 	SchemaVersion string          `json:"schema_version"`
 	Errors        []SpanErrorInfo `json:"errors,omitempty"`
+	// ErrorReason is the type of the most telling error of the span, empty when it has no errors.
+	ErrorReason ErrorType `json:"error_reason,omitempty"`
 
 	// Span Attributes
 	SpanAttributes     *zkUtilsCommonModel.GenericMap `json:"attributes,omitempty"`
@@ -31,7 +35,8 @@ type OTelSpanDetails struct {
 	ServiceName string `json:"service_name"`
 	SpanName    string `json:"span_name"`
 
-	Protocol ProtocolType `json:"protocol"`
+	Protocol     ProtocolType `json:"protocol"`
+	ProtocolRule string       `json:"protocol_rule,omitempty"`
 
 	// Network span properties
 	SourceIp    *string `json:"source_ip,omitempty"`
@@ -48,6 +53,10 @@ type OTelSpanDetails struct {
 	Status   *float64 `json:"status,omitempty"`
 	Username *string  `json:"username,omitempty"`
 
+	// DB and messaging properties.
+	ServerAddress *string `json:"server_address,omitempty"`
+	MessageId     *string `json:"message_id,omitempty"`
+
 	// ZeroK Properties
 	WorkloadIdList []string                      `json:"workload_id_list,omitempty"`
 	GroupBy        zkUtilsCommonModel.GroupByMap `json:"group_by,omitempty"`
@@ -58,21 +67,26 @@ type SpanErrorInfo struct {
 	ErrorType     ErrorType `json:"error_type"`
 	ExceptionType string    `json:"exception_type"`
 	Hash          string    `json:"hash"`
+	GroupHash     string    `json:"group_hash,omitempty"`
 }
 
 type ErrorType string
 
 const (
-	ErrorTypeException ErrorType = "exception"
+	ErrorTypeException  ErrorType = "exception"
+	ErrorTypeSpanStatus ErrorType = "span_status"
+	ErrorTypeHttpStatus ErrorType = "http_status"
+	ErrorTypeGrpcStatus ErrorType = "grpc_status"
 )
 
 type ProtocolType string
 
 const (
-	ProtocolTypeHTTP    ProtocolType = "HTTP"
-	ProtocolTypeDB      ProtocolType = "DB"
-	ProtocolTypeGRPC    ProtocolType = "GRPC"
-	ProtocolTypeUnknown ProtocolType = "UNKNOWN"
+	ProtocolTypeHTTP      ProtocolType = "HTTP"
+	ProtocolTypeDB        ProtocolType = "DB"
+	ProtocolTypeGRPC      ProtocolType = "GRPC"
+	ProtocolTypeMessaging ProtocolType = "MESSAGING"
+	ProtocolTypeUnknown   ProtocolType = "UNKNOWN"
 )
 
 func (s *OTelSpanDetails) SetParentSpanId(parentSpanId string) {
@@ -91,3 +105,63 @@ func (s *OTelSpanDetails) GetResourceIP() string {
 	}
 	return ""
 }
+
+// ToProto converts the span details to the ZkSpan stored for the zk storage format. Resource and scope
+// attributes are stored separately and only referenced by their hashes.
+func (s *OTelSpanDetails) ToProto(traceId string, spanId string) *zkspan.ZkSpan {
+	zkSpan := &zkspan.ZkSpan{
+		TraceId:                traceId,
+		SpanId:                 spanId,
+		ParentSpanId:           s.ParentSpanId,
+		SpanKind:               string(s.SpanKind),
+		StartNs:                s.StartNs,
+		LatencyNs:              s.LatencyNs,
+		SchemaVersion:          s.SchemaVersion,
+		ResourceAttributesHash: s.ResourceAttributesHash,
+		ScopeAttributesHash:    s.ScopeAttributesHash,
+		ServiceName:            s.ServiceName,
+		SpanName:               s.SpanName,
+		Protocol:               string(s.Protocol),
+		ProtocolRule:           s.ProtocolRule,
+		SourceIp:               valueOrEmpty(s.SourceIp),
+		Source:                 valueOrEmpty(s.Source),
+		DestinationIp:          valueOrEmpty(s.DestIp),
+		Destination:            valueOrEmpty(s.Destination),
+		Method:                 valueOrEmpty(s.Method),
+		Route:                  valueOrEmpty(s.Route),
+		Scheme:                 valueOrEmpty(s.Scheme),
+		Path:                   valueOrEmpty(s.Path),
+		Query:                  valueOrEmpty(s.Query),
+		Status:                 valueOrEmpty(s.Status),
+		Username:               valueOrEmpty(s.Username),
+		ServerAddress:          valueOrEmpty(s.ServerAddress),
+		MessageId:              valueOrEmpty(s.MessageId),
+		WorkloadIdList:         s.WorkloadIdList,
+		ErrorReason:            string(s.ErrorReason),
+	}
+
+	for _, spanError := range s.Errors {
+		zkSpan.Errors = append(zkSpan.Errors, &zkspan.SpanError{
+			Message:       spanError.Message,
+			ErrorType:     string(spanError.ErrorType),
+			ExceptionType: spanError.ExceptionType,
+			Hash:          spanError.Hash,
+			GroupHash:     spanError.GroupHash,
+		})
+	}
+	if s.SpanAttributes != nil {
+		zkSpan.SpanAttributes = enrichedSpan.ConvertMapToKVList(*s.SpanAttributes).KeyValueList
+	}
+	if len(s.GroupBy) > 0 {
+		zkSpan.GroupBy = enrichedSpan.ConvertGroupByMapToKVList(s.GroupBy).KeyValueList
+	}
+	return zkSpan
+}
+
+func valueOrEmpty[T any](value *T) T {
+	if value == nil {
+		var empty T
+		return empty
+	}
+	return *value
+}
@@ -4,4 +4,47 @@ type ExceptionDetails struct {
 	Message    string `json:"message"`
 	Stacktrace string `json:"stacktrace"`
 	Type       string `json:"type"`
+	// GroupHash is shared by the exceptions which differ only in variable message tokens, line numbers or
+	// generated names.
+	GroupHash string `json:"group_hash,omitempty"`
+}
+
+// ExceptionGroup is the normalized form of the exceptions sharing a group hash.
+type ExceptionGroup struct {
+	GroupHash       string       `json:"group_hash"`
+	Type            string       `json:"type"`
+	MessageTemplate string       `json:"message_template"`
+	Language        string       `json:"language,omitempty"`
+	Frames          []StackFrame `json:"frames,omitempty"`
+}
+
+type StackFrame struct {
+	Module   string `json:"module,omitempty"`
+	Function string `json:"function,omitempty"`
+	File     string `json:"file,omitempty"`
+}
+
+// ExceptionSummary holds the occurrences of an exception group seen by all observer pods.
+type ExceptionSummary struct {
+	GroupHash       string   `json:"group_hash"`
+	Type            string   `json:"type"`
+	MessageTemplate string   `json:"message_template"`
+	Count           int64    `json:"count"`
+	FirstSeen       int64    `json:"first_seen"`
+	LastSeen        int64    `json:"last_seen"`
+	Services        []string `json:"services"`
+}
+
+// ExceptionOccurrences adds the group and the latest samples to the summary of an exception group.
+type ExceptionOccurrences struct {
+	ExceptionSummary
+	Group   *ExceptionGroup   `json:"group,omitempty"`
+	Samples []ExceptionSample `json:"samples"`
+}
+
+type ExceptionSample struct {
+	TraceId     string `json:"trace_id"`
+	SpanId      string `json:"span_id"`
+	ServiceName string `json:"service_name"`
+	Timestamp   int64  `json:"timestamp"`
 }
@@ -0,0 +1,47 @@
+package model
+
+// ScenarioEvaluation is the result of a dry run of a scenario on a set of spans.
+type ScenarioEvaluation struct {
+	ScenarioId string           `json:"scenario_id"`
+	Spans      []SpanEvaluation `json:"spans"`
+}
+
+// SpanEvaluation holds the workloads matched by a span and the group by values of the scenario for it.
+type SpanEvaluation struct {
+	TraceId          string               `json:"trace_id"`
+	SpanId           string               `json:"span_id"`
+	ServiceName      string               `json:"service_name"`
+	SpanName         string               `json:"span_name"`
+	MatchedWorkloads []string             `json:"matched_workloads"`
+	GroupBy          []GroupByEvaluation  `json:"group_by,omitempty"`
+	Workloads        []WorkloadEvaluation `json:"workloads"`
+}
+
+type GroupByEvaluation struct {
+	WorkloadId string `json:"workload_id"`
+	Title      string `json:"title"`
+	Hash       string `json:"hash"`
+}
+
+// WorkloadEvaluation explains the result of a workload. Workloads which are not evaluated for the span have a
+// skip reason and no rule.
+type WorkloadEvaluation struct {
+	WorkloadId string          `json:"workload_id"`
+	Matched    bool            `json:"matched"`
+	SkipReason string          `json:"skip_reason,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Rule       *RuleEvaluation `json:"rule,omitempty"`
+}
+
+// RuleEvaluation is the result of a rule, with the results of its rules for rule groups.
+type RuleEvaluation struct {
+	Type      string           `json:"type"`
+	Condition string           `json:"condition,omitempty"`
+	Id        string           `json:"id,omitempty"`
+	Field     string           `json:"field,omitempty"`
+	Operator  string           `json:"operator,omitempty"`
+	Value     string           `json:"value,omitempty"`
+	Passed    bool             `json:"passed"`
+	Error     string           `json:"error,omitempty"`
+	Rules     []RuleEvaluation `json:"rules,omitempty"`
+}
@@ -0,0 +1,26 @@
+package model
+
+// Sources of the loaded scenarios.
+const (
+	ScenarioSourceRedis = "redis"
+	ScenarioSourceFile  = "file"
+)
+
+// ScenarioStatus is a loaded scenario, with the unix time in seconds of its last match, or 0 when it did not
+// match since the receiver started.
+type ScenarioStatus struct {
+	ScenarioId string           `json:"scenario_id"`
+	Title      string           `json:"title"`
+	Version    string           `json:"version"`
+	Enabled    bool             `json:"enabled"`
+	Source     string           `json:"source"`
+	LastMatch  int64            `json:"last_match"`
+	Workloads  []WorkloadStatus `json:"workloads"`
+}
+
+type WorkloadStatus struct {
+	WorkloadId string `json:"workload_id"`
+	Service    string `json:"service,omitempty"`
+	Executor   string `json:"executor"`
+	LastMatch  int64  `json:"last_match"`
+}
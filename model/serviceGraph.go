@@ -0,0 +1,142 @@
+package model
+
+const (
+	ServiceGraphConnectionTypeMessaging   = "messaging_system"
+	ServiceGraphConnectionTypeVirtualNode = "virtual_node"
+
+	// ServiceGraphUserNode is the caller of root server spans.
+	ServiceGraphUserNode = "user"
+)
+
+// ServiceGraphLatencyBucketsMs are the upper bounds of the latency buckets kept per edge. The last bucket
+// counts everything above the last bound.
+var ServiceGraphLatencyBucketsMs = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// ServiceGraphEdge holds the requests from a client service to a server service in one time bucket.
+type ServiceGraphEdge struct {
+	Client         string
+	Server         string
+	ConnectionType string
+	Requests       int64
+	Failed         int64
+	LatencySumUs   int64
+	// LatencyCounts has one count per bucket of ServiceGraphLatencyBucketsMs, plus the overflow bucket.
+	LatencyCounts []int64
+}
+
+// ServiceGraphEdgeSide is the client or server span of a request which found no pair on the observer pod it
+// reached. It is shared through the edge store, as the spans of a request reach different nodes.
+type ServiceGraphEdgeSide struct {
+	// Key identifies the request by trace id and client span id.
+	Key            string `json:"-"`
+	IsClient       bool   `json:"-"`
+	Service        string `json:"service"`
+	ConnectionType string `json:"connection_type,omitempty"`
+	LatencyUs      int64  `json:"latency_us"`
+	Failed         bool   `json:"failed,omitempty"`
+	Peer           string `json:"peer,omitempty"`
+}
+
+type ServiceDependency struct {
+	Client         string  `json:"client"`
+	Server         string  `json:"server"`
+	ConnectionType string  `json:"connection_type,omitempty"`
+	Requests       int64   `json:"requests"`
+	Failed         int64   `json:"failed"`
+	ErrorRate      float64 `json:"error_rate"`
+	AvgLatencyMs   float64 `json:"avg_latency_ms"`
+	P50LatencyMs   float64 `json:"p50_latency_ms"`
+	P95LatencyMs   float64 `json:"p95_latency_ms"`
+	P99LatencyMs   float64 `json:"p99_latency_ms"`
+}
+
+func NewServiceGraphEdge(client string, server string, connectionType string) *ServiceGraphEdge {
+	return &ServiceGraphEdge{
+		Client:         client,
+		Server:         server,
+		ConnectionType: connectionType,
+		LatencyCounts:  make([]int64, len(ServiceGraphLatencyBucketsMs)+1),
+	}
+}
+
+// EdgeId identifies the edge across time buckets.
+func (e *ServiceGraphEdge) EdgeId() string {
+	return e.Client + "|" + e.Server + "|" + e.ConnectionType
+}
+
+func (e *ServiceGraphEdge) AddRequest(latencyUs int64, failed bool) {
+	e.Requests++
+	if failed {
+		e.Failed++
+	}
+	e.LatencySumUs += latencyUs
+	latencyMs := float64(latencyUs) / 1000
+	bucket := len(ServiceGraphLatencyBucketsMs)
+	for i, bound := range ServiceGraphLatencyBucketsMs {
+		if latencyMs <= bound {
+			bucket = i
+			break
+		}
+	}
+	e.LatencyCounts[bucket]++
+}
+
+func (e *ServiceGraphEdge) Merge(other *ServiceGraphEdge) {
+	e.Requests += other.Requests
+	e.Failed += other.Failed
+	e.LatencySumUs += other.LatencySumUs
+	for i := range e.LatencyCounts {
+		if i < len(other.LatencyCounts) {
+			e.LatencyCounts[i] += other.LatencyCounts[i]
+		}
+	}
+}
+
+func (e *ServiceGraphEdge) ToDependency() ServiceDependency {
+	dependency := ServiceDependency{
+		Client:         e.Client,
+		Server:         e.Server,
+		ConnectionType: e.ConnectionType,
+		Requests:       e.Requests,
+		Failed:         e.Failed,
+	}
+	if e.Requests > 0 {
+		dependency.ErrorRate = float64(e.Failed) / float64(e.Requests)
+		dependency.AvgLatencyMs = float64(e.LatencySumUs) / 1000 / float64(e.Requests)
+	}
+	dependency.P50LatencyMs = e.latencyPercentileMs(0.5)
+	dependency.P95LatencyMs = e.latencyPercentileMs(0.95)
+	dependency.P99LatencyMs = e.latencyPercentileMs(0.99)
+	return dependency
+}
+
+// latencyPercentileMs interpolates the percentile linearly within its bucket. Percentiles in the overflow
+// bucket are reported as the last bound.
+func (e *ServiceGraphEdge) latencyPercentileMs(quantile float64) float64 {
+	var total int64
+	for _, count := range e.LatencyCounts {
+		total += count
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := quantile * float64(total)
+	var cumulative int64
+	for i, count := range e.LatencyCounts {
+		if count == 0 || float64(cumulative+count) < rank {
+			cumulative += count
+			continue
+		}
+		if i == len(ServiceGraphLatencyBucketsMs) {
+			break
+		}
+		lowerBound := 0.0
+		if i > 0 {
+			lowerBound = ServiceGraphLatencyBucketsMs[i-1]
+		}
+		upperBound := ServiceGraphLatencyBucketsMs[i]
+		return lowerBound + (upperBound-lowerBound)*(rank-float64(cumulative))/float64(count)
+	}
+	return ServiceGraphLatencyBucketsMs[len(ServiceGraphLatencyBucketsMs)-1]
+}
@@ -0,0 +1,14 @@
+package model
+
+type ServiceMetadata struct {
+	ServiceName    string  `json:"service_name"`
+	ServiceVersion string  `json:"service_version,omitempty"`
+	Namespace      string  `json:"namespace,omitempty"`
+	Deployment     string  `json:"deployment,omitempty"`
+	SdkLanguage    string  `json:"sdk_language,omitempty"`
+	SdkName        string  `json:"sdk_name,omitempty"`
+	SdkVersion     string  `json:"sdk_version,omitempty"`
+	FirstSeen      int64   `json:"first_seen"`
+	LastSeen       int64   `json:"last_seen"`
+	SpanRate       float64 `json:"span_rate"`
+}
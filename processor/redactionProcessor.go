@@ -0,0 +1,355 @@
+package processor
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/zerok-ai/zk-observer/common"
+	"github.com/zerok-ai/zk-observer/config"
+	"github.com/zerok-ai/zk-observer/model"
+	"github.com/zerok-ai/zk-observer/utils"
+	zkUtilsCommonModel "github.com/zerok-ai/zk-utils-go/common"
+	logger "github.com/zerok-ai/zk-utils-go/logs"
+	commonv1 "go.opentelemetry.io/proto/otlp/common/v1"
+	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
+	"maps"
+	"path"
+	"regexp"
+)
+
+var redactionLogTag = "RedactionProcessor"
+
+const defaultMaskValue = "****"
+
+// builtinMaskPatterns can be referred to by name in maskPatterns. Any other entry is compiled as a regex.
+var builtinMaskPatterns = map[string]string{
+	"email":        `[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}`,
+	"credit_card":  `\b(?:\d[ \-]?){12,18}\d\b`,
+	"jwt":          `eyJ[a-zA-Z0-9_\-]+\.[a-zA-Z0-9_\-]+\.[a-zA-Z0-9_\-]+`,
+	"bearer_token": `(?i)bearer\s+[a-zA-Z0-9._~+/\-]+=*`,
+	"basic_auth":   `(?i)basic\s+[a-zA-Z0-9+/]+=*`,
+	"ipv4":         `\b(?:\d{1,3}\.){3}\d{1,3}\b`,
+}
+
+// exceptionKeys are the attributes of exceptions which are kept by the event allow-lists.
+var exceptionKeys = []string{common.OTelExceptionMessageKey, common.OTelExceptionTypeKey, common.OTelExceptionStacktraceKey}
+
+// attributeRedactor applies the redaction rules of one attribute level.
+type attributeRedactor struct {
+	allowKeys []string
+	// exemptKeys are kept even when allowKeys does not match them.
+	exemptKeys   []string
+	denyKeys     []string
+	hashKeys     []string
+	hashKey      []byte
+	maskPatterns []*regexp.Regexp
+	maskValue    string
+}
+
+// RedactionProcessor removes, masks or hashes attribute values before they are stored or exported. The rules
+// apply separately to resource, scope, span and event attributes. Exceptions use the event rules, as they are
+// recorded as span events, except that the allow-list does not remove their message, type and stacktrace.
+// Hashed values are HMAC-SHA256 with the configured hash key, so that they cannot be reversed with a dictionary
+// of the possible values.
+type RedactionProcessor struct {
+	enabled   bool
+	resource  *attributeRedactor
+	scope     *attributeRedactor
+	span      *attributeRedactor
+	event     *attributeRedactor
+	exception *attributeRedactor
+}
+
+func NewRedactionProcessor(cfg config.RedactionConfig) (*RedactionProcessor, error) {
+	processor := RedactionProcessor{enabled: cfg.Enabled}
+	if !cfg.Enabled {
+		return &processor, nil
+	}
+
+	maskValue := cfg.MaskValue
+	if len(maskValue) == 0 {
+		maskValue = defaultMaskValue
+	}
+	hashKey := []byte(cfg.HashKey)
+	for _, rules := range []config.AttributeRedactionRules{cfg.Resource, cfg.Scope, cfg.Span, cfg.Event} {
+		if len(rules.HashKeys) > 0 && len(hashKey) == 0 {
+			err := errors.New("hashKeys need a hashKey")
+			logger.Error(redactionLogTag, "Invalid redaction rules: ", err)
+			return nil, err
+		}
+	}
+
+	var err error
+	if processor.resource, err = newAttributeRedactor(cfg.Resource, maskValue, hashKey); err != nil {
+		logger.Error(redactionLogTag, "Invalid resource redaction rules: ", err)
+		return nil, err
+	}
+	if processor.scope, err = newAttributeRedactor(cfg.Scope, maskValue, hashKey); err != nil {
+		logger.Error(redactionLogTag, "Invalid scope redaction rules: ", err)
+		return nil, err
+	}
+	if processor.span, err = newAttributeRedactor(cfg.Span, maskValue, hashKey); err != nil {
+		logger.Error(redactionLogTag, "Invalid span redaction rules: ", err)
+		return nil, err
+	}
+	if processor.event, err = newAttributeRedactor(cfg.Event, maskValue, hashKey); err != nil {
+		logger.Error(redactionLogTag, "Invalid event redaction rules: ", err)
+		return nil, err
+	}
+	exceptionRedactor := *processor.event
+	exceptionRedactor.exemptKeys = exceptionKeys
+	processor.exception = &exceptionRedactor
+	return &processor, nil
+}
+
+func newAttributeRedactor(rules config.AttributeRedactionRules, maskValue string, hashKey []byte) (*attributeRedactor, error) {
+	for _, keys := range [][]string{rules.AllowKeys, rules.DenyKeys, rules.HashKeys} {
+		for _, key := range keys {
+			if _, err := path.Match(key, ""); err != nil {
+				return nil, fmt.Errorf("invalid key pattern %s: %v", key, err)
+			}
+		}
+	}
+
+	redactor := attributeRedactor{
+		allowKeys: rules.AllowKeys,
+		denyKeys:  rules.DenyKeys,
+		hashKeys:  rules.HashKeys,
+		hashKey:   hashKey,
+		maskValue: maskValue,
+	}
+	for _, pattern := range rules.MaskPatterns {
+		if builtinPattern, ok := builtinMaskPatterns[pattern]; ok {
+			pattern = builtinPattern
+		}
+		compiledPattern, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mask pattern %s: %v", pattern, err)
+		}
+		redactor.maskPatterns = append(redactor.maskPatterns, compiledPattern)
+	}
+	return &redactor, nil
+}
+
+// Enabled tells if the stored values differ from the received ones.
+func (p *RedactionProcessor) Enabled() bool {
+	return p.enabled
+}
+
+// RedactResourceAttributes returns a redacted copy of the attributes to store, as the original ones are still used
+// to evaluate and enrich the spans. The attributes are returned as they are when redaction is disabled. The other
+// methods do the same for their values.
+func (p *RedactionProcessor) RedactResourceAttributes(attributes map[string]interface{}) map[string]interface{} {
+	if !p.enabled {
+		return attributes
+	}
+	return p.resource.redactCopy(attributes)
+}
+
+func (p *RedactionProcessor) RedactScopeAttributes(attributes map[string]interface{}) map[string]interface{} {
+	if !p.enabled {
+		return attributes
+	}
+	return p.scope.redactCopy(attributes)
+}
+
+func (p *RedactionProcessor) RedactSpanAttributes(attributes map[string]interface{}) map[string]interface{} {
+	if !p.enabled {
+		return attributes
+	}
+	return p.span.redactCopy(attributes)
+}
+
+// RedactSpanEvents applies the event rules to the attributes of the processed span events. Exception events
+// have no attributes left, as the exceptions are stored separately.
+func (p *RedactionProcessor) RedactSpanEvents(events []zkUtilsCommonModel.GenericMap) []zkUtilsCommonModel.GenericMap {
+	if !p.enabled {
+		return events
+	}
+	redactedEvents := make([]zkUtilsCommonModel.GenericMap, 0, len(events))
+	for _, event := range events {
+		attributes, ok := event[common.OTelSpanEventAttrKey].(map[string]interface{})
+		if !ok {
+			redactedEvents = append(redactedEvents, event)
+			continue
+		}
+		redactedEvent := maps.Clone(event)
+		redactedEvent[common.OTelSpanEventAttrKey] = p.event.redactCopy(attributes)
+		redactedEvents = append(redactedEvents, redactedEvent)
+	}
+	return redactedEvents
+}
+
+// RedactException applies the event rules to the message, type and stacktrace of an exception. They are not
+// removed by the allow-list, only by the deny-list.
+func (p *RedactionProcessor) RedactException(exception *model.ExceptionDetails) *model.ExceptionDetails {
+	if !p.enabled {
+		return exception
+	}
+	attributes := p.exception.redactCopy(map[string]interface{}{
+		common.OTelExceptionMessageKey:    exception.Message,
+		common.OTelExceptionTypeKey:       exception.Type,
+		common.OTelExceptionStacktraceKey: exception.Stacktrace,
+	})
+	redactedException := *exception
+	redactedException.Message = stringValue(attributes[common.OTelExceptionMessageKey])
+	redactedException.Type = stringValue(attributes[common.OTelExceptionTypeKey])
+	redactedException.Stacktrace = stringValue(attributes[common.OTelExceptionStacktraceKey])
+	return &redactedException
+}
+
+// RedactExceptionGroup applies the exception rules to the type and message template of a group. The group hash
+// is kept, as it is computed before redaction, and the frames are removed with the stacktrace.
+func (p *RedactionProcessor) RedactExceptionGroup(group *model.ExceptionGroup) *model.ExceptionGroup {
+	if !p.enabled {
+		return group
+	}
+	attributes := p.exception.redactCopy(map[string]interface{}{
+		common.OTelExceptionMessageKey:    group.MessageTemplate,
+		common.OTelExceptionTypeKey:       group.Type,
+		common.OTelExceptionStacktraceKey: "",
+	})
+	redactedGroup := *group
+	redactedGroup.MessageTemplate = stringValue(attributes[common.OTelExceptionMessageKey])
+	redactedGroup.Type = stringValue(attributes[common.OTelExceptionTypeKey])
+	if _, ok := attributes[common.OTelExceptionStacktraceKey]; !ok {
+		redactedGroup.Frames = nil
+	}
+	return &redactedGroup
+}
+
+// RedactResourceSpans applies the rules to the attributes of copies of received resource spans, as forwarded by
+// the exporters. Links use the span rules.
+func (p *RedactionProcessor) RedactResourceSpans(resourceSpans []*tracev1.ResourceSpans) {
+	if !p.enabled {
+		return
+	}
+	for _, resourceSpan := range resourceSpans {
+		if resourceSpan.Resource != nil {
+			resourceSpan.Resource.Attributes = p.resource.redactKeyValues(resourceSpan.Resource.Attributes)
+		}
+		for _, scopeSpans := range resourceSpan.ScopeSpans {
+			if scopeSpans.Scope != nil {
+				scopeSpans.Scope.Attributes = p.scope.redactKeyValues(scopeSpans.Scope.Attributes)
+			}
+			for _, span := range scopeSpans.Spans {
+				span.Attributes = p.span.redactKeyValues(span.Attributes)
+				for _, event := range span.Events {
+					if event.Name == common.OTelSpanEventException {
+						event.Attributes = p.exception.redactKeyValues(event.Attributes)
+					} else {
+						event.Attributes = p.event.redactKeyValues(event.Attributes)
+					}
+				}
+				for _, link := range span.Links {
+					link.Attributes = p.span.redactKeyValues(link.Attributes)
+				}
+			}
+		}
+	}
+}
+
+// redactCopy returns the attributes without the removed ones, and with the hashed and masked values.
+func (r *attributeRedactor) redactCopy(attributes map[string]interface{}) map[string]interface{} {
+	if attributes == nil {
+		return nil
+	}
+	redacted := make(map[string]interface{}, len(attributes))
+	for key, value := range attributes {
+		if r.removes(key) {
+			continue
+		}
+		if matchesAnyKey(r.hashKeys, key) {
+			redacted[key] = r.hash(value)
+			continue
+		}
+		if len(r.maskPatterns) > 0 {
+			value = r.mask(value)
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
+
+// redactKeyValues redacts the attributes in place, and returns them without the removed ones. Hashed values are
+// the same as for the attribute maps.
+func (r *attributeRedactor) redactKeyValues(attributes []*commonv1.KeyValue) []*commonv1.KeyValue {
+	redacted := attributes[:0]
+	for _, attribute := range attributes {
+		if r.removes(attribute.Key) {
+			continue
+		}
+		if matchesAnyKey(r.hashKeys, attribute.Key) {
+			value := utils.ConvertKVListToMap([]*commonv1.KeyValue{attribute})[attribute.Key]
+			attribute.Value = &commonv1.AnyValue{Value: &commonv1.AnyValue_StringValue{StringValue: r.hash(value)}}
+		} else if len(r.maskPatterns) > 0 {
+			r.maskAnyValue(attribute.Value)
+		}
+		redacted = append(redacted, attribute)
+	}
+	return redacted
+}
+
+func (r *attributeRedactor) removes(key string) bool {
+	if matchesAnyKey(r.denyKeys, key) {
+		return true
+	}
+	return len(r.allowKeys) > 0 && !matchesAnyKey(r.allowKeys, key) && !matchesAnyKey(r.exemptKeys, key)
+}
+
+func (r *attributeRedactor) maskAnyValue(value *commonv1.AnyValue) {
+	switch v := value.GetValue().(type) {
+	case *commonv1.AnyValue_StringValue:
+		v.StringValue = r.mask(v.StringValue).(string)
+	case *commonv1.AnyValue_ArrayValue:
+		for _, item := range v.ArrayValue.GetValues() {
+			r.maskAnyValue(item)
+		}
+	}
+}
+
+func (r *attributeRedactor) mask(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		for _, pattern := range r.maskPatterns {
+			v = pattern.ReplaceAllString(v, r.maskValue)
+		}
+		return v
+	case []interface{}:
+		masked := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			masked = append(masked, r.mask(item))
+		}
+		return masked
+	}
+	return value
+}
+
+func matchesAnyKey(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, key); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *attributeRedactor) hash(value interface{}) string {
+	return hashValue(r.hashKey, value)
+}
+
+// hashValue returns the HMAC-SHA256 of the value with the key.
+func hashValue(key []byte, value interface{}) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(fmt.Sprintf("%v", value)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func stringValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", value)
+}
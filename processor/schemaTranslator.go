@@ -0,0 +1,321 @@
+package processor
+
+import (
+	"embed"
+	"fmt"
+	"github.com/zerok-ai/zk-observer/config"
+	logger "github.com/zerok-ai/zk-utils-go/logs"
+	"gopkg.in/yaml.v3"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var schemaTranslatorLogTag = "SchemaTranslator"
+
+//go:embed schemas/*.yaml
+var schemaFiles embed.FS
+
+// schemaFile is the subset of the OTel schema file format used for translation.
+// Ref: https://opentelemetry.io/docs/specs/otel/schemas/file_format_v1.1.0/
+type schemaFile struct {
+	FileFormat string                       `yaml:"file_format"`
+	SchemaUrl  string                       `yaml:"schema_url"`
+	Versions   map[string]*schemaVersionDef `yaml:"versions"`
+}
+
+type schemaVersionDef struct {
+	All        schemaChangeSet `yaml:"all"`
+	Resources  schemaChangeSet `yaml:"resources"`
+	Spans      schemaChangeSet `yaml:"spans"`
+	SpanEvents schemaChangeSet `yaml:"span_events"`
+}
+
+type schemaChangeSet struct {
+	Changes []schemaChange `yaml:"changes"`
+}
+
+type schemaChange struct {
+	RenameAttributes *struct {
+		AttributeMap  map[string]string `yaml:"attribute_map"`
+		ApplyToSpans  []string          `yaml:"apply_to_spans"`
+		ApplyToEvents []string          `yaml:"apply_to_events"`
+	} `yaml:"rename_attributes"`
+	RenameEvents *struct {
+		NameMap map[string]string `yaml:"name_map"`
+	} `yaml:"rename_events"`
+}
+
+type semVersion [3]int
+
+type renameRule struct {
+	attributeMap map[string]string
+	// applyTo limits the rule to spans or events with these names. Empty means all.
+	applyTo map[string]bool
+}
+
+// versionChanges holds the renames needed to move data from the previous version to this one.
+type versionChanges struct {
+	version    semVersion
+	resource   []renameRule
+	span       []renameRule
+	event      []renameRule
+	eventNames []map[string]string
+}
+
+// SchemaTranslator upgrades attributes from the schema version of the incoming data to the configured target
+// version, using the rename rules of the bundled OTel schema files. Data newer than the target is left as is.
+// It runs before the transform processor, so transform actions see the target version keys.
+type SchemaTranslator struct {
+	enabled       bool
+	targetVersion string
+	target        semVersion
+	changes       []*versionChanges
+	// plans caches the plans of the versions of the schema files only, as clients can send any version.
+	plans         sync.Map
+	knownVersions map[string]bool
+}
+
+func NewSchemaTranslator(cfg config.SchemaTranslationConfig) (*SchemaTranslator, error) {
+	translator := SchemaTranslator{enabled: cfg.Enabled && len(cfg.TargetVersion) > 0, targetVersion: cfg.TargetVersion}
+	if !translator.enabled {
+		return &translator, nil
+	}
+
+	target, err := parseSemVersion(cfg.TargetVersion)
+	if err != nil {
+		logger.Error(schemaTranslatorLogTag, "Invalid target schema version ", cfg.TargetVersion, ": ", err)
+		return nil, err
+	}
+	translator.target = target
+
+	changes, err := loadSchemaChanges()
+	if err != nil {
+		logger.Error(schemaTranslatorLogTag, "Error while loading schema files ", err)
+		return nil, err
+	}
+	if len(changes) == 0 || compareSemVersions(changes[len(changes)-1].version, target) < 0 {
+		return nil, fmt.Errorf("target schema version %s is newer than the bundled schema files", cfg.TargetVersion)
+	}
+	translator.changes = changes
+	translator.knownVersions = map[string]bool{cfg.TargetVersion: true}
+	for _, versionChanges := range changes {
+		translator.knownVersions[versionChanges.version.String()] = true
+	}
+	return &translator, nil
+}
+
+func loadSchemaChanges() ([]*versionChanges, error) {
+	fileNames, err := schemaFiles.ReadDir("schemas")
+	if err != nil {
+		return nil, err
+	}
+
+	// Every schema file repeats the history of the older versions, so a version is taken from the first
+	// file which has it.
+	changesByVersion := map[semVersion]*versionChanges{}
+	for _, fileName := range fileNames {
+		data, err := schemaFiles.ReadFile("schemas/" + fileName.Name())
+		if err != nil {
+			return nil, err
+		}
+		var file schemaFile
+		if err = yaml.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("invalid schema file %s: %v", fileName.Name(), err)
+		}
+		for versionStr, versionDef := range file.Versions {
+			version, err := parseSemVersion(versionStr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid version %s in schema file %s: %v", versionStr, fileName.Name(), err)
+			}
+			if _, ok := changesByVersion[version]; !ok {
+				changesByVersion[version] = newVersionChanges(version, versionDef)
+			}
+		}
+	}
+
+	changes := make([]*versionChanges, 0, len(changesByVersion))
+	for _, versionChange := range changesByVersion {
+		changes = append(changes, versionChange)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return compareSemVersions(changes[i].version, changes[j].version) < 0
+	})
+	return changes, nil
+}
+
+// newVersionChanges flattens a version definition. Changes in `all` apply before the section specific ones.
+func newVersionChanges(version semVersion, versionDef *schemaVersionDef) *versionChanges {
+	changes := versionChanges{version: version}
+	if versionDef == nil {
+		return &changes
+	}
+
+	for _, change := range versionDef.All.Changes {
+		if change.RenameAttributes != nil {
+			rule := renameRule{attributeMap: change.RenameAttributes.AttributeMap}
+			changes.resource = append(changes.resource, rule)
+			changes.span = append(changes.span, rule)
+			changes.event = append(changes.event, rule)
+		}
+	}
+	for _, change := range versionDef.Resources.Changes {
+		if change.RenameAttributes != nil {
+			changes.resource = append(changes.resource, renameRule{attributeMap: change.RenameAttributes.AttributeMap})
+		}
+	}
+	for _, change := range versionDef.Spans.Changes {
+		if change.RenameAttributes != nil {
+			changes.span = append(changes.span, renameRule{
+				attributeMap: change.RenameAttributes.AttributeMap,
+				applyTo:      toSet(change.RenameAttributes.ApplyToSpans),
+			})
+		}
+	}
+	for _, change := range versionDef.SpanEvents.Changes {
+		if change.RenameEvents != nil {
+			changes.eventNames = append(changes.eventNames, change.RenameEvents.NameMap)
+		}
+		if change.RenameAttributes != nil {
+			changes.event = append(changes.event, renameRule{
+				attributeMap: change.RenameAttributes.AttributeMap,
+				applyTo:      toSet(change.RenameAttributes.ApplyToEvents),
+			})
+		}
+	}
+	return &changes
+}
+
+// TargetVersion returns the schema version of the data after translation.
+func (t *SchemaTranslator) TargetVersion(fromVersion string) string {
+	if !t.translates(fromVersion) {
+		return fromVersion
+	}
+	return t.targetVersion
+}
+
+// TargetSchemaUrl returns the schema url of the data after translation.
+func (t *SchemaTranslator) TargetSchemaUrl(schemaUrl string, fromVersion string) string {
+	if !t.translates(fromVersion) {
+		return schemaUrl
+	}
+	return schemaUrl[:strings.LastIndex(schemaUrl, "/")+1] + t.targetVersion
+}
+
+func (t *SchemaTranslator) TranslateResourceAttributes(fromVersion string, attributes map[string]interface{}) {
+	for _, changes := range t.plan(fromVersion) {
+		applyRenameRules(changes.resource, "", attributes)
+	}
+}
+
+func (t *SchemaTranslator) TranslateSpanAttributes(fromVersion string, spanName string, attributes map[string]interface{}) {
+	for _, changes := range t.plan(fromVersion) {
+		applyRenameRules(changes.span, spanName, attributes)
+	}
+}
+
+// TranslateEvent renames the attributes of a span event and returns the translated event name.
+func (t *SchemaTranslator) TranslateEvent(fromVersion string, eventName string, attributes map[string]interface{}) string {
+	for _, changes := range t.plan(fromVersion) {
+		for _, nameMap := range changes.eventNames {
+			if newName, ok := nameMap[eventName]; ok {
+				eventName = newName
+			}
+		}
+		applyRenameRules(changes.event, eventName, attributes)
+	}
+	return eventName
+}
+
+func (t *SchemaTranslator) translates(fromVersion string) bool {
+	return len(t.plan(fromVersion)) > 0 || (t.enabled && fromVersion == t.targetVersion)
+}
+
+// plan returns the changes between the given version and the target version, oldest first.
+func (t *SchemaTranslator) plan(fromVersion string) []*versionChanges {
+	if !t.enabled {
+		return nil
+	}
+	if cached, ok := t.plans.Load(fromVersion); ok {
+		return cached.([]*versionChanges)
+	}
+
+	var plan []*versionChanges
+	from, err := parseSemVersion(fromVersion)
+	if err != nil {
+		logger.Debug(schemaTranslatorLogTag, "Not translating data with invalid schema version ", fromVersion)
+	} else {
+		for _, changes := range t.changes {
+			if compareSemVersions(changes.version, from) > 0 && compareSemVersions(changes.version, t.target) <= 0 {
+				plan = append(plan, changes)
+			}
+		}
+	}
+	if t.knownVersions[fromVersion] {
+		t.plans.Store(fromVersion, plan)
+	}
+	return plan
+}
+
+func applyRenameRules(rules []renameRule, name string, attributes map[string]interface{}) {
+	if attributes == nil {
+		return
+	}
+	for _, rule := range rules {
+		if rule.applyTo != nil && !rule.applyTo[name] {
+			continue
+		}
+		for oldKey, newKey := range rule.attributeMap {
+			value, ok := attributes[oldKey]
+			if !ok {
+				continue
+			}
+			// An attribute already sent with the new name wins.
+			if _, exists := attributes[newKey]; !exists {
+				attributes[newKey] = value
+			}
+			delete(attributes, oldKey)
+		}
+	}
+}
+
+func parseSemVersion(version string) (semVersion, error) {
+	var parsed semVersion
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return parsed, fmt.Errorf("version %s is not of the form major.minor.patch", version)
+	}
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 {
+			return parsed, fmt.Errorf("version %s is not of the form major.minor.patch", version)
+		}
+		parsed[i] = number
+	}
+	return parsed, nil
+}
+
+func (v semVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+func compareSemVersions(a semVersion, b semVersion) int {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] - b[i]
+		}
+	}
+	return 0
+}
+
+func toSet(values []string) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
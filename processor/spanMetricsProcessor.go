@@ -0,0 +1,190 @@
+package processor
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/zerok-ai/zk-observer/config"
+	"github.com/zerok-ai/zk-observer/model"
+	"github.com/zerok-ai/zk-observer/utils"
+	logger "github.com/zerok-ai/zk-utils-go/logs"
+	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var spanMetricsLogTag = "SpanMetricsProcessor"
+
+// spanMetricsOverflowValue replaces all label values of the spans recorded above the series limit.
+const spanMetricsOverflowValue = "__overflow__"
+
+var defaultSpanMetricsBuckets = []float64{0.002, 0.004, 0.006, 0.008, 0.01, 0.05, 0.1, 0.2, 0.4, 0.8, 1, 1.4, 2, 5, 10, 15}
+
+var spanMetricsLabels = []string{"service_name", "span_name", "span_kind", "protocol", "status_code"}
+
+// Status code attributes, checked in order.
+var spanMetricsStatusCodeKeys = []string{"http.response.status_code", "http.status_code", "rpc.grpc.status_code"}
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+type spanMetricsDimension struct {
+	key   string
+	label string
+}
+
+// SpanMetricsProcessor records request rate, error rate and duration metrics of the received spans. Spans
+// dropped by head sampling are not counted.
+type SpanMetricsProcessor struct {
+	enabled          bool
+	exemplars        bool
+	maxSeries        int
+	dimensions       []spanMetricsDimension
+	protocolDetector *utils.ProtocolDetector
+
+	calls     *prometheus.CounterVec
+	errors    *prometheus.CounterVec
+	duration  *prometheus.HistogramVec
+	overflows prometheus.Counter
+
+	series      map[string]struct{}
+	seriesMutex sync.Mutex
+}
+
+func NewSpanMetricsProcessor(cfg config.SpanMetricsConfig, protocolDetector *utils.ProtocolDetector) (*SpanMetricsProcessor, error) {
+	processor := SpanMetricsProcessor{enabled: cfg.Enabled}
+	if !cfg.Enabled {
+		return &processor, nil
+	}
+
+	buckets := cfg.Buckets
+	if len(buckets) == 0 {
+		buckets = defaultSpanMetricsBuckets
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		err := fmt.Errorf("buckets %v are not in increasing order", buckets)
+		logger.Error(spanMetricsLogTag, "Invalid span metrics config: ", err)
+		return nil, err
+	}
+
+	labels := append([]string{}, spanMetricsLabels...)
+	for _, key := range cfg.Dimensions {
+		label := invalidLabelChars.ReplaceAllString(key, "_")
+		for _, existingLabel := range labels {
+			if label == existingLabel {
+				err := fmt.Errorf("dimension %s conflicts with label %s", key, label)
+				logger.Error(spanMetricsLogTag, "Invalid span metrics config: ", err)
+				return nil, err
+			}
+		}
+		labels = append(labels, label)
+		processor.dimensions = append(processor.dimensions, spanMetricsDimension{key: key, label: label})
+	}
+
+	processor.exemplars = cfg.Exemplars
+	processor.maxSeries = cfg.MaxSeries
+	processor.protocolDetector = protocolDetector
+	processor.series = make(map[string]struct{})
+
+	processor.calls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zerok_receiver_spanmetrics_calls_total",
+		Help: "Total spans received.",
+	}, labels)
+	processor.errors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zerok_receiver_spanmetrics_errors_total",
+		Help: "Total spans received with an error status or an exception.",
+	}, labels)
+	processor.duration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "zerok_receiver_spanmetrics_duration_seconds",
+		Help:    "Duration of the received spans.",
+		Buckets: buckets,
+	}, labels)
+	processor.overflows = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "zerok_receiver_spanmetrics_overflow_total",
+		Help: "Total spans recorded with overflow labels as the series limit was reached.",
+	})
+	return &processor, nil
+}
+
+// RecordSpan records the span in the span metrics. spanAttributes and resourceAttributes are the processed
+// attributes of the span, and isError tells whether the span was classified as an error.
+func (p *SpanMetricsProcessor) RecordSpan(traceId string, serviceName string, span *tracev1.Span, spanKind model.SpanKind, isError bool, spanAttributes map[string]interface{}, resourceAttributes map[string]interface{}) {
+	if !p.enabled {
+		return
+	}
+
+	protocol, _ := p.protocolDetector.DetectProtocolFromAttributes(spanKind, spanAttributes)
+	labelValues := []string{serviceName, span.Name, string(spanKind), string(protocol), spanStatusCode(spanAttributes)}
+	for _, dimension := range p.dimensions {
+		value, ok := spanAttributes[dimension.key]
+		if !ok {
+			value, ok = resourceAttributes[dimension.key]
+		}
+		if ok {
+			labelValues = append(labelValues, fmt.Sprintf("%v", value))
+		} else {
+			labelValues = append(labelValues, "")
+		}
+	}
+	labelValues = p.limitSeries(labelValues)
+
+	var exemplar prometheus.Labels
+	if p.exemplars && len(traceId) > 0 {
+		exemplar = prometheus.Labels{"trace_id": traceId}
+	}
+	durationSeconds := float64(span.EndTimeUnixNano-span.StartTimeUnixNano) / 1e9
+
+	addToCounter(p.calls.WithLabelValues(labelValues...), exemplar)
+	if isError {
+		addToCounter(p.errors.WithLabelValues(labelValues...), exemplar)
+	}
+	observer := p.duration.WithLabelValues(labelValues...)
+	if exemplarObserver, ok := observer.(prometheus.ExemplarObserver); ok && exemplar != nil {
+		exemplarObserver.ObserveWithExemplar(durationSeconds, exemplar)
+	} else {
+		observer.Observe(durationSeconds)
+	}
+}
+
+// limitSeries returns the overflow label values for new label combinations once maxSeries is reached.
+func (p *SpanMetricsProcessor) limitSeries(labelValues []string) []string {
+	if p.maxSeries <= 0 {
+		return labelValues
+	}
+
+	seriesKey := strings.Join(labelValues, "\xff")
+	p.seriesMutex.Lock()
+	defer p.seriesMutex.Unlock()
+	if _, ok := p.series[seriesKey]; ok {
+		return labelValues
+	}
+	if len(p.series) < p.maxSeries {
+		p.series[seriesKey] = struct{}{}
+		return labelValues
+	}
+
+	p.overflows.Inc()
+	overflowValues := make([]string, len(labelValues))
+	for i := range overflowValues {
+		overflowValues[i] = spanMetricsOverflowValue
+	}
+	return overflowValues
+}
+
+func addToCounter(counter prometheus.Counter, exemplar prometheus.Labels) {
+	if exemplarAdder, ok := counter.(prometheus.ExemplarAdder); ok && exemplar != nil {
+		exemplarAdder.AddWithExemplar(1, exemplar)
+		return
+	}
+	counter.Inc()
+}
+
+func spanStatusCode(spanAttributes map[string]interface{}) string {
+	for _, key := range spanMetricsStatusCodeKeys {
+		if value, ok := spanAttributes[key]; ok {
+			return fmt.Sprintf("%v", value)
+		}
+	}
+	return ""
+}
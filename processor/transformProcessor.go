@@ -0,0 +1,213 @@
+package processor
+
+import (
+	"fmt"
+	"github.com/zerok-ai/zk-observer/config"
+	logger "github.com/zerok-ai/zk-utils-go/logs"
+	"regexp"
+	"strings"
+)
+
+var transformLogTag = "TransformProcessor"
+
+const (
+	ActionInsert  = "insert"
+	ActionUpdate  = "update"
+	ActionUpsert  = "upsert"
+	ActionDelete  = "delete"
+	ActionRename  = "rename"
+	ActionCopy    = "copy"
+	ActionHash    = "hash"
+	ActionExtract = "extract"
+
+	LevelResource = "resource"
+	LevelScope    = "scope"
+	LevelSpan     = "span"
+)
+
+type transformAction struct {
+	action  string
+	key     string
+	fromKey string
+	value   interface{}
+	pattern *regexp.Regexp
+	hashKey []byte
+}
+
+// TransformProcessor applies the configured attribute actions in order, separately for resource, scope and
+// span attributes. It runs before rule evaluation and IP detection, so both see the normalized keys, and after
+// schema translation, so actions have to use the keys of the translation target version.
+type TransformProcessor struct {
+	enabled  bool
+	resource []transformAction
+	scope    []transformAction
+	span     []transformAction
+}
+
+func NewTransformProcessor(cfg config.TransformConfig) (*TransformProcessor, error) {
+	processor := TransformProcessor{enabled: cfg.Enabled}
+	if !cfg.Enabled {
+		return &processor, nil
+	}
+
+	for i, actionConfig := range cfg.Actions {
+		action, err := newTransformAction(actionConfig, []byte(cfg.HashKey))
+		if err != nil {
+			logger.Error(transformLogTag, "Invalid transform action at index ", i, ": ", err)
+			return nil, err
+		}
+		switch strings.ToLower(actionConfig.Level) {
+		case LevelResource:
+			processor.resource = append(processor.resource, action)
+		case LevelScope:
+			processor.scope = append(processor.scope, action)
+		case LevelSpan, "":
+			processor.span = append(processor.span, action)
+		default:
+			return nil, fmt.Errorf("invalid level %s for transform action at index %d", actionConfig.Level, i)
+		}
+	}
+	return &processor, nil
+}
+
+func newTransformAction(actionConfig config.AttributeTransformAction, hashKey []byte) (transformAction, error) {
+	action := transformAction{
+		action:  strings.ToLower(actionConfig.Action),
+		key:     actionConfig.Key,
+		fromKey: actionConfig.FromKey,
+		value:   normalizeValue(actionConfig.Value),
+		hashKey: hashKey,
+	}
+
+	switch action.action {
+	case ActionInsert, ActionUpdate, ActionUpsert:
+		if len(action.key) == 0 || (action.value == nil && len(action.fromKey) == 0) {
+			return action, fmt.Errorf("%s needs a key and a value or fromKey", action.action)
+		}
+	case ActionRename, ActionCopy:
+		if len(action.key) == 0 || len(action.fromKey) == 0 {
+			return action, fmt.Errorf("%s needs a key and a fromKey", action.action)
+		}
+	case ActionDelete:
+		if len(action.key) == 0 {
+			return action, fmt.Errorf("%s needs a key", action.action)
+		}
+	case ActionHash:
+		if len(action.key) == 0 || len(hashKey) == 0 {
+			return action, fmt.Errorf("hash needs a key and a hashKey")
+		}
+	case ActionExtract:
+		if len(action.key) == 0 || len(actionConfig.Pattern) == 0 {
+			return action, fmt.Errorf("extract needs a key and a pattern")
+		}
+		pattern, err := regexp.Compile(actionConfig.Pattern)
+		if err != nil {
+			return action, err
+		}
+		if len(pattern.SubexpNames()) <= 1 {
+			return action, fmt.Errorf("extract pattern %s has no named groups", actionConfig.Pattern)
+		}
+		action.pattern = pattern
+	default:
+		return action, fmt.Errorf("unknown action %s", actionConfig.Action)
+	}
+	return action, nil
+}
+
+func (p *TransformProcessor) TransformResourceAttributes(attributes map[string]interface{}) {
+	if p.enabled {
+		applyTransformActions(p.resource, attributes)
+	}
+}
+
+func (p *TransformProcessor) TransformScopeAttributes(attributes map[string]interface{}) {
+	if p.enabled {
+		applyTransformActions(p.scope, attributes)
+	}
+}
+
+func (p *TransformProcessor) TransformSpanAttributes(attributes map[string]interface{}) {
+	if p.enabled {
+		applyTransformActions(p.span, attributes)
+	}
+}
+
+func applyTransformActions(actions []transformAction, attributes map[string]interface{}) {
+	if attributes == nil {
+		return
+	}
+	for _, action := range actions {
+		action.apply(attributes)
+	}
+}
+
+func (a transformAction) apply(attributes map[string]interface{}) {
+	_, keyExists := attributes[a.key]
+	switch a.action {
+	case ActionInsert:
+		if !keyExists {
+			a.setValue(attributes)
+		}
+	case ActionUpdate:
+		if keyExists {
+			a.setValue(attributes)
+		}
+	case ActionUpsert:
+		a.setValue(attributes)
+	case ActionDelete:
+		delete(attributes, a.key)
+	case ActionRename:
+		// The new key wins when an instrumentation sends both the old and the new name.
+		if value, ok := attributes[a.fromKey]; ok {
+			if !keyExists {
+				attributes[a.key] = value
+			}
+			delete(attributes, a.fromKey)
+		}
+	case ActionCopy:
+		if value, ok := attributes[a.fromKey]; ok && !keyExists {
+			attributes[a.key] = value
+		}
+	case ActionHash:
+		if value, ok := attributes[a.key]; ok {
+			attributes[a.key] = hashValue(a.hashKey, value)
+		}
+	case ActionExtract:
+		value, ok := attributes[a.key].(string)
+		if !ok {
+			return
+		}
+		match := a.pattern.FindStringSubmatch(value)
+		if match == nil {
+			return
+		}
+		for i, name := range a.pattern.SubexpNames() {
+			if i > 0 && len(name) > 0 {
+				attributes[name] = match[i]
+			}
+		}
+	}
+}
+
+func (a transformAction) setValue(attributes map[string]interface{}) {
+	if len(a.fromKey) > 0 {
+		if value, ok := attributes[a.fromKey]; ok {
+			attributes[a.key] = value
+		}
+		return
+	}
+	attributes[a.key] = a.value
+}
+
+// normalizeValue converts config values into the types used in the attribute maps.
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case float32:
+		return float64(v)
+	}
+	return value
+}
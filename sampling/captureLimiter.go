@@ -0,0 +1,132 @@
+package sampling
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// Reasons of the trace ids of a workload which are not captured.
+const (
+	CaptureDropRateLimited = "rate_limited"
+	CaptureDropSampledOut  = "sampled_out"
+	CaptureDropEvicted     = "evicted"
+	// CaptureDropStaleBucket is the reason of the trace ids offered for a bucket older than the current one.
+	CaptureDropStaleBucket = "stale_bucket"
+)
+
+// maxTrackedTraces bounds the trace ids remembered for dedup in a bucket, when the bucket has no cap.
+const maxTrackedTraces = 100000
+
+// Markers of the trace ids offered in the bucket which are not in its reservoir.
+const (
+	notCaptured = -1
+	// capturedUncapped marks the trace ids captured in buckets without a cap, which have no reservoir.
+	capturedUncapped = -2
+)
+
+// CaptureLimits are the limits of the trace ids captured for a workload by a receiver pod, which only sees its own
+// spans. Zero values are unlimited.
+type CaptureLimits struct {
+	MaxTracesPerBucket int
+	TracesPerSecond    float64
+	Burst              float64
+}
+
+// CaptureDecision is the result of offering a trace id to a CaptureLimiter.
+type CaptureDecision struct {
+	// Captured tells if the trace id is in the bucket.
+	Captured bool
+	// Repeated is set when the trace id was already offered in the bucket, by another span of the trace. It is
+	// captured if it was the first time, and was not evicted since.
+	Repeated bool
+	// Evicted is the captured trace id removed from the reservoir for this one, if any. It was captured for the
+	// spans offered before, so only its next spans are not captured.
+	Evicted string
+	// DropReason tells why a trace id offered for the first time is not captured.
+	DropReason string
+}
+
+// CaptureLimiter decides which trace ids of a workload are captured in a bucket by a receiver pod. A trace id is
+// decided on once per bucket, then rate limited, and once the bucket is at its cap, reservoir sampled so that the
+// captured trace ids stay a uniform sample of the bucket.
+type CaptureLimiter struct {
+	mutex       sync.Mutex
+	limits      CaptureLimits
+	rateLimiter *tokenBucket
+	bucketStart int64
+	// traces holds the index in the reservoir of the offered trace ids, or notCaptured.
+	traces    map[string]int
+	reservoir []string
+	offered   int
+}
+
+func NewCaptureLimiter(limits CaptureLimits) *CaptureLimiter {
+	limiter := CaptureLimiter{limits: limits, traces: map[string]int{}}
+	if limits.TracesPerSecond > 0 {
+		limiter.rateLimiter = newTokenBucket(limits.TracesPerSecond)
+		if limits.Burst > 0 {
+			limiter.rateLimiter.burst = limits.Burst
+			limiter.rateLimiter.tokens = limits.Burst
+		}
+	}
+	return &limiter
+}
+
+func (l *CaptureLimiter) Limits() CaptureLimits {
+	return l.limits
+}
+
+// Offer decides if the trace id is captured in the bucket. Trace ids offered for a bucket older than the current
+// one are not captured, as the state of that bucket is gone.
+func (l *CaptureLimiter) Offer(traceId string, bucketStart int64) CaptureDecision {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if bucketStart < l.bucketStart {
+		return CaptureDecision{DropReason: CaptureDropStaleBucket}
+	}
+	if bucketStart > l.bucketStart {
+		l.bucketStart = bucketStart
+		l.traces = map[string]int{}
+		l.reservoir = nil
+		l.offered = 0
+	}
+
+	if index, ok := l.traces[traceId]; ok {
+		return CaptureDecision{Captured: index != notCaptured, Repeated: true}
+	}
+	if l.rateLimiter != nil {
+		if allowed, _ := l.rateLimiter.allow(); !allowed {
+			l.track(traceId, notCaptured)
+			return CaptureDecision{DropReason: CaptureDropRateLimited}
+		}
+	}
+
+	maxTraces := l.limits.MaxTracesPerBucket
+	if maxTraces <= 0 {
+		l.track(traceId, capturedUncapped)
+		return CaptureDecision{Captured: true}
+	}
+	l.offered++
+	if len(l.reservoir) < maxTraces {
+		l.reservoir = append(l.reservoir, traceId)
+		l.traces[traceId] = len(l.reservoir) - 1
+		return CaptureDecision{Captured: true}
+	}
+	index := rand.Intn(l.offered)
+	if index >= maxTraces {
+		l.track(traceId, notCaptured)
+		return CaptureDecision{DropReason: CaptureDropSampledOut}
+	}
+	evicted := l.reservoir[index]
+	l.traces[evicted] = notCaptured
+	l.reservoir[index] = traceId
+	l.traces[traceId] = index
+	return CaptureDecision{Captured: true, Evicted: evicted}
+}
+
+// track remembers a trace id which is not in the reservoir, as long as the bucket tracks few enough of them.
+func (l *CaptureLimiter) track(traceId string, index int) {
+	if len(l.traces) < maxTrackedTraces {
+		l.traces[traceId] = index
+	}
+}
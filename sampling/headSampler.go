@@ -0,0 +1,242 @@
+package sampling
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/zerok-ai/zk-observer/common"
+	"github.com/zerok-ai/zk-observer/config"
+	promMetrics "github.com/zerok-ai/zk-observer/metrics"
+	commonv1 "go.opentelemetry.io/proto/otlp/common/v1"
+	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	SamplerTraceIdRatio = "trace_id_ratio"
+	SamplerRateLimiting = "rate_limiting"
+
+	defaultMaxBuckets = 10000
+)
+
+// HeadSampler samples spans at ingest, before any enrichment, using the rules configured per service and
+// span name. The first matching rule decides.
+type HeadSampler struct {
+	rules      []config.HeadSamplingRule
+	mutex      sync.Mutex
+	buckets    map[string]*list.Element
+	bucketLru  *list.List
+	maxBuckets int
+}
+
+// keyedBucket is the rate limit of a rule for a service, or for a service and span name.
+type keyedBucket struct {
+	key    string
+	bucket *tokenBucket
+}
+
+func NewHeadSampler(cfg config.HeadSamplingConfig) (*HeadSampler, error) {
+	for _, rule := range cfg.Rules {
+		if rule.Ratio != nil && (*rule.Ratio <= 0 || *rule.Ratio > 1) {
+			return nil, fmt.Errorf("ratio %v of head sampling rule %s is not in (0, 1]", *rule.Ratio, ruleName(&rule))
+		}
+		if rule.SpansPerSecond < 0 {
+			return nil, fmt.Errorf("spansPerSecond %v of head sampling rule %s is negative", rule.SpansPerSecond, ruleName(&rule))
+		}
+	}
+	maxBuckets := cfg.MaxBuckets
+	if maxBuckets <= 0 {
+		maxBuckets = defaultMaxBuckets
+	}
+	return &HeadSampler{
+		rules:      cfg.Rules,
+		buckets:    map[string]*list.Element{},
+		bucketLru:  list.New(),
+		maxBuckets: maxBuckets,
+	}, nil
+}
+
+// Sample tells if the span has to be kept. Kept spans are annotated with the sampling decision so that
+// downstream counts can be re-weighted.
+func (s *HeadSampler) Sample(span *tracev1.Span, serviceName string) bool {
+	ruleIndex, rule := s.matchRule(serviceName, span.Name)
+	if rule == nil {
+		return true
+	}
+
+	// A span sampled upstream keeps the higher of the two thresholds, so that its adjusted count covers both.
+	otState := parseOtTraceState(span.TraceState)
+	threshold := otState.threshold
+	if rule.Ratio != nil {
+		randomness := TraceIdRandomness(span.TraceId)
+		if otState.hasRandomness {
+			randomness = otState.randomness
+		}
+		ruleThreshold := RejectionThreshold(*rule.Ratio)
+		if randomness < ruleThreshold {
+			promMetrics.HeadSamplingSpansDropped.WithLabelValues(serviceName, SamplerTraceIdRatio).Inc()
+			return false
+		}
+		if ruleThreshold > threshold {
+			threshold = ruleThreshold
+			span.TraceState = withThreshold(span.TraceState, threshold)
+		}
+	}
+	adjustedCount := AdjustedCount(threshold)
+
+	if rule.SpansPerSecond > 0 {
+		allowed, rateAdjustedCount := s.allow(ruleIndex, rule, serviceName, span.Name)
+		if !allowed {
+			promMetrics.HeadSamplingSpansDropped.WithLabelValues(serviceName, SamplerRateLimiting).Inc()
+			return false
+		}
+		adjustedCount *= rateAdjustedCount
+	}
+
+	span.Attributes = append(span.Attributes,
+		stringAttribute(common.OTelSpanAttrSamplingRuleKey, ruleName(rule)),
+		doubleAttribute(common.OTelSpanAttrSamplingAdjustedCountKey, adjustedCount),
+	)
+	if rule.Ratio != nil || otState.hasThreshold {
+		span.Attributes = append(span.Attributes, doubleAttribute(common.OTelSpanAttrSamplingProbabilityKey, 1/AdjustedCount(threshold)))
+	}
+	return true
+}
+
+func (s *HeadSampler) matchRule(serviceName string, spanName string) (int, *config.HeadSamplingRule) {
+	for i := range s.rules {
+		rule := &s.rules[i]
+		if !matchesValue(rule.Service, serviceName) || !matchesValue(rule.SpanName, spanName) {
+			continue
+		}
+		return i, rule
+	}
+	return -1, nil
+}
+
+func matchesValue(ruleValue string, value string) bool {
+	return len(ruleValue) == 0 || ruleValue == common.ScenarioWorkloadGenericServiceNameKey || ruleValue == value
+}
+
+// allow takes a token from the bucket of the rule. Wildcard rules get a bucket per service, and rules with a span
+// name a bucket per span name. Above maxBuckets, the least recently used bucket is evicted, and starts full when
+// it is used again.
+func (s *HeadSampler) allow(ruleIndex int, rule *config.HeadSamplingRule, serviceName string, spanName string) (bool, float64) {
+	bucketKey := fmt.Sprintf("%d|%s", ruleIndex, serviceName)
+	if len(rule.SpanName) > 0 {
+		bucketKey += "|" + spanName
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	element, ok := s.buckets[bucketKey]
+	if ok {
+		s.bucketLru.MoveToFront(element)
+	} else {
+		if s.bucketLru.Len() >= s.maxBuckets {
+			oldest := s.bucketLru.Back()
+			s.bucketLru.Remove(oldest)
+			delete(s.buckets, oldest.Value.(*keyedBucket).key)
+		}
+		element = s.bucketLru.PushFront(&keyedBucket{key: bucketKey, bucket: newTokenBucket(rule.SpansPerSecond)})
+		s.buckets[bucketKey] = element
+	}
+	return element.Value.(*keyedBucket).bucket.allow()
+}
+
+func ruleName(rule *config.HeadSamplingRule) string {
+	service := rule.Service
+	if len(service) == 0 {
+		service = common.ScenarioWorkloadGenericServiceNameKey
+	}
+	if len(rule.SpanName) == 0 {
+		return service
+	}
+	return service + "/" + rule.SpanName
+}
+
+// otTraceState holds the probability sampling sub-keys of the `ot` tracestate entry. Missing or invalid
+// sub-keys are left at their zero value.
+type otTraceState struct {
+	threshold     uint64
+	hasThreshold  bool
+	randomness    uint64
+	hasRandomness bool
+}
+
+// parseOtTraceState reads the `th` threshold, which has up to 14 hex digits with the trailing zeros removed, and
+// the explicit `rv` randomness, which has exactly 14 hex digits.
+// Ref: https://opentelemetry.io/docs/specs/otel/trace/tracestate-probability-sampling/
+func parseOtTraceState(traceState string) otTraceState {
+	var state otTraceState
+	for _, member := range strings.Split(traceState, ",") {
+		member = strings.TrimSpace(member)
+		if !strings.HasPrefix(member, "ot=") {
+			continue
+		}
+		for _, subKey := range strings.Split(strings.TrimPrefix(member, "ot="), ";") {
+			key, value, found := strings.Cut(subKey, ":")
+			if !found {
+				continue
+			}
+			switch key {
+			case "th":
+				if len(value) == 0 || len(value) > 14 {
+					continue
+				}
+				if threshold, err := strconv.ParseUint(value, 16, 64); err == nil {
+					state.threshold = threshold << (4 * (14 - len(value)))
+					state.hasThreshold = true
+				}
+			case "rv":
+				if len(value) != 14 {
+					continue
+				}
+				if randomness, err := strconv.ParseUint(value, 16, 64); err == nil {
+					state.randomness = randomness
+					state.hasRandomness = true
+				}
+			}
+		}
+	}
+	return state
+}
+
+// withThreshold records the rejection threshold in the `th` sub-key of the `ot` tracestate entry.
+// Ref: https://opentelemetry.io/docs/specs/otel/trace/tracestate-handling/
+func withThreshold(traceState string, threshold uint64) string {
+	thValue := strings.TrimRight(fmt.Sprintf("%014x", threshold), "0")
+	if len(thValue) == 0 {
+		thValue = "0"
+	}
+	thEntry := "th:" + thValue
+
+	var members []string
+	otValue := thEntry
+	for _, member := range strings.Split(traceState, ",") {
+		member = strings.TrimSpace(member)
+		if len(member) == 0 {
+			continue
+		}
+		if !strings.HasPrefix(member, "ot=") {
+			members = append(members, member)
+			continue
+		}
+		for _, subKey := range strings.Split(strings.TrimPrefix(member, "ot="), ";") {
+			if len(subKey) > 0 && !strings.HasPrefix(subKey, "th:") {
+				otValue += ";" + subKey
+			}
+		}
+	}
+	// The modified entry moves to the front of the tracestate.
+	return strings.Join(append([]string{"ot=" + otValue}, members...), ",")
+}
+
+func stringAttribute(key string, value string) *commonv1.KeyValue {
+	return &commonv1.KeyValue{Key: key, Value: &commonv1.AnyValue{Value: &commonv1.AnyValue_StringValue{StringValue: value}}}
+}
+
+func doubleAttribute(key string, value float64) *commonv1.KeyValue {
+	return &commonv1.KeyValue{Key: key, Value: &commonv1.AnyValue{Value: &commonv1.AnyValue_DoubleValue{DoubleValue: value}}}
+}
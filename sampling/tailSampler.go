@@ -0,0 +1,272 @@
+package sampling
+
+import (
+	"container/list"
+	"github.com/zerok-ai/zk-observer/config"
+	promMetrics "github.com/zerok-ai/zk-observer/metrics"
+	logger "github.com/zerok-ai/zk-utils-go/logs"
+	zktick "github.com/zerok-ai/zk-utils-go/ticker"
+	"sync"
+	"time"
+)
+
+var tailSamplerLogTag = "TailSampler"
+
+const (
+	defaultDecisionWait = 10
+	defaultMaxTraces    = 50000
+	defaultDecisionTtl  = 300
+	// maxSharedDecisions is the most keep decisions written to the decision store at once.
+	maxSharedDecisions = 500
+
+	KeepReasonWorkload    = "workload"
+	KeepReasonError       = "error"
+	KeepReasonLatency     = "latency"
+	KeepReasonRemote      = "remote"
+	KeepReasonProbability = "probability"
+	DropReasonSampledOut  = "sampled_out"
+)
+
+// DecisionStore shares keep decisions with the other observer pods. Written decisions have to be visible to the
+// other pods right away, as they only check them once the decision window of a trace ends. Decisions expire
+// after the ttl.
+type DecisionStore interface {
+	PutKeepDecisions(traceIds []string, ttl time.Duration) error
+	GetKeepDecisions(traceIds []string) (map[string]bool, error)
+}
+
+// BufferedSpan is a serialized span waiting for the sampling decision of its trace.
+type BufferedSpan struct {
+	Key       string
+	SpanProto []byte
+}
+
+// SpanVerdict holds the properties of a span which decide if its trace has to be kept.
+type SpanVerdict struct {
+	MatchedWorkload bool
+	Error           bool
+	LatencyNs       uint64
+}
+
+type traceBuffer struct {
+	traceId   string
+	firstSeen time.Time
+	spans     []BufferedSpan
+	element   *list.Element
+}
+
+type decision struct {
+	keep   bool
+	expiry time.Time
+}
+
+// TailSampler buffers the spans of a trace for a decision window and stores the whole trace only if one of
+// its spans matched a workload, had an error or crossed the latency threshold. A configurable share of the
+// remaining traces is kept as well. Everything else is dropped.
+type TailSampler struct {
+	cfg              config.TailSamplingConfig
+	decisionStore    DecisionStore
+	onKeep           func(spans []BufferedSpan)
+	mutex            sync.Mutex
+	traces           map[string]*traceBuffer
+	arrivalOrder     *list.List
+	decisions        map[string]decision
+	latencyThreshold uint64
+	ticker           *zktick.TickerTask
+	// sharedDecisions holds the trace ids kept by this pod, until they are written to the decision store.
+	sharedDecisions chan string
+}
+
+// NewTailSampler creates a sampler which calls onKeep for the buffered spans of traces kept when their
+// decision window ends.
+func NewTailSampler(cfg config.TailSamplingConfig, decisionStore DecisionStore, onKeep func(spans []BufferedSpan)) *TailSampler {
+	if cfg.DecisionWait <= 0 {
+		cfg.DecisionWait = defaultDecisionWait
+	}
+	if cfg.MaxTraces <= 0 {
+		cfg.MaxTraces = defaultMaxTraces
+	}
+	if cfg.Ttl <= 0 {
+		cfg.Ttl = defaultDecisionTtl
+	}
+
+	sampler := &TailSampler{
+		cfg:              cfg,
+		decisionStore:    decisionStore,
+		onKeep:           onKeep,
+		traces:           map[string]*traceBuffer{},
+		arrivalOrder:     list.New(),
+		decisions:        map[string]decision{},
+		latencyThreshold: uint64(cfg.LatencyThresholdMs) * uint64(time.Millisecond),
+		sharedDecisions:  make(chan string, cfg.MaxTraces),
+	}
+	go sampler.shareKeepDecisions()
+	sampler.ticker = zktick.GetNewTickerTask("tail_sampling", time.Second, sampler.decide)
+	sampler.ticker.Start()
+	return sampler
+}
+
+// Offer hands a span to the sampler. It returns the spans which can be stored right away, which happens
+// when the trace was already kept or this span makes the trace worth keeping.
+func (s *TailSampler) Offer(traceId string, span BufferedSpan, verdict SpanVerdict) []BufferedSpan {
+	keepReason := s.keepReasonForSpan(verdict)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if existingDecision, ok := s.decisions[traceId]; ok {
+		if existingDecision.keep {
+			return []BufferedSpan{span}
+		}
+		if len(keepReason) == 0 {
+			promMetrics.TailSamplingSpansDropped.WithLabelValues(podIp).Inc()
+			return nil
+		}
+		// A late span changed the decision for this trace. The spans dropped earlier are lost.
+		s.keepTrace(traceId, keepReason)
+		return []BufferedSpan{span}
+	}
+
+	buffer, ok := s.traces[traceId]
+	if len(keepReason) > 0 {
+		spans := []BufferedSpan{span}
+		if ok {
+			spans = append(buffer.spans, span)
+			s.removeBuffer(buffer)
+		}
+		s.keepTrace(traceId, keepReason)
+		return spans
+	}
+
+	if !ok {
+		buffer = &traceBuffer{traceId: traceId, firstSeen: time.Now()}
+		buffer.element = s.arrivalOrder.PushBack(buffer)
+		s.traces[traceId] = buffer
+	}
+	buffer.spans = append(buffer.spans, span)
+	return nil
+}
+
+func (s *TailSampler) keepReasonForSpan(verdict SpanVerdict) string {
+	if verdict.MatchedWorkload {
+		return KeepReasonWorkload
+	}
+	if verdict.Error {
+		return KeepReasonError
+	}
+	if s.latencyThreshold > 0 && verdict.LatencyNs >= s.latencyThreshold {
+		return KeepReasonLatency
+	}
+	return ""
+}
+
+// keepTrace records a keep decision and queues it to be shared with the other pods. Must be called with the
+// mutex held, and does not wait for the decision store.
+func (s *TailSampler) keepTrace(traceId string, reason string) {
+	s.decisions[traceId] = decision{keep: true, expiry: time.Now().Add(time.Duration(s.cfg.Ttl) * time.Second)}
+	promMetrics.TailSamplingTraces.WithLabelValues(podIp, reason).Inc()
+	if reason == KeepReasonRemote {
+		return
+	}
+	select {
+	case s.sharedDecisions <- traceId:
+	default:
+		logger.Warn(tailSamplerLogTag, "Too many keep decisions waiting to be shared, not sharing traceId ", traceId)
+	}
+}
+
+// shareKeepDecisions writes the queued keep decisions to the decision store as soon as they are made, in batches
+// of the decisions queued meanwhile.
+func (s *TailSampler) shareKeepDecisions() {
+	for traceId := range s.sharedDecisions {
+		traceIds := []string{traceId}
+	drain:
+		for len(traceIds) < maxSharedDecisions {
+			select {
+			case traceId = <-s.sharedDecisions:
+				traceIds = append(traceIds, traceId)
+			default:
+				break drain
+			}
+		}
+		if err := s.decisionStore.PutKeepDecisions(traceIds, time.Duration(s.cfg.Ttl)*time.Second); err != nil {
+			logger.Error(tailSamplerLogTag, "Error while sharing ", len(traceIds), " keep decisions, error: ", err)
+		}
+	}
+}
+
+// removeBuffer must be called with the mutex held.
+func (s *TailSampler) removeBuffer(buffer *traceBuffer) {
+	s.arrivalOrder.Remove(buffer.element)
+	delete(s.traces, buffer.traceId)
+}
+
+// decide closes the decision window of the traces which waited long enough, or of the oldest traces when
+// the buffer is over capacity.
+func (s *TailSampler) decide() {
+	dueBuffers := s.collectDueBuffers()
+	if len(dueBuffers) == 0 {
+		s.expireDecisions()
+		return
+	}
+
+	traceIds := make([]string, 0, len(dueBuffers))
+	for _, buffer := range dueBuffers {
+		traceIds = append(traceIds, buffer.traceId)
+	}
+	remoteKeeps, err := s.decisionStore.GetKeepDecisions(traceIds)
+	if err != nil {
+		logger.Error(tailSamplerLogTag, "Error while getting keep decisions from other pods ", err)
+	}
+
+	var keptSpans []BufferedSpan
+	s.mutex.Lock()
+	for _, buffer := range dueBuffers {
+		if remoteKeeps[buffer.traceId] {
+			s.keepTrace(buffer.traceId, KeepReasonRemote)
+			keptSpans = append(keptSpans, buffer.spans...)
+		} else if ShouldSampleTraceId(buffer.traceId, s.cfg.SampleRatio) {
+			s.keepTrace(buffer.traceId, KeepReasonProbability)
+			keptSpans = append(keptSpans, buffer.spans...)
+		} else {
+			s.decisions[buffer.traceId] = decision{keep: false, expiry: time.Now().Add(time.Duration(s.cfg.Ttl) * time.Second)}
+			promMetrics.TailSamplingTraces.WithLabelValues(podIp, DropReasonSampledOut).Inc()
+			promMetrics.TailSamplingSpansDropped.WithLabelValues(podIp).Add(float64(len(buffer.spans)))
+		}
+	}
+	s.mutex.Unlock()
+
+	s.expireDecisions()
+	if len(keptSpans) > 0 {
+		s.onKeep(keptSpans)
+	}
+}
+
+func (s *TailSampler) collectDueBuffers() []*traceBuffer {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var dueBuffers []*traceBuffer
+	windowStart := time.Now().Add(-time.Duration(s.cfg.DecisionWait) * time.Second)
+	for element := s.arrivalOrder.Front(); element != nil; element = s.arrivalOrder.Front() {
+		buffer := element.Value.(*traceBuffer)
+		if buffer.firstSeen.After(windowStart) && len(s.traces) <= s.cfg.MaxTraces {
+			break
+		}
+		s.removeBuffer(buffer)
+		dueBuffers = append(dueBuffers, buffer)
+	}
+	return dueBuffers
+}
+
+func (s *TailSampler) expireDecisions() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for traceId, existingDecision := range s.decisions {
+		if now.After(existingDecision.expiry) {
+			delete(s.decisions, traceId)
+		}
+	}
+}
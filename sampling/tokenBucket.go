@@ -0,0 +1,55 @@
+package sampling
+
+import (
+	"time"
+)
+
+// tokenBucket limits the spans/sec of a sampling rule. It also counts the spans offered and allowed in the
+// current second to estimate how many spans each allowed span stands for.
+type tokenBucket struct {
+	rate              float64
+	burst             float64
+	tokens            float64
+	lastRefill        time.Time
+	windowStart       time.Time
+	offered           float64
+	allowed           float64
+	lastAdjustedCount float64
+}
+
+func newTokenBucket(spansPerSecond float64) *tokenBucket {
+	now := time.Now()
+	return &tokenBucket{
+		rate:              spansPerSecond,
+		burst:             spansPerSecond,
+		tokens:            spansPerSecond,
+		lastRefill:        now,
+		windowStart:       now,
+		lastAdjustedCount: 1,
+	}
+}
+
+// allow returns if the span can be kept, along with the adjusted count of the kept span.
+func (b *tokenBucket) allow() (bool, float64) {
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.lastRefill).Seconds()*b.rate)
+	b.lastRefill = now
+
+	if now.Sub(b.windowStart) >= time.Second {
+		if b.allowed > 0 {
+			b.lastAdjustedCount = b.offered / b.allowed
+		}
+		b.windowStart = now
+		b.offered = 0
+		b.allowed = 0
+	}
+	b.offered++
+
+	if b.tokens < 1 {
+		return false, 0
+	}
+	b.tokens--
+	b.allowed++
+	// The current window is still filling up, so the previous window is a better estimate early on.
+	return true, max(b.lastAdjustedCount, b.offered/b.allowed)
+}
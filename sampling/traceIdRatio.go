@@ -0,0 +1,58 @@
+package sampling
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+)
+
+var podIp = os.Getenv("POD_IP")
+
+// Trace id ratio sampling as defined by the OpenTelemetry probability sampling spec. The randomness is taken
+// from the least significant 56 bits of the trace id and a span is sampled when it is not below the
+// rejection threshold, so every pod makes the same decision for a trace.
+// Ref: https://opentelemetry.io/docs/specs/otel/trace/tracestate-probability-sampling/
+const (
+	randomnessBits = 56
+	maxThreshold   = uint64(1) << randomnessBits
+	randomnessMask = maxThreshold - 1
+)
+
+// TraceIdRandomness returns the 56 bit randomness value of a 16 byte trace id.
+func TraceIdRandomness(traceId []byte) uint64 {
+	if len(traceId) != 16 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(traceId[8:16]) & randomnessMask
+}
+
+// RejectionThreshold converts a sampling probability into the spec's rejection threshold.
+func RejectionThreshold(ratio float64) uint64 {
+	if ratio >= 1 {
+		return 0
+	}
+	if ratio <= 0 {
+		return maxThreshold
+	}
+	return uint64((1 - ratio) * float64(maxThreshold))
+}
+
+// AdjustedCount returns the number of spans represented by a span sampled with the rejection threshold.
+func AdjustedCount(threshold uint64) float64 {
+	if threshold >= maxThreshold {
+		return 0
+	}
+	return float64(maxThreshold) / float64(maxThreshold-threshold)
+}
+
+func ShouldSample(traceId []byte, ratio float64) bool {
+	return TraceIdRandomness(traceId) >= RejectionThreshold(ratio)
+}
+
+func ShouldSampleTraceId(traceIdHex string, ratio float64) bool {
+	traceId, err := hex.DecodeString(traceIdHex)
+	if err != nil {
+		return false
+	}
+	return ShouldSample(traceId, ratio)
+}
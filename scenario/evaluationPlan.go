@@ -0,0 +1,363 @@
+package scenario
+
+import (
+	"fmt"
+	"github.com/zerok-ai/zk-observer/common"
+	zkmodel "github.com/zerok-ai/zk-utils-go/scenario/model"
+	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Trace roles of the workloads, and of the spans by their kind.
+const (
+	TraceRoleServer   = "server"
+	TraceRoleClient   = "client"
+	TraceRoleInternal = "internal"
+)
+
+// maxCachedCandidates bounds the candidate lists kept by a plan, for service names which keep changing.
+const maxCachedCandidates = 4096
+
+// PlannedWorkload is a workload of a scenario, as evaluated by a plan.
+type PlannedWorkload struct {
+	Id       string
+	Workload zkmodel.Workload
+}
+
+// ProtocolWorkloads are the workloads of a scenario with the same protocol, so that the attribute store key is
+// generated once for them.
+type ProtocolWorkloads struct {
+	Protocol  zkmodel.ProtocolName
+	Workloads []PlannedWorkload
+}
+
+// ScenarioCandidates are the workloads of a scenario which can match a span.
+type ScenarioCandidates struct {
+	Scenario  *zkmodel.Scenario
+	Protocols []ProtocolWorkloads
+}
+
+// EvaluationPlan is compiled from the scenarios, and indexes their workloads by trace role, protocol and
+// service name, so that the workloads which cannot match a span are skipped without evaluating their rules.
+// Only OTel workloads are planned. A plan is immutable once compiled, apart from its cache of candidates.
+type EvaluationPlan struct {
+	signature  string
+	scenarios  []*zkmodel.Scenario
+	entries    []*plannedEntry
+	index      map[planIndexKey]*serviceIndex
+	candidates sync.Map
+	cached     int
+	cacheMutex sync.Mutex
+}
+
+// planIndexKey is the trace role and protocol of workloads. Empty values are workloads of any role or protocol.
+type planIndexKey struct {
+	traceRole string
+	protocol  zkmodel.ProtocolName
+}
+
+// serviceIndex holds the workloads of an index key by the service name they select. Workloads selecting
+// every service, a glob pattern or a deployment are generic, and matched with WorkloadSkipReason.
+type serviceIndex struct {
+	byService map[string][]*plannedEntry
+	generic   []*plannedEntry
+}
+
+// plannedEntry is a workload of the plan. order is the position of the workload in the plan, by scenario id
+// and workload id, which is the order of evaluation.
+type plannedEntry struct {
+	order    int
+	scenario *zkmodel.Scenario
+	workload PlannedWorkload
+}
+
+// SpanTarget is what the workload selectors are matched with for a span. Unknown values are the generic key,
+// or empty for the trace role and protocol, and match every selector.
+type SpanTarget struct {
+	ServiceName string
+	Namespace   string
+	Deployment  string
+	TraceRole   string
+	Protocol    zkmodel.ProtocolName
+}
+
+// NewSpanTarget returns the target of a span from the attributes of its resource. protocol is the executor
+// protocol of the span, or empty when it is not known.
+func NewSpanTarget(serviceName string, resourceAttrMap map[string]interface{}, kind tracev1.Span_SpanKind, protocol zkmodel.ProtocolName) SpanTarget {
+	target := SpanTarget{
+		ServiceName: serviceName,
+		Namespace:   common.ScenarioWorkloadGenericNamespaceKey,
+		Deployment:  common.ScenarioWorkloadGenericDeploymentKey,
+		TraceRole:   SpanTraceRole(kind),
+		Protocol:    protocol,
+	}
+	if len(target.ServiceName) == 0 {
+		target.ServiceName = common.ScenarioWorkloadGenericServiceNameKey
+	}
+	if namespace, ok := resourceAttrMap[common.OTelResourceAttrNamespaceKey].(string); ok && len(namespace) > 0 {
+		target.Namespace = namespace
+	}
+	if deployment, ok := resourceAttrMap[common.OTelResourceAttrDeploymentNameKey].(string); ok && len(deployment) > 0 {
+		target.Deployment = deployment
+	}
+	return target
+}
+
+// NewEvaluationPlan compiles the plan of the scenarios, which are evaluated in the order of their ids.
+func NewEvaluationPlan(scenarios map[string]*zkmodel.Scenario) *EvaluationPlan {
+	plan := EvaluationPlan{signature: PlanSignature(scenarios)}
+	for _, scenario := range scenarios {
+		if scenario != nil {
+			plan.scenarios = append(plan.scenarios, scenario)
+		}
+	}
+	sort.Slice(plan.scenarios, func(i, j int) bool {
+		return plan.scenarios[i].Id < plan.scenarios[j].Id
+	})
+
+	plan.index = map[planIndexKey]*serviceIndex{}
+	order := 0
+	for _, scenario := range plan.scenarios {
+		if scenario.Workloads == nil {
+			continue
+		}
+		for _, id := range sortedWorkloadIds(scenario) {
+			workload := (*scenario.Workloads)[id]
+			if workload.Executor != zkmodel.ExecutorOTel {
+				continue
+			}
+			entry := &plannedEntry{order: order, scenario: scenario, workload: PlannedWorkload{Id: id, Workload: workload}}
+			plan.entries = append(plan.entries, entry)
+			plan.addToIndex(entry)
+			order++
+		}
+	}
+	return &plan
+}
+
+func (p *EvaluationPlan) addToIndex(entry *plannedEntry) {
+	workload := entry.workload.Workload
+	key := planIndexKey{traceRole: strings.ToLower(string(workload.TraceRole)), protocol: indexedProtocol(workload.Protocol)}
+	index, ok := p.index[key]
+	if !ok {
+		index = &serviceIndex{byService: map[string][]*plannedEntry{}}
+		p.index[key] = index
+	}
+	if isExactServiceName(workload.Service) {
+		index.byService[workload.Service] = append(index.byService[workload.Service], entry)
+	} else {
+		index.generic = append(index.generic, entry)
+	}
+}
+
+// indexedProtocol returns the protocol a workload is indexed with. The general and identifier protocols, and
+// workloads without a protocol, evaluate spans of any protocol.
+func indexedProtocol(protocol zkmodel.ProtocolName) zkmodel.ProtocolName {
+	switch protocol {
+	case zkmodel.ProtocolGeneral, zkmodel.ProtocolIdentifier:
+		return ""
+	}
+	return protocol
+}
+
+func isExactServiceName(selector string) bool {
+	return len(selector) > 0 && selector != common.ScenarioWorkloadGenericServiceNameKey && !strings.ContainsAny(selector, "/*?[\\")
+}
+
+// matches tells if the workloads of the key can match a span of the target.
+func (k planIndexKey) matches(target SpanTarget) bool {
+	if len(k.traceRole) > 0 && len(target.TraceRole) > 0 && k.traceRole != target.TraceRole {
+		return false
+	}
+	return protocolMatches(k.protocol, target.Protocol)
+}
+
+// protocolMatches tells if a workload of the indexed protocol can match a span of the protocol. HTTP workloads
+// also match gRPC spans, which are HTTP/2 requests.
+func protocolMatches(workloadProtocol zkmodel.ProtocolName, spanProtocol zkmodel.ProtocolName) bool {
+	if len(workloadProtocol) == 0 || !isWorkloadProtocol(spanProtocol) || workloadProtocol == spanProtocol {
+		return true
+	}
+	return workloadProtocol == zkmodel.ProtocolHTTP && spanProtocol == zkmodel.ProtocolGRPC
+}
+
+// isWorkloadProtocol tells if workloads are selected by the span protocol. Span protocols which zk-utils-go has no
+// workload protocol for, like DB and MESSAGING, are not known, so that workloads of their subtypes, like MYSQL,
+// are evaluated for them.
+func isWorkloadProtocol(spanProtocol zkmodel.ProtocolName) bool {
+	switch spanProtocol {
+	case zkmodel.ProtocolHTTP, zkmodel.ProtocolGRPC, zkmodel.MYSQL:
+		return true
+	}
+	return false
+}
+
+// PlanSignature changes when a scenario is added, removed, or replaced by the scenario stores.
+func PlanSignature(scenarios map[string]*zkmodel.Scenario) string {
+	entries := make([]string, 0, len(scenarios))
+	for id, scenario := range scenarios {
+		if scenario == nil {
+			continue
+		}
+		entries = append(entries, fmt.Sprintf("%s|%s|%p", id, scenario.Version, scenario))
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, "\n")
+}
+
+func (p *EvaluationPlan) Signature() string {
+	return p.signature
+}
+
+func (p *EvaluationPlan) Scenarios() []*zkmodel.Scenario {
+	return p.scenarios
+}
+
+// ForEachWorkload calls fn for every workload of the plan, in the order of evaluation.
+func (p *EvaluationPlan) ForEachWorkload(fn func(scenario *zkmodel.Scenario, workload PlannedWorkload)) {
+	for _, entry := range p.entries {
+		fn(entry.scenario, entry.workload)
+	}
+}
+
+// Candidates returns the workloads which can match a span of the target, by scenario.
+func (p *EvaluationPlan) Candidates(target SpanTarget) []ScenarioCandidates {
+	if value, ok := p.candidates.Load(target); ok {
+		return value.([]ScenarioCandidates)
+	}
+	candidates := p.lookup(target)
+
+	p.cacheMutex.Lock()
+	if p.cached < maxCachedCandidates {
+		p.candidates.Store(target, candidates)
+		p.cached++
+	}
+	p.cacheMutex.Unlock()
+	return candidates
+}
+
+// lookup returns the candidates of the target from the index, in the order of evaluation.
+func (p *EvaluationPlan) lookup(target SpanTarget) []ScenarioCandidates {
+	var entries []*plannedEntry
+	for key, index := range p.index {
+		if !key.matches(target) {
+			continue
+		}
+		if target.ServiceName == common.ScenarioWorkloadGenericServiceNameKey {
+			for _, serviceEntries := range index.byService {
+				entries = append(entries, serviceEntries...)
+			}
+		} else {
+			entries = append(entries, index.byService[target.ServiceName]...)
+		}
+		// The trace role and protocol of the entries already match, so only the service selector is checked.
+		for _, entry := range index.generic {
+			if serviceSelectorMatches(entry.workload.Workload.Service, target) {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].order < entries[j].order
+	})
+
+	candidates := make([]ScenarioCandidates, 0)
+	for _, entry := range entries {
+		if len(candidates) == 0 || candidates[len(candidates)-1].Scenario != entry.scenario {
+			candidates = append(candidates, ScenarioCandidates{Scenario: entry.scenario})
+		}
+		candidates[len(candidates)-1].add(entry.workload)
+	}
+	return candidates
+}
+
+func (c *ScenarioCandidates) add(workload PlannedWorkload) {
+	for i := range c.Protocols {
+		if c.Protocols[i].Protocol == workload.Workload.Protocol {
+			c.Protocols[i].Workloads = append(c.Protocols[i].Workloads, workload)
+			return
+		}
+	}
+	c.Protocols = append(c.Protocols, ProtocolWorkloads{Protocol: workload.Workload.Protocol, Workloads: []PlannedWorkload{workload}})
+}
+
+func sortedWorkloadIds(scenario *zkmodel.Scenario) []string {
+	ids := make([]string, 0, len(*scenario.Workloads))
+	for id := range *scenario.Workloads {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// WorkloadSkipReason returns why the workload cannot match a span of the target, or an empty string when its rule
+// has to be evaluated.
+//
+// The service of a workload is a selector: empty or * selects every span, namespace/deployment selects the spans
+// of a Kubernetes deployment, and any other value selects the spans of a service name. Both parts of
+// namespace/deployment, and service names, are glob patterns, where * and ? match any characters and a
+// single character. Workloads of a trace role or protocol only evaluate the spans of that role or protocol, and
+// HTTP workloads also the gRPC spans. Spans for which a selected value is unknown are evaluated by the workload.
+func WorkloadSkipReason(workload zkmodel.Workload, target SpanTarget) string {
+	if workload.Executor != zkmodel.ExecutorOTel {
+		return fmt.Sprintf("workload executor %s is not %s", workload.Executor, zkmodel.ExecutorOTel)
+	}
+	selector := workload.Service
+	if namespace, deployment, found := strings.Cut(selector, "/"); found {
+		if !globMatches(namespace, target.Namespace, common.ScenarioWorkloadGenericNamespaceKey) {
+			return fmt.Sprintf("workload namespace %s does not match %s", namespace, target.Namespace)
+		}
+		if !globMatches(deployment, target.Deployment, common.ScenarioWorkloadGenericDeploymentKey) {
+			return fmt.Sprintf("workload deployment %s does not match %s", deployment, target.Deployment)
+		}
+	} else if !globMatches(selector, target.ServiceName, common.ScenarioWorkloadGenericServiceNameKey) {
+		return fmt.Sprintf("workload service %s does not match %s", selector, target.ServiceName)
+	}
+	workloadRole := strings.ToLower(string(workload.TraceRole))
+	if len(workloadRole) > 0 && len(target.TraceRole) > 0 && workloadRole != target.TraceRole {
+		return fmt.Sprintf("workload trace role %s is not %s", workloadRole, target.TraceRole)
+	}
+	if !protocolMatches(indexedProtocol(workload.Protocol), target.Protocol) {
+		return fmt.Sprintf("workload protocol %s does not match %s", workload.Protocol, target.Protocol)
+	}
+	return ""
+}
+
+// serviceSelectorMatches returns whether the service selector of a workload selects the spans of the target, like
+// WorkloadSkipReason.
+func serviceSelectorMatches(selector string, target SpanTarget) bool {
+	if namespace, deployment, found := strings.Cut(selector, "/"); found {
+		return globMatches(namespace, target.Namespace, common.ScenarioWorkloadGenericNamespaceKey) &&
+			globMatches(deployment, target.Deployment, common.ScenarioWorkloadGenericDeploymentKey)
+	}
+	return globMatches(selector, target.ServiceName, common.ScenarioWorkloadGenericServiceNameKey)
+}
+
+// globMatches returns whether the value matches the pattern. Empty patterns, and unknown values, match. Invalid
+// patterns only match themselves.
+func globMatches(pattern string, value string, genericKey string) bool {
+	if len(pattern) == 0 || pattern == genericKey || value == genericKey {
+		return true
+	}
+	matched, err := path.Match(pattern, value)
+	if err != nil {
+		return pattern == value
+	}
+	return matched
+}
+
+// SpanTraceRole returns the trace role of a span kind, or an empty string for an unspecified kind.
+func SpanTraceRole(kind tracev1.Span_SpanKind) string {
+	switch kind {
+	case tracev1.Span_SPAN_KIND_SERVER, tracev1.Span_SPAN_KIND_CONSUMER:
+		return TraceRoleServer
+	case tracev1.Span_SPAN_KIND_CLIENT, tracev1.Span_SPAN_KIND_PRODUCER:
+		return TraceRoleClient
+	case tracev1.Span_SPAN_KIND_INTERNAL:
+		return TraceRoleInternal
+	}
+	return ""
+}
@@ -0,0 +1,221 @@
+package scenario
+
+import (
+	"fmt"
+	"github.com/zerok-ai/zk-observer/common"
+	zkmodel "github.com/zerok-ai/zk-utils-go/scenario/model"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// planScenarios returns scenarios with workloads of every kind of selector, trace role and protocol.
+func planScenarios(scenarioCount int, workloadsPerScenario int) map[string]*zkmodel.Scenario {
+	selectors := []func(i int) string{
+		func(i int) string { return fmt.Sprintf("service-%d", i) },
+		func(i int) string { return fmt.Sprintf("service-%d", i) },
+		func(i int) string { return fmt.Sprintf("service-%d", i) },
+		func(i int) string { return "" },
+		func(i int) string { return "*" },
+		func(i int) string { return fmt.Sprintf("ns-%d/deployment-%d", i%3, i) },
+		func(i int) string { return fmt.Sprintf("service-%d?", i%10) },
+	}
+	traceRoles := []zkmodel.TraceRole{"", "server", "client"}
+	protocols := []zkmodel.ProtocolName{zkmodel.ProtocolHTTP, zkmodel.ProtocolGRPC, zkmodel.MYSQL, zkmodel.ProtocolGeneral, ""}
+
+	scenarios := map[string]*zkmodel.Scenario{}
+	for s := 0; s < scenarioCount; s++ {
+		workloads := map[string]zkmodel.Workload{}
+		for w := 0; w < workloadsPerScenario; w++ {
+			i := s*workloadsPerScenario + w
+			executor := zkmodel.ExecutorOTel
+			if i%11 == 0 {
+				executor = zkmodel.ExecutorEbpf
+			}
+			workloads[fmt.Sprintf("workload-%d", i)] = zkmodel.Workload{
+				Executor:  executor,
+				Service:   selectors[i%len(selectors)](i % 40),
+				TraceRole: traceRoles[i%len(traceRoles)],
+				Protocol:  protocols[i%len(protocols)],
+			}
+		}
+		id := fmt.Sprintf("scenario-%d", s)
+		scenarios[id] = &zkmodel.Scenario{Id: id, Version: "1", Workloads: &workloads}
+	}
+	return scenarios
+}
+
+func planTargets() []SpanTarget {
+	var targets []SpanTarget
+	for _, serviceName := range []string{"service-1", "service-12", "service-39", "unknown", common.ScenarioWorkloadGenericServiceNameKey} {
+		for _, traceRole := range []string{TraceRoleServer, TraceRoleClient, TraceRoleInternal, ""} {
+			for _, protocol := range []zkmodel.ProtocolName{zkmodel.ProtocolHTTP, zkmodel.ProtocolGRPC, zkmodel.MYSQL, "DB", ""} {
+				targets = append(targets, SpanTarget{
+					ServiceName: serviceName,
+					Namespace:   "ns-1",
+					Deployment:  "deployment-13",
+					TraceRole:   traceRole,
+					Protocol:    protocol,
+				})
+			}
+		}
+	}
+	targets = append(targets, SpanTarget{
+		ServiceName: "service-4",
+		Namespace:   common.ScenarioWorkloadGenericNamespaceKey,
+		Deployment:  common.ScenarioWorkloadGenericDeploymentKey,
+	})
+	return targets
+}
+
+// scanCandidates checks every workload of the plan, as done before workloads were indexed.
+func scanCandidates(plan *EvaluationPlan, target SpanTarget) []ScenarioCandidates {
+	candidates := make([]ScenarioCandidates, 0)
+	for _, scenario := range plan.Scenarios() {
+		scenarioCandidates := ScenarioCandidates{Scenario: scenario}
+		for _, id := range sortedWorkloadIds(scenario) {
+			workload := (*scenario.Workloads)[id]
+			if len(WorkloadSkipReason(workload, target)) == 0 {
+				scenarioCandidates.add(PlannedWorkload{Id: id, Workload: workload})
+			}
+		}
+		if len(scenarioCandidates.Protocols) > 0 {
+			candidates = append(candidates, scenarioCandidates)
+		}
+	}
+	return candidates
+}
+
+func TestCandidatesMatchWorkloadSkipReason(t *testing.T) {
+	plan := NewEvaluationPlan(planScenarios(5, 40))
+	for _, target := range planTargets() {
+		t.Run(fmt.Sprintf("%+v", target), func(t *testing.T) {
+			want := scanCandidates(plan, target)
+			if got := plan.Candidates(target); !reflect.DeepEqual(got, want) {
+				t.Errorf("Candidates() =\n%+v\nwant\n%+v", got, want)
+			}
+			// The second call is served from the cache.
+			if got := plan.Candidates(target); !reflect.DeepEqual(got, want) {
+				t.Errorf("cached Candidates() =\n%+v\nwant\n%+v", got, want)
+			}
+		})
+	}
+}
+
+func TestWorkloadSkipReason(t *testing.T) {
+	target := SpanTarget{
+		ServiceName: "checkout",
+		Namespace:   "ns-prod",
+		Deployment:  "api-1",
+		TraceRole:   TraceRoleServer,
+		Protocol:    zkmodel.ProtocolHTTP,
+	}
+	unknownTarget := SpanTarget{
+		ServiceName: common.ScenarioWorkloadGenericServiceNameKey,
+		Namespace:   common.ScenarioWorkloadGenericNamespaceKey,
+		Deployment:  common.ScenarioWorkloadGenericDeploymentKey,
+	}
+
+	tests := []struct {
+		name     string
+		workload zkmodel.Workload
+		target   SpanTarget
+		// wantSkip is part of the skip reason, or empty when the workload is evaluated.
+		wantSkip string
+	}{
+		{name: "any service", workload: zkmodel.Workload{Service: "*"}, target: target},
+		{name: "any deployment", workload: zkmodel.Workload{Service: "*/*"}, target: target},
+		{name: "empty selector", workload: zkmodel.Workload{Service: ""}, target: target},
+		{name: "exact service", workload: zkmodel.Workload{Service: "checkout"}, target: target},
+		{name: "other service", workload: zkmodel.Workload{Service: "cart"}, target: target, wantSkip: "workload service"},
+		{name: "service glob", workload: zkmodel.Workload{Service: "check*"}, target: target},
+		{name: "namespace and deployment globs", workload: zkmodel.Workload{Service: "ns-*/api-?"}, target: target},
+		{name: "deployment glob mismatch", workload: zkmodel.Workload{Service: "ns-*/api-?"}, target: SpanTarget{ServiceName: "checkout", Namespace: "ns-prod", Deployment: "api-10"}, wantSkip: "workload deployment"},
+		{name: "namespace mismatch", workload: zkmodel.Workload{Service: "ns-dev/api-1"}, target: target, wantSkip: "workload namespace"},
+		{name: "deployment mismatch", workload: zkmodel.Workload{Service: "ns-prod/api-2"}, target: target, wantSkip: "workload deployment"},
+		{name: "empty namespace", workload: zkmodel.Workload{Service: "/api-1"}, target: target},
+		{name: "invalid pattern", workload: zkmodel.Workload{Service: "[checkout"}, target: target, wantSkip: "workload service"},
+		{name: "invalid pattern equal to service", workload: zkmodel.Workload{Service: "[checkout"}, target: SpanTarget{ServiceName: "[checkout"}},
+		{name: "unknown service", workload: zkmodel.Workload{Service: "cart"}, target: unknownTarget},
+		{name: "unknown deployment", workload: zkmodel.Workload{Service: "ns-dev/api-2"}, target: unknownTarget},
+		{name: "trace role", workload: zkmodel.Workload{Service: "checkout", TraceRole: "server"}, target: target},
+		{name: "trace role in upper case", workload: zkmodel.Workload{Service: "checkout", TraceRole: "SERVER"}, target: target},
+		{name: "trace role mismatch", workload: zkmodel.Workload{Service: "checkout", TraceRole: "client"}, target: target, wantSkip: "workload trace role"},
+		{name: "unknown trace role", workload: zkmodel.Workload{Service: "cart", TraceRole: "client"}, target: unknownTarget},
+		{name: "protocol", workload: zkmodel.Workload{Service: "checkout", Protocol: zkmodel.ProtocolHTTP}, target: target},
+		{name: "general protocol", workload: zkmodel.Workload{Service: "checkout", Protocol: zkmodel.ProtocolGeneral}, target: target},
+		{name: "protocol mismatch", workload: zkmodel.Workload{Service: "checkout", Protocol: zkmodel.ProtocolGRPC}, target: target, wantSkip: "workload protocol"},
+		{name: "http workload on grpc span", workload: zkmodel.Workload{Service: "checkout", Protocol: zkmodel.ProtocolHTTP}, target: SpanTarget{ServiceName: "checkout", Protocol: zkmodel.ProtocolGRPC}},
+		{name: "unknown protocol", workload: zkmodel.Workload{Service: "cart", Protocol: zkmodel.ProtocolGRPC}, target: unknownTarget},
+		{name: "mysql workload on db span", workload: zkmodel.Workload{Service: "checkout", Protocol: zkmodel.MYSQL}, target: SpanTarget{ServiceName: "checkout", Protocol: "DB"}},
+		{name: "mysql workload on mysql span", workload: zkmodel.Workload{Service: "checkout", Protocol: zkmodel.MYSQL}, target: SpanTarget{ServiceName: "checkout", Protocol: zkmodel.MYSQL}},
+		{name: "mysql workload on http span", workload: zkmodel.Workload{Service: "checkout", Protocol: zkmodel.MYSQL}, target: target, wantSkip: "workload protocol"},
+		{name: "http workload on messaging span", workload: zkmodel.Workload{Service: "checkout", Protocol: zkmodel.ProtocolHTTP}, target: SpanTarget{ServiceName: "checkout", Protocol: "MESSAGING"}},
+		{name: "ebpf workload", workload: zkmodel.Workload{Executor: zkmodel.ExecutorEbpf, Service: "*"}, target: target, wantSkip: "workload executor"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			workload := test.workload
+			if len(workload.Executor) == 0 {
+				workload.Executor = zkmodel.ExecutorOTel
+			}
+			got := WorkloadSkipReason(workload, test.target)
+			if len(test.wantSkip) == 0 && len(got) > 0 {
+				t.Errorf("WorkloadSkipReason() = %q, want no skip", got)
+			}
+			if len(test.wantSkip) > 0 && !strings.HasPrefix(got, test.wantSkip) {
+				t.Errorf("WorkloadSkipReason() = %q, want a skip starting with %q", got, test.wantSkip)
+			}
+		})
+	}
+}
+
+func TestGlobMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{pattern: "", value: "checkout", want: true},
+		{pattern: "*", value: "checkout", want: true},
+		{pattern: "checkout", value: "checkout", want: true},
+		{pattern: "checkout", value: "checkout-v2", want: false},
+		{pattern: "api-?", value: "api-1", want: true},
+		{pattern: "api-?", value: "api-10", want: false},
+		{pattern: "api-[0-9]", value: "api-7", want: true},
+		{pattern: "[api", value: "[api", want: true},
+		{pattern: "[api", value: "api", want: false},
+		{pattern: "api-?", value: "*", want: true},
+		{pattern: "api-?", value: "", want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.pattern+"|"+test.value, func(t *testing.T) {
+			if got := globMatches(test.pattern, test.value, "*"); got != test.want {
+				t.Errorf("globMatches(%q, %q) = %v, want %v", test.pattern, test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func BenchmarkCandidates(b *testing.B) {
+	plan := NewEvaluationPlan(planScenarios(10, 20))
+	target := SpanTarget{
+		ServiceName: "service-12",
+		Namespace:   "ns-1",
+		Deployment:  "deployment-12",
+		TraceRole:   TraceRoleServer,
+		Protocol:    zkmodel.ProtocolHTTP,
+	}
+	b.Run("index", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			plan.lookup(target)
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			plan.Candidates(target)
+		}
+	})
+}
@@ -0,0 +1,217 @@
+package scenario
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/zerok-ai/zk-observer/config"
+	promMetrics "github.com/zerok-ai/zk-observer/metrics"
+	logger "github.com/zerok-ai/zk-utils-go/logs"
+	zkmodel "github.com/zerok-ai/zk-utils-go/scenario/model"
+	zktick "github.com/zerok-ai/zk-utils-go/ticker"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var fileScenarioSourceLogTag = "FileScenarioSource"
+
+const defaultPollDuration = 30
+
+// Precedence of the scenarios, when a scenario id is both in the directory and in Redis.
+const (
+	PrecedenceFile  = "file"
+	PrecedenceRedis = "redis"
+)
+
+// FileScenarioSource loads scenarios from the YAML and JSON files of a directory, like a mounted ConfigMap. A
+// file holds one scenario or a list of them. The directory is polled, and reloaded when a file is added,
+// removed or modified. Hidden files and directories, like the ones of ConfigMap mounts, are skipped.
+type FileScenarioSource struct {
+	dir        string
+	precedence string
+	mutex      sync.RWMutex
+	scenarios  map[string]*zkmodel.Scenario
+	// fileScenarios holds the scenarios of the last successful load of every file.
+	fileScenarios map[string][]*zkmodel.Scenario
+	signature     string
+	ticker        *zktick.TickerTask
+}
+
+func NewFileScenarioSource(cfg config.ScenarioFilesConfig) (*FileScenarioSource, error) {
+	source := FileScenarioSource{
+		dir:           cfg.Dir,
+		precedence:    cfg.Precedence,
+		scenarios:     map[string]*zkmodel.Scenario{},
+		fileScenarios: map[string][]*zkmodel.Scenario{},
+	}
+	if len(source.precedence) == 0 {
+		source.precedence = PrecedenceFile
+	}
+	if source.precedence != PrecedenceFile && source.precedence != PrecedenceRedis {
+		err := fmt.Errorf("unknown scenario precedence %s", cfg.Precedence)
+		logger.Error(fileScenarioSourceLogTag, "Error while creating file scenario source ", err)
+		return nil, err
+	}
+	if info, err := os.Stat(cfg.Dir); err != nil || !info.IsDir() {
+		err = fmt.Errorf("scenario directory %s is not a readable directory", cfg.Dir)
+		logger.Error(fileScenarioSourceLogTag, "Error while creating file scenario source ", err)
+		return nil, err
+	}
+
+	pollDuration := cfg.PollDuration
+	if pollDuration <= 0 {
+		pollDuration = defaultPollDuration
+	}
+	source.reload()
+	source.ticker = zktick.GetNewTickerTask("scenario_files", time.Duration(pollDuration)*time.Second, source.reload)
+	source.ticker.Start()
+	return &source, nil
+}
+
+// GetAllValues returns the scenarios of the last successful load of every file, by id.
+func (s *FileScenarioSource) GetAllValues() map[string]*zkmodel.Scenario {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.scenarios
+}
+
+// Merge merges the Redis scenarios with the file scenarios by id, following the precedence. A disabled file
+// scenario which takes precedence removes the scenario with its id, so that Redis scenarios can be turned off
+// locally.
+func (s *FileScenarioSource) Merge(redisScenarios map[string]*zkmodel.Scenario) map[string]*zkmodel.Scenario {
+	fileScenarios := s.GetAllValues()
+	scenarios := make(map[string]*zkmodel.Scenario, len(redisScenarios)+len(fileScenarios))
+	for id, scenario := range redisScenarios {
+		scenarios[id] = scenario
+	}
+	for id, scenario := range fileScenarios {
+		if _, inRedis := scenarios[id]; inRedis && s.precedence == PrecedenceRedis {
+			continue
+		}
+		if !scenario.Enabled {
+			delete(scenarios, id)
+			continue
+		}
+		scenarios[id] = scenario
+	}
+	return scenarios
+}
+
+// reload loads the directory again when its signature changed. A file which fails to load keeps its scenarios
+// of the last successful load, so that an edit in progress does not drop them.
+func (s *FileScenarioSource) reload() {
+	files, signature, err := s.listFiles()
+	if err != nil {
+		logger.Error(fileScenarioSourceLogTag, "Error while listing scenario directory ", s.dir, " error: ", err)
+		promMetrics.ScenarioFileErrors.WithLabelValues(s.dir).Inc()
+		return
+	}
+	s.mutex.RLock()
+	unchanged := signature == s.signature
+	s.mutex.RUnlock()
+	if unchanged {
+		return
+	}
+
+	fileScenarios := map[string][]*zkmodel.Scenario{}
+	for _, file := range files {
+		loadedScenarios, err := loadScenarioFile(file)
+		if err != nil {
+			logger.Error(fileScenarioSourceLogTag, "Error while loading scenario file ", file, " error: ", err)
+			promMetrics.ScenarioFileErrors.WithLabelValues(file).Inc()
+			loadedScenarios = s.fileScenarios[file]
+		}
+		fileScenarios[file] = loadedScenarios
+	}
+
+	scenarios := map[string]*zkmodel.Scenario{}
+	scenarioFiles := map[string]string{}
+	for _, file := range files {
+		for _, scenario := range fileScenarios[file] {
+			if otherFile, ok := scenarioFiles[scenario.Id]; ok {
+				logger.Error(fileScenarioSourceLogTag, "Scenario ", scenario.Id, " of ", file, " is ignored, as it is also defined in ", otherFile)
+				continue
+			}
+			scenarioFiles[scenario.Id] = file
+			scenarios[scenario.Id] = scenario
+		}
+	}
+
+	s.mutex.Lock()
+	s.scenarios = scenarios
+	s.fileScenarios = fileScenarios
+	s.signature = signature
+	s.mutex.Unlock()
+	promMetrics.ScenarioFileScenarios.Set(float64(len(scenarios)))
+	logger.Info(fileScenarioSourceLogTag, "Loaded ", len(scenarios), " scenarios from ", s.dir)
+}
+
+// listFiles returns the scenario files of the directory, and a signature which changes with their names, sizes
+// and modification times.
+func (s *FileScenarioSource) listFiles() ([]string, string, error) {
+	var files []string
+	var signature strings.Builder
+	err := filepath.WalkDir(s.dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(entry.Name(), ".") && path != s.dir {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml", ".json":
+		default:
+			return nil
+		}
+		// ConfigMap files are symlinks to the hidden data directory, so the target is checked.
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		files = append(files, path)
+		signature.WriteString(fmt.Sprintf("%s|%d|%d\n", path, info.Size(), info.ModTime().UnixNano()))
+		return nil
+	})
+	sort.Strings(files)
+	return files, signature.String(), err
+}
+
+// loadScenarioFile reads the scenarios of a file, which must all have an id.
+func loadScenarioFile(file string) ([]*zkmodel.Scenario, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	// Scenarios only have json tags, so yaml files are converted to json first.
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var scenarios []*zkmodel.Scenario
+	trimmed := strings.TrimSpace(string(jsonData))
+	if strings.HasPrefix(trimmed, "[") {
+		err = json.Unmarshal(jsonData, &scenarios)
+	} else if trimmed != "null" && len(trimmed) > 0 {
+		var scenario zkmodel.Scenario
+		err = json.Unmarshal(jsonData, &scenario)
+		scenarios = append(scenarios, &scenario)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, scenario := range scenarios {
+		if scenario == nil || len(scenario.Id) == 0 {
+			return nil, fmt.Errorf("scenario without scenario_id")
+		}
+	}
+	return scenarios, nil
+}
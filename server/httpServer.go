@@ -1,16 +1,21 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/golang/protobuf/proto"
 	"github.com/kataras/iris/v12"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/zerok-ai/zk-observer/config"
 	"github.com/zerok-ai/zk-observer/handler"
 	promMetrics "github.com/zerok-ai/zk-observer/metrics"
 	logger "github.com/zerok-ai/zk-utils-go/logs"
+	zkmodel "github.com/zerok-ai/zk-utils-go/scenario/model"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 )
 
@@ -29,13 +34,19 @@ func NewHTTPServer() *HTTPServer {
 }
 
 func (s *HTTPServer) ConfigureRoutes(traceHandler *handler.TraceHandler) {
-	s.app.Get("/metrics", iris.FromStd(promhttp.Handler()))
+	// OpenMetrics is negotiated for scrapers asking for it, as exemplars are only exposed in that format.
+	s.app.Get("/metrics", iris.FromStd(promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))))
 	s.app.Get("/debug/vars", iris.FromStd(http.DefaultServeMux))
 	s.app.Get("/healthz", func(ctx iris.Context) {
 		ctx.StatusCode(iris.StatusOK)
 	})
 	s.app.Post("/v1/traces", traceHandler.ServeHTTP)
 	configureBadgerGetStreamAPI(s.app, traceHandler)
+	configureServiceGraphAPI(s.app, traceHandler)
+	configureServiceListAPI(s.app, traceHandler)
+	configureExceptionsAPI(s.app, traceHandler)
+	configureScenariosAPI(s.app, traceHandler)
 }
 
 func (s *HTTPServer) Run(otlpConfig config.OtlpConfig) error {
@@ -126,4 +137,201 @@ func configureBadgerGetStreamAPI(app *iris.Application, traceHandler *handler.Tr
 		promMetrics.TotalFetchRequestsFromSMSuccess.WithLabelValues(podIp).Inc()
 
 	}).Describe("Badger Data Fetch API")
+
+	app.Post("get-zk-trace-data", func(ctx iris.Context) {
+		var inputList []string
+		promMetrics.TotalFetchRequestsFromSM.WithLabelValues(podIp).Inc()
+		if err := ctx.ReadJSON(&inputList); err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			err := ctx.JSON(iris.Map{"error": "Invalid JSON input"})
+			if err != nil {
+				promMetrics.TotalFetchRequestsFromSMError.WithLabelValues(podIp).Inc()
+				logger.Error(httpServerLogTag, "Invalid request format for fetching zk spans for trace prefix list ", err)
+			}
+			return
+		}
+
+		promMetrics.TotalTracesSpanDataRequestedFromReceiver.WithLabelValues(podIp).Add(float64(len(inputList)))
+
+		data, err2 := traceHandler.GetBulkZkDataFromBadgerForPrefix(inputList)
+		if err2 != nil {
+			promMetrics.TotalFetchRequestsFromSMError.WithLabelValues(podIp).Inc()
+			logger.Error(httpServerLogTag, fmt.Sprintf("Unable to fetch zk spans from badger for tracePrefixList: %s", inputList), err2)
+			ctx.StatusCode(iris.StatusInternalServerError)
+			return
+		}
+		ctx.StatusCode(iris.StatusOK)
+
+		protoData, err := proto.Marshal(data)
+		if err != nil {
+			promMetrics.TotalFetchRequestsFromSMError.WithLabelValues(podIp).Inc()
+			logger.Error(httpServerLogTag, fmt.Sprintf("Unable to encode zk spans for tracePrefixList: %s", inputList), err)
+			return
+		}
+		ctx.ContentType("application/octet-stream")
+		_, err = ctx.Write(protoData)
+		if err != nil {
+			logger.Error(httpServerLogTag, fmt.Sprintf("Unable to write zk spans for trace prefix list: %s", inputList), err)
+			return
+		}
+		promMetrics.TotalFetchRequestsFromSMSuccess.WithLabelValues(podIp).Inc()
+
+	}).Describe("Badger Zk Span Fetch API")
+}
+
+// parseTimeRange reads the time range of a query from the end and lookback params. end is a unix timestamp in
+// seconds and lookback a duration in seconds. They default to now and an hour. It writes a bad request
+// response when a param is invalid.
+func parseTimeRange(ctx iris.Context) (time.Time, time.Time, bool) {
+	end := time.Now()
+	if endParam := ctx.URLParamDefault("end", ""); len(endParam) > 0 {
+		endSeconds, err := strconv.ParseInt(endParam, 10, 64)
+		if err != nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			_ = ctx.JSON(iris.Map{"error": "Invalid end"})
+			return time.Time{}, time.Time{}, false
+		}
+		end = time.Unix(endSeconds, 0)
+	}
+	lookback := ctx.URLParamInt64Default("lookback", 3600)
+	if lookback <= 0 {
+		ctx.StatusCode(iris.StatusBadRequest)
+		_ = ctx.JSON(iris.Map{"error": "Invalid lookback"})
+		return time.Time{}, time.Time{}, false
+	}
+	return end.Add(-time.Duration(lookback) * time.Second), end, true
+}
+
+func configureServiceGraphAPI(app *iris.Application, traceHandler *handler.TraceHandler) {
+	app.Get("/api/v1/dependencies", func(ctx iris.Context) {
+		from, to, ok := parseTimeRange(ctx)
+		if !ok {
+			return
+		}
+
+		dependencies, err := traceHandler.GetServiceDependencies(from, to)
+		if errors.Is(err, handler.ErrServiceGraphDisabled) {
+			ctx.StatusCode(iris.StatusNotFound)
+			_ = ctx.JSON(iris.Map{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			logger.Error(httpServerLogTag, "Unable to get service dependencies ", err)
+			ctx.StatusCode(iris.StatusInternalServerError)
+			return
+		}
+		ctx.StatusCode(iris.StatusOK)
+		_ = ctx.JSON(iris.Map{"dependencies": dependencies})
+	}).Describe("Service Dependencies API")
+}
+
+func configureExceptionsAPI(app *iris.Application, traceHandler *handler.TraceHandler) {
+	// service is optional, and limit defaults to 100 exceptions with the highest counts.
+	app.Get("/api/v1/exceptions", func(ctx iris.Context) {
+		from, to, ok := parseTimeRange(ctx)
+		if !ok {
+			return
+		}
+		limit := ctx.URLParamIntDefault("limit", 100)
+		if limit <= 0 {
+			ctx.StatusCode(iris.StatusBadRequest)
+			_ = ctx.JSON(iris.Map{"error": "Invalid limit"})
+			return
+		}
+
+		exceptions, err := traceHandler.GetExceptions(ctx.URLParamDefault("service", ""), from, to, limit)
+		if err != nil {
+			logger.Error(httpServerLogTag, "Unable to get exceptions ", err)
+			ctx.StatusCode(iris.StatusInternalServerError)
+			return
+		}
+		ctx.StatusCode(iris.StatusOK)
+		_ = ctx.JSON(iris.Map{"exceptions": exceptions})
+	}).Describe("Exceptions API")
+
+	app.Get("/api/v1/exceptions/{hash:string}", func(ctx iris.Context) {
+		groupHash := ctx.Params().Get("hash")
+		exception, err := traceHandler.GetException(groupHash)
+		if err != nil {
+			logger.Error(httpServerLogTag, "Unable to get exception ", groupHash, " ", err)
+			ctx.StatusCode(iris.StatusInternalServerError)
+			return
+		}
+		if exception == nil {
+			ctx.StatusCode(iris.StatusNotFound)
+			_ = ctx.JSON(iris.Map{"error": "Exception not found"})
+			return
+		}
+		ctx.StatusCode(iris.StatusOK)
+		_ = ctx.JSON(exception)
+	}).Describe("Exception Details API")
+}
+
+// scenarioEvaluationRequest holds the scenario to evaluate, and either OTLP JSON traces or the id of a trace
+// stored in Badger.
+type scenarioEvaluationRequest struct {
+	Scenario *zkmodel.Scenario `json:"scenario"`
+	Traces   json.RawMessage   `json:"traces"`
+	TraceId  string            `json:"trace_id"`
+}
+
+func configureScenariosAPI(app *iris.Application, traceHandler *handler.TraceHandler) {
+	app.Post("/api/v1/scenarios/evaluate", func(ctx iris.Context) {
+		var request scenarioEvaluationRequest
+		if err := ctx.ReadJSON(&request); err != nil || request.Scenario == nil {
+			ctx.StatusCode(iris.StatusBadRequest)
+			_ = ctx.JSON(iris.Map{"error": "Invalid JSON input, a scenario is required"})
+			return
+		}
+		hasTraces := len(request.Traces) > 0 && string(request.Traces) != "null"
+		if hasTraces == (len(request.TraceId) > 0) {
+			ctx.StatusCode(iris.StatusBadRequest)
+			_ = ctx.JSON(iris.Map{"error": "Either traces or trace_id is required"})
+			return
+		}
+
+		if hasTraces {
+			resourceSpans, err := handler.ParseTracesJSON(request.Traces)
+			if err != nil {
+				ctx.StatusCode(iris.StatusBadRequest)
+				_ = ctx.JSON(iris.Map{"error": "Invalid traces: " + err.Error()})
+				return
+			}
+			ctx.StatusCode(iris.StatusOK)
+			_ = ctx.JSON(traceHandler.EvaluateScenario(request.Scenario, resourceSpans))
+			return
+		}
+
+		evaluation, err := traceHandler.EvaluateScenarioForTrace(request.Scenario, request.TraceId)
+		if errors.Is(err, handler.ErrTraceNotFound) {
+			ctx.StatusCode(iris.StatusNotFound)
+			_ = ctx.JSON(iris.Map{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			logger.Error(httpServerLogTag, "Unable to evaluate scenario on trace ", request.TraceId, " ", err)
+			ctx.StatusCode(iris.StatusInternalServerError)
+			return
+		}
+		ctx.StatusCode(iris.StatusOK)
+		_ = ctx.JSON(evaluation)
+	}).Describe("Scenario Evaluation API")
+
+	app.Get("/api/v1/scenarios/status", func(ctx iris.Context) {
+		ctx.StatusCode(iris.StatusOK)
+		_ = ctx.JSON(iris.Map{"scenarios": traceHandler.GetScenarioStatus()})
+	}).Describe("Scenario Status API")
+}
+
+func configureServiceListAPI(app *iris.Application, traceHandler *handler.TraceHandler) {
+	app.Get("/api/v1/services", func(ctx iris.Context) {
+		services, err := traceHandler.GetServices()
+		if err != nil {
+			logger.Error(httpServerLogTag, "Unable to get services ", err)
+			ctx.StatusCode(iris.StatusInternalServerError)
+			return
+		}
+		ctx.StatusCode(iris.StatusOK)
+		_ = ctx.JSON(iris.Map{"services": services})
+	}).Describe("Service List API")
 }
@@ -0,0 +1,391 @@
+package servicegraph
+
+import (
+	"fmt"
+	"github.com/zerok-ai/zk-observer/common"
+	"github.com/zerok-ai/zk-observer/config"
+	promMetrics "github.com/zerok-ai/zk-observer/metrics"
+	"github.com/zerok-ai/zk-observer/model"
+	logger "github.com/zerok-ai/zk-utils-go/logs"
+	zktick "github.com/zerok-ai/zk-utils-go/ticker"
+	"sort"
+	"sync"
+	"time"
+)
+
+var serviceGraphLogTag = "ServiceGraph"
+
+const (
+	defaultWait           = 10
+	defaultMaxItems       = 10000
+	defaultBucketDuration = 60
+	defaultSyncDuration   = 5
+	defaultTtl            = 3600
+)
+
+var defaultPeerAttributes = []string{"peer.service", "db.name", "db.system", "server.address", "net.peer.name"}
+
+// EdgeStore keeps the edges of the service graph by time bucket, and the sides of requests waiting for their
+// pair, shared between the observer pods.
+type EdgeStore interface {
+	PutEdges(bucketStart int64, edges []*model.ServiceGraphEdge) error
+	GetEdges(bucketStarts []int64) ([]*model.ServiceGraphEdge, error)
+	// PairEdgeSides stores each side for expiry, unless the other side of its request is stored. That side is
+	// then removed and returned at the same index.
+	PairEdgeSides(sides []*model.ServiceGraphEdgeSide, expiry time.Duration) ([]*model.ServiceGraphEdgeSide, error)
+	// ClaimEdgeSides removes the stored sides, and tells which ones were still there, so not paired by another pod.
+	ClaimEdgeSides(sides []*model.ServiceGraphEdgeSide) ([]bool, error)
+}
+
+// pendingEdge is one side of a request waiting for the span of the other side.
+type pendingEdge struct {
+	key             string
+	clientService   string
+	serverService   string
+	connectionType  string
+	clientLatencyUs int64
+	serverLatencyUs int64
+	hasClient       bool
+	hasServer       bool
+	failed          bool
+	peer            string
+	expiry          time.Time
+}
+
+// storedSide is a side of a request handed to the edge store, which another pod may pair until the expiry.
+type storedSide struct {
+	side   *model.ServiceGraphEdgeSide
+	expiry time.Time
+}
+
+// ServiceGraph pairs the client span of a request with the server span it caused, which is the child of the
+// client span in another service. Producer and consumer spans are paired the same way. The spans of a request
+// are paired on the pod when both reach it within the wait. Otherwise the side is shared through the edge store
+// for another wait, as the other span may have reached the pod of another node. Client spans which find no
+// server span then become edges to a virtual node named by a peer attribute.
+type ServiceGraph struct {
+	cfg     config.ServiceGraphConfig
+	store   EdgeStore
+	mutex   sync.Mutex
+	pending map[string]*pendingEdge
+	edges   map[int64]map[string]*model.ServiceGraphEdge
+	// stored holds the sides in the edge store by expiry. It is only used by the sync ticker.
+	stored []storedSide
+	ticker *zktick.TickerTask
+}
+
+func NewServiceGraph(cfg config.ServiceGraphConfig, store EdgeStore) *ServiceGraph {
+	if cfg.Wait <= 0 {
+		cfg.Wait = defaultWait
+	}
+	if cfg.MaxItems <= 0 {
+		cfg.MaxItems = defaultMaxItems
+	}
+	if cfg.BucketDuration <= 0 {
+		cfg.BucketDuration = defaultBucketDuration
+	}
+	if cfg.SyncDuration <= 0 {
+		cfg.SyncDuration = defaultSyncDuration
+	}
+	if cfg.Ttl <= 0 {
+		cfg.Ttl = defaultTtl
+	}
+	if len(cfg.PeerAttributes) == 0 {
+		cfg.PeerAttributes = defaultPeerAttributes
+	}
+
+	serviceGraph := &ServiceGraph{
+		cfg:     cfg,
+		store:   store,
+		pending: map[string]*pendingEdge{},
+		edges:   map[int64]map[string]*model.ServiceGraphEdge{},
+	}
+	serviceGraph.ticker = zktick.GetNewTickerTask("service_graph", time.Duration(cfg.SyncDuration)*time.Second, serviceGraph.sync)
+	serviceGraph.ticker.Start()
+	return serviceGraph
+}
+
+// ConsumeSpan adds a span to the graph. Internal spans are ignored.
+func (g *ServiceGraph) ConsumeSpan(traceId string, spanId string, parentSpanId string, spanKind model.SpanKind, serviceName string, failed bool, latencyNs uint64, spanAttributes map[string]interface{}) {
+	latencyUs := int64(latencyNs / 1000)
+	var key string
+	var isClient bool
+	connectionType := ""
+	switch spanKind {
+	case model.SpanKindClient, model.SpanKindProducer:
+		key = traceId + spanId
+		isClient = true
+	case model.SpanKindServer, model.SpanKindConsumer:
+		if len(parentSpanId) == 0 || parentSpanId == common.DefaultParentSpanId {
+			if spanKind == model.SpanKindServer {
+				g.recordEdge(model.ServiceGraphUserNode, serviceName, "", 0, latencyUs, false, true, failed)
+			}
+			return
+		}
+		key = traceId + parentSpanId
+	default:
+		return
+	}
+	if spanKind == model.SpanKindProducer || spanKind == model.SpanKindConsumer {
+		connectionType = model.ServiceGraphConnectionTypeMessaging
+	}
+
+	g.mutex.Lock()
+	edge, ok := g.pending[key]
+	if !ok {
+		if len(g.pending) >= g.cfg.MaxItems {
+			g.mutex.Unlock()
+			if isClient {
+				promMetrics.ServiceGraphDroppedSpans.WithLabelValues(serviceName, "").Inc()
+			} else {
+				promMetrics.ServiceGraphDroppedSpans.WithLabelValues("", serviceName).Inc()
+			}
+			return
+		}
+		edge = &pendingEdge{key: key, connectionType: connectionType, expiry: time.Now().Add(time.Duration(g.cfg.Wait) * time.Second)}
+		g.pending[key] = edge
+	}
+	if isClient {
+		edge.clientService = serviceName
+		edge.clientLatencyUs = latencyUs
+		edge.hasClient = true
+		edge.peer = g.peerName(spanAttributes)
+	} else {
+		edge.serverService = serviceName
+		edge.serverLatencyUs = latencyUs
+		edge.hasServer = true
+	}
+	edge.failed = edge.failed || failed
+	complete := edge.hasClient && edge.hasServer
+	if complete {
+		delete(g.pending, key)
+	}
+	g.mutex.Unlock()
+
+	if complete {
+		g.recordEdge(edge.clientService, edge.serverService, edge.connectionType, edge.clientLatencyUs, edge.serverLatencyUs, true, true, edge.failed)
+	}
+}
+
+func (g *ServiceGraph) peerName(spanAttributes map[string]interface{}) string {
+	for _, key := range g.cfg.PeerAttributes {
+		if value, ok := spanAttributes[key]; ok {
+			if peer := fmt.Sprintf("%v", value); len(peer) > 0 {
+				return peer
+			}
+		}
+	}
+	return ""
+}
+
+// recordEdge updates the metrics and the edge of the current time bucket. The latency stored with the edge is
+// the one seen by the client, when there is a client span.
+func (g *ServiceGraph) recordEdge(client string, server string, connectionType string, clientLatencyUs int64, serverLatencyUs int64, hasClient bool, hasServer bool, failed bool) {
+	promMetrics.ServiceGraphRequests.WithLabelValues(client, server, connectionType).Inc()
+	if failed {
+		promMetrics.ServiceGraphRequestsFailed.WithLabelValues(client, server, connectionType).Inc()
+	}
+	if hasClient {
+		promMetrics.ServiceGraphRequestClientSeconds.WithLabelValues(client, server, connectionType).Observe(float64(clientLatencyUs) / 1e6)
+	}
+	if hasServer {
+		promMetrics.ServiceGraphRequestServerSeconds.WithLabelValues(client, server, connectionType).Observe(float64(serverLatencyUs) / 1e6)
+	}
+
+	latencyUs := serverLatencyUs
+	if hasClient {
+		latencyUs = clientLatencyUs
+	}
+	bucketStart := g.bucketStart(time.Now())
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	bucketEdges, ok := g.edges[bucketStart]
+	if !ok {
+		bucketEdges = map[string]*model.ServiceGraphEdge{}
+		g.edges[bucketStart] = bucketEdges
+	}
+	newEdge := model.NewServiceGraphEdge(client, server, connectionType)
+	edge, ok := bucketEdges[newEdge.EdgeId()]
+	if !ok {
+		edge = newEdge
+		bucketEdges[edge.EdgeId()] = edge
+	}
+	edge.AddRequest(latencyUs, failed)
+}
+
+func (g *ServiceGraph) bucketStart(t time.Time) int64 {
+	bucketDuration := int64(g.cfg.BucketDuration)
+	return t.Unix() / bucketDuration * bucketDuration
+}
+
+// sync expires the pending edges which waited long enough, pairs them through the edge store and writes the
+// recorded edges to the store.
+func (g *ServiceGraph) sync() {
+	now := time.Now()
+	var expiredSides []*model.ServiceGraphEdgeSide
+	g.mutex.Lock()
+	for key, edge := range g.pending {
+		if now.After(edge.expiry) {
+			expiredSides = append(expiredSides, edge.side())
+			delete(g.pending, key)
+		}
+	}
+	g.mutex.Unlock()
+
+	g.pairStoredSides(expiredSides, now)
+	g.claimStoredSides(now)
+
+	g.mutex.Lock()
+	edgesToStore := g.edges
+	g.edges = map[int64]map[string]*model.ServiceGraphEdge{}
+	g.mutex.Unlock()
+
+	for bucketStart, bucketEdges := range edgesToStore {
+		edges := make([]*model.ServiceGraphEdge, 0, len(bucketEdges))
+		for _, edge := range bucketEdges {
+			edges = append(edges, edge)
+		}
+		if err := g.store.PutEdges(bucketStart, edges); err != nil {
+			logger.Error(serviceGraphLogTag, "Error while storing service graph edges for bucket ", bucketStart, " error: ", err)
+		}
+	}
+}
+
+// side returns the side of an expired pending edge, which never has both sides.
+func (e *pendingEdge) side() *model.ServiceGraphEdgeSide {
+	side := model.ServiceGraphEdgeSide{Key: e.key, IsClient: e.hasClient, ConnectionType: e.connectionType, Failed: e.failed}
+	if e.hasClient {
+		side.Service = e.clientService
+		side.LatencyUs = e.clientLatencyUs
+		side.Peer = e.peer
+	} else {
+		side.Service = e.serverService
+		side.LatencyUs = e.serverLatencyUs
+	}
+	return &side
+}
+
+// pairStoredSides records the edges of the sides whose other side another pod stored, and stores the rest for
+// another wait.
+func (g *ServiceGraph) pairStoredSides(sides []*model.ServiceGraphEdgeSide, now time.Time) {
+	if len(sides) == 0 {
+		return
+	}
+	// The stored sides outlive the wait, so a pod claiming its side late does not miss it.
+	otherSides, err := g.store.PairEdgeSides(sides, time.Duration(3*g.cfg.Wait)*time.Second)
+	if err != nil {
+		logger.Error(serviceGraphLogTag, "Error while pairing service graph spans through the store ", err)
+		for _, side := range sides {
+			g.recordUnpaired(side)
+		}
+		return
+	}
+
+	expiry := now.Add(time.Duration(g.cfg.Wait) * time.Second)
+	for i, side := range sides {
+		otherSide := otherSides[i]
+		if otherSide != nil {
+			g.recordPair(side, otherSide)
+			continue
+		}
+		if len(g.stored) >= g.cfg.MaxItems {
+			g.recordUnpaired(side)
+			continue
+		}
+		g.stored = append(g.stored, storedSide{side: side, expiry: expiry})
+	}
+}
+
+// claimStoredSides takes back the stored sides which waited long enough. The ones still in the store were not
+// paired by another pod.
+func (g *ServiceGraph) claimStoredSides(now time.Time) {
+	expired := 0
+	for expired < len(g.stored) && now.After(g.stored[expired].expiry) {
+		expired++
+	}
+	if expired == 0 {
+		return
+	}
+	sides := make([]*model.ServiceGraphEdgeSide, 0, expired)
+	for _, stored := range g.stored[:expired] {
+		sides = append(sides, stored.side)
+	}
+	g.stored = g.stored[expired:]
+
+	unpaired, err := g.store.ClaimEdgeSides(sides)
+	if err != nil {
+		logger.Error(serviceGraphLogTag, "Error while claiming service graph spans from the store ", err)
+		return
+	}
+	for i, side := range sides {
+		if unpaired[i] {
+			g.recordUnpaired(side)
+		}
+	}
+}
+
+func (g *ServiceGraph) recordPair(side *model.ServiceGraphEdgeSide, otherSide *model.ServiceGraphEdgeSide) {
+	client, server := side, otherSide
+	if !side.IsClient {
+		client, server = otherSide, side
+	}
+	g.recordEdge(client.Service, server.Service, client.ConnectionType, client.LatencyUs, server.LatencyUs, true, true, client.Failed || server.Failed)
+}
+
+// recordUnpaired records the edge to the peer of a client side, or counts the side as unpaired.
+func (g *ServiceGraph) recordUnpaired(side *model.ServiceGraphEdgeSide) {
+	if side.IsClient && len(side.Peer) > 0 {
+		g.recordEdge(side.Service, side.Peer, model.ServiceGraphConnectionTypeVirtualNode, side.LatencyUs, 0, true, false, side.Failed)
+		return
+	}
+	if side.IsClient {
+		promMetrics.ServiceGraphUnpairedSpans.WithLabelValues(side.Service, "").Inc()
+	} else {
+		promMetrics.ServiceGraphUnpairedSpans.WithLabelValues("", side.Service).Inc()
+	}
+}
+
+// GetDependencies returns the edges recorded by all observer pods between from and to, limited to the
+// retention of the store. Edges of the last sync duration may not be included yet.
+func (g *ServiceGraph) GetDependencies(from time.Time, to time.Time) ([]model.ServiceDependency, error) {
+	retentionStart := time.Now().Add(-time.Duration(g.cfg.Ttl) * time.Second)
+	if from.Before(retentionStart) {
+		from = retentionStart
+	}
+	if to.Before(from) {
+		return nil, fmt.Errorf("the end of the time range %v is before its start %v", to, from)
+	}
+
+	var bucketStarts []int64
+	for bucketStart := g.bucketStart(from); bucketStart <= to.Unix(); bucketStart += int64(g.cfg.BucketDuration) {
+		bucketStarts = append(bucketStarts, bucketStart)
+	}
+	edges, err := g.store.GetEdges(bucketStarts)
+	if err != nil {
+		logger.Error(serviceGraphLogTag, "Error while getting service graph edges ", err)
+		return nil, err
+	}
+
+	mergedEdges := map[string]*model.ServiceGraphEdge{}
+	for _, edge := range edges {
+		mergedEdge, ok := mergedEdges[edge.EdgeId()]
+		if !ok {
+			mergedEdge = model.NewServiceGraphEdge(edge.Client, edge.Server, edge.ConnectionType)
+			mergedEdges[edge.EdgeId()] = mergedEdge
+		}
+		mergedEdge.Merge(edge)
+	}
+
+	dependencies := make([]model.ServiceDependency, 0, len(mergedEdges))
+	for _, edge := range mergedEdges {
+		dependencies = append(dependencies, edge.ToDependency())
+	}
+	sort.Slice(dependencies, func(i, j int) bool {
+		if dependencies[i].Client != dependencies[j].Client {
+			return dependencies[i].Client < dependencies[j].Client
+		}
+		return dependencies[i].Server < dependencies[j].Server
+	})
+	return dependencies, nil
+}
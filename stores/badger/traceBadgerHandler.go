@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 	"github.com/golang/protobuf/proto"
+	"github.com/zerok-ai/zk-observer/common"
 	"github.com/zerok-ai/zk-observer/config"
+	"github.com/zerok-ai/zk-observer/proto/zkspan"
 	logger "github.com/zerok-ai/zk-utils-go/logs"
 	__ "github.com/zerok-ai/zk-utils-go/proto/opentelemetry"
 	"github.com/zerok-ai/zk-utils-go/storage/badger"
+	"strings"
 	"time"
 )
 
@@ -45,6 +48,17 @@ func (h *TraceBadgerHandler) PutTraceData(traceId string, spanId string, spanPro
 	return nil
 }
 
+// PutZkSpanData stores a zk span under a prefixed key, so that raw and zk spans of a trace can be stored together.
+func (h *TraceBadgerHandler) PutZkSpanData(traceId string, spanId string, zkSpanProto []byte) error {
+	key := common.ZkSpanKeyPrefix + traceId + "-" + spanId
+	if err := h.badgerHandler.Set(key, zkSpanProto, time.Duration(h.config.Traces.Ttl)*time.Second); err != nil {
+		logger.ErrorF(traceBadgerHandlerLogTag, "Error while setting zk span details for traceId %s: %v", traceId, err)
+		return err
+	}
+
+	return nil
+}
+
 func (h *TraceBadgerHandler) SyncPipeline() {
 	h.badgerHandler.StartCompaction()
 }
@@ -69,3 +83,30 @@ func (h *TraceBadgerHandler) GetBulkDataForPrefixList(prefixList []string) (map[
 
 	return finalResp, nil
 }
+
+// GetBulkZkSpansForPrefixList returns the zk spans of the given trace prefixes, keyed without the zk prefix.
+func (h *TraceBadgerHandler) GetBulkZkSpansForPrefixList(prefixList []string) (map[string]*zkspan.ZkSpan, error) {
+	zkPrefixList := make([]string, 0, len(prefixList))
+	for _, prefix := range prefixList {
+		zkPrefixList = append(zkPrefixList, common.ZkSpanKeyPrefix+prefix)
+	}
+
+	prefix, err := h.badgerHandler.BulkGetForPrefix(zkPrefixList)
+	if err != nil {
+		logger.Error(traceBadgerHandlerLogTag, fmt.Sprintf("Error while fetching zk spans from badger for given tracePrefixList: %v", prefixList), err)
+		return nil, err
+	}
+
+	finalResp := make(map[string]*zkspan.ZkSpan)
+	for k, value := range prefix {
+		var d zkspan.ZkSpan
+		err := proto.Unmarshal([]byte(value), &d)
+		if err != nil {
+			logger.Error(traceBadgerHandlerLogTag, fmt.Sprintf("Error while unmarshalling zk span from badger for given tracePrefixList: %v", prefixList), err)
+			continue
+		}
+		finalResp[strings.TrimPrefix(k, common.ZkSpanKeyPrefix)] = &d
+	}
+
+	return finalResp, nil
+}
@@ -1,24 +1,55 @@
 package redis
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/redis/go-redis/v9"
+	"github.com/zerok-ai/zk-observer/common"
 	"github.com/zerok-ai/zk-observer/config"
 	"github.com/zerok-ai/zk-observer/model"
 	zkcommon "github.com/zerok-ai/zk-utils-go/common"
 	logger "github.com/zerok-ai/zk-utils-go/logs"
 	"github.com/zerok-ai/zk-utils-go/storage/redis/clientDBNames"
+	zktick "github.com/zerok-ai/zk-utils-go/ticker"
 	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 )
 
 var exceptionLogTag = "ExceptionRedisHandler"
 
+const (
+	defaultExceptionMaxSamples = 10
+
+	exceptionTypeField            = "type"
+	exceptionMessageTemplateField = "message_template"
+	exceptionCountField           = "count"
+	exceptionFirstSeenField       = "first_seen"
+)
+
+// exceptionOccurrenceRecord holds the occurrences of an exception group since the last sync.
+type exceptionOccurrenceRecord struct {
+	exceptionType   string
+	messageTemplate string
+	count           int64
+	firstSeen       int64
+	lastSeen        int64
+	// services maps the services which threw the exception to the time they last did.
+	services map[string]int64
+	samples  []model.ExceptionSample
+}
+
 type ExceptionRedisHandler struct {
 	redisHandler          *RedisHandler
+	ctx                   context.Context
 	existingExceptionData sync.Map
 	otlpConfig            *config.OtlpConfig
+	occurrences           map[string]*exceptionOccurrenceRecord
+	occurrencesMutex      sync.Mutex
+	ticker                *zktick.TickerTask
 }
 
 func NewExceptionHandler(config *config.OtlpConfig) (*ExceptionRedisHandler, error) {
@@ -29,60 +60,334 @@ func NewExceptionHandler(config *config.OtlpConfig) (*ExceptionRedisHandler, err
 		return nil, err
 	}
 
+	if config.Exception.MaxSamples <= 0 {
+		config.Exception.MaxSamples = defaultExceptionMaxSamples
+	}
+
 	handler.redisHandler = exceptionRedisHandler
+	handler.ctx = context.Background()
 	handler.existingExceptionData = sync.Map{}
 	handler.otlpConfig = config
+	handler.occurrences = map[string]*exceptionOccurrenceRecord{}
+	handler.ticker = zktick.GetNewTickerTask("sync_exception_occurrences", time.Duration(config.Exception.SyncDuration)*time.Second, handler.syncOccurrences)
+	handler.ticker.Start()
 	return &handler, nil
 }
 
-func (h *ExceptionRedisHandler) SyncExceptionData(exception *model.ExceptionDetails, spanId string) (string, error) {
-	hash := ""
-	if len(exception.Stacktrace) > 0 {
-		err := h.redisHandler.CheckRedisConnection()
+// SyncExceptionData stores the exception under its raw hash, and its group under the group hash. Exceptions
+// without a stacktrace are hashed by their message and type.
+func (h *ExceptionRedisHandler) SyncExceptionData(exception *model.ExceptionDetails, group *model.ExceptionGroup, spanId string) (string, error) {
+	if len(exception.Stacktrace) == 0 && len(exception.Message) == 0 && len(exception.Type) == 0 {
+		logger.Error(exceptionLogTag, "Could not find stacktrace, message or type for exception for span Id ", spanId)
+		return "", fmt.Errorf("no stacktrace, message or type for the exception")
+	}
+	err := h.redisHandler.CheckRedisConnection()
+	if err != nil {
+		logger.Error(exceptionLogTag, "Error while checking redis conn ", err)
+		return "", err
+	}
+
+	hash := ExceptionHash(exception)
+	_, ok := h.existingExceptionData.Load(hash)
+	expiry := time.Duration(h.otlpConfig.Exception.Ttl) * time.Second
+	if !ok {
+		exceptionJSON, err := json.Marshal(exception)
 		if err != nil {
-			logger.Error(exceptionLogTag, "Error while checking redis conn ", err)
+			logger.ErrorF(exceptionLogTag, "Error encoding exception details for spanID %s: %v\n", spanId, err)
 			return "", err
 		}
-		hash = zkcommon.Generate256SHA(exception.Message, exception.Type, exception.Stacktrace)
-		_, ok := h.existingExceptionData.Load(hash)
-		expiry := time.Duration(h.otlpConfig.Exception.Ttl) * time.Second
-		if !ok {
-			exceptionJSON, err := json.Marshal(exception)
-			if err != nil {
-				logger.ErrorF(exceptionLogTag, "Error encoding exception details for spanID %s: %v\n", spanId, err)
-				return "", err
-			}
-			//Directly setting this to redis, because each resource will be only be written once. So no need to create a pipeline.
-			err = h.redisHandler.SetNXPipeline(hash, exceptionJSON, expiry)
-			if err != nil {
-				logger.ErrorF(exceptionLogTag, "Error while setting exception details for spanID %s: %v\n", spanId, err)
-				return "", err
-			}
-			h.existingExceptionData.Store(hash, true)
-		} else {
-			err = h.redisHandler.setExpiry(hash, expiry)
-			if err != nil {
-				logger.ErrorF(exceptionLogTag, "Error while setting expiry for exception details for spanID %s: %v\n", spanId, err)
-				return "", err
-			}
+		//Directly setting this to redis, because each resource will be only be written once. So no need to create a pipeline.
+		err = h.redisHandler.SetNXPipeline(hash, exceptionJSON, expiry)
+		if err != nil {
+			logger.ErrorF(exceptionLogTag, "Error while setting exception details for spanID %s: %v\n", spanId, err)
+			return "", err
 		}
+		h.existingExceptionData.Store(hash, true)
 	} else {
-		logger.Error(exceptionLogTag, "Could not find stacktrace for exception for span Id ", spanId)
-		return "", fmt.Errorf("no stacktrace for the expcetion")
+		err = h.redisHandler.setExpiry(hash, expiry)
+		if err != nil {
+			logger.ErrorF(exceptionLogTag, "Error while setting expiry for exception details for spanID %s: %v\n", spanId, err)
+			return "", err
+		}
+	}
+	if group != nil {
+		if err := h.syncExceptionGroup(group, expiry); err != nil {
+			logger.ErrorF(exceptionLogTag, "Error while setting exception group for spanID %s: %v\n", spanId, err)
+			return "", err
+		}
 	}
 	return hash, nil
 }
 
+// ExceptionHash returns the raw hash of the exception, of its message, type and stacktrace.
+func ExceptionHash(exception *model.ExceptionDetails) string {
+	if len(exception.Stacktrace) > 0 {
+		return zkcommon.Generate256SHA(exception.Message, exception.Type, exception.Stacktrace)
+	}
+	return zkcommon.Generate256SHA(exception.Message, exception.Type)
+}
+
+func (h *ExceptionRedisHandler) syncExceptionGroup(group *model.ExceptionGroup, expiry time.Duration) error {
+	key := common.ExceptionGroupKeyPrefix + group.GroupHash
+	if _, ok := h.existingExceptionData.Load(key); ok {
+		return h.redisHandler.setExpiry(key, expiry)
+	}
+	groupJSON, err := json.Marshal(group)
+	if err != nil {
+		return err
+	}
+	if err = h.redisHandler.SetNXPipeline(key, groupJSON, expiry); err != nil {
+		return err
+	}
+	h.existingExceptionData.Store(key, true)
+	return nil
+}
+
+// RecordOccurrence counts an occurrence of the exception group. The occurrences are written on the next sync.
+func (h *ExceptionRedisHandler) RecordOccurrence(group *model.ExceptionGroup, sample model.ExceptionSample) {
+	h.occurrencesMutex.Lock()
+	defer h.occurrencesMutex.Unlock()
+	record, ok := h.occurrences[group.GroupHash]
+	if !ok {
+		record = &exceptionOccurrenceRecord{
+			exceptionType:   group.Type,
+			messageTemplate: group.MessageTemplate,
+			firstSeen:       sample.Timestamp,
+			services:        map[string]int64{},
+		}
+		h.occurrences[group.GroupHash] = record
+	}
+	record.count++
+	if sample.Timestamp < record.firstSeen {
+		record.firstSeen = sample.Timestamp
+	}
+	if sample.Timestamp > record.lastSeen {
+		record.lastSeen = sample.Timestamp
+	}
+	if sample.Timestamp > record.services[sample.ServiceName] {
+		record.services[sample.ServiceName] = sample.Timestamp
+	}
+	record.samples = append(record.samples, sample)
+	if len(record.samples) > h.otlpConfig.Exception.MaxSamples {
+		record.samples = record.samples[len(record.samples)-h.otlpConfig.Exception.MaxSamples:]
+	}
+}
+
+// syncOccurrences adds the occurrences since the last sync to the occurrence hashes of the exception groups, and
+// updates the exception indexes. Index entries older than the ttl are removed.
+func (h *ExceptionRedisHandler) syncOccurrences() {
+	h.occurrencesMutex.Lock()
+	occurrences := h.occurrences
+	h.occurrences = map[string]*exceptionOccurrenceRecord{}
+	h.occurrencesMutex.Unlock()
+	if len(occurrences) == 0 {
+		return
+	}
+
+	if err := h.redisHandler.CheckRedisConnection(); err != nil {
+		logger.Error(exceptionLogTag, "Error while checking redis conn ", err)
+		return
+	}
+	ttl := time.Duration(h.otlpConfig.Exception.Ttl) * time.Second
+	indexKeys := map[string]bool{common.ExceptionIndexKey: true}
+	for groupHash, record := range occurrences {
+		if err := h.putOccurrences(groupHash, record, ttl); err != nil {
+			logger.Error(exceptionLogTag, "Error while setting occurrences of exception group ", groupHash, " error: ", err)
+			continue
+		}
+		for serviceName := range record.services {
+			indexKeys[exceptionServiceIndexKey(serviceName)] = true
+		}
+	}
+	if ttl > 0 {
+		expiredBefore := strconv.FormatInt(time.Now().Add(-ttl).Unix(), 10)
+		for indexKey := range indexKeys {
+			if err := h.redisHandler.ZRemRangeByScorePipeline(indexKey, "-inf", "("+expiredBefore); err != nil {
+				logger.Error(exceptionLogTag, "Error while removing expired exceptions from index ", indexKey, " error: ", err)
+			}
+		}
+	}
+	h.redisHandler.SyncPipeline()
+}
+
+func (h *ExceptionRedisHandler) putOccurrences(groupHash string, record *exceptionOccurrenceRecord, ttl time.Duration) error {
+	key := common.ExceptionOccurrenceKeyPrefix + groupHash
+	if err := h.redisHandler.HSetNXPipeline(key, exceptionFirstSeenField, record.firstSeen, ttl); err != nil {
+		return err
+	}
+	values := map[string]interface{}{
+		exceptionTypeField:            record.exceptionType,
+		exceptionMessageTemplateField: record.messageTemplate,
+	}
+	if err := h.redisHandler.HMSetPipeline(key, values, ttl); err != nil {
+		return err
+	}
+	if err := h.redisHandler.HIncrByPipeline(key, map[string]int64{exceptionCountField: record.count}, ttl); err != nil {
+		return err
+	}
+
+	for serviceName, lastSeen := range record.services {
+		if err := h.redisHandler.SAddPipeline(key+common.ExceptionServicesKeySuffix, serviceName, ttl); err != nil {
+			return err
+		}
+		if err := h.redisHandler.ZAddGTPipeline(exceptionServiceIndexKey(serviceName), groupHash, float64(lastSeen), ttl); err != nil {
+			return err
+		}
+	}
+	if err := h.redisHandler.ZAddGTPipeline(common.ExceptionIndexKey, groupHash, float64(record.lastSeen), ttl); err != nil {
+		return err
+	}
+
+	// The newest sample is pushed last, so that it is the head of the list.
+	samples := make([]interface{}, 0, len(record.samples))
+	for _, sample := range record.samples {
+		sampleJSON, err := json.Marshal(sample)
+		if err != nil {
+			return err
+		}
+		samples = append(samples, sampleJSON)
+	}
+	return h.redisHandler.LPushTrimPipeline(key+common.ExceptionSamplesKeySuffix, samples, int64(h.otlpConfig.Exception.MaxSamples), ttl)
+}
+
+func exceptionServiceIndexKey(serviceName string) string {
+	return common.ExceptionIndexKey + "_" + serviceName
+}
+
+// GetExceptions returns the exception groups seen between from and to, by the service when it is not empty,
+// sorted by their occurrence count. The counts are the totals within the ttl, not only the ones of the range.
+func (h *ExceptionRedisHandler) GetExceptions(serviceName string, from time.Time, to time.Time, limit int) ([]model.ExceptionSummary, error) {
+	if err := h.redisHandler.CheckRedisConnection(); err != nil {
+		logger.Error(exceptionLogTag, "Error while checking redis conn ", err)
+		return nil, err
+	}
+
+	indexKey := common.ExceptionIndexKey
+	if len(serviceName) > 0 {
+		indexKey = exceptionServiceIndexKey(serviceName)
+	}
+	indexEntries, err := h.redisHandler.RedisClient.ZRangeByScoreWithScores(h.ctx, indexKey, &redis.ZRangeBy{
+		Min: strconv.FormatInt(from.Unix(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		logger.Error(exceptionLogTag, "Error while getting exception index ", indexKey, " error: ", err)
+		return nil, err
+	}
+	exceptions := make([]model.ExceptionSummary, 0, len(indexEntries))
+	if len(indexEntries) == 0 {
+		return exceptions, nil
+	}
+
+	pipeline := h.redisHandler.RedisClient.Pipeline()
+	hashCmds := make([]*redis.MapStringStringCmd, len(indexEntries))
+	serviceCmds := make([]*redis.StringSliceCmd, len(indexEntries))
+	for i, entry := range indexEntries {
+		key := common.ExceptionOccurrenceKeyPrefix + entry.Member.(string)
+		hashCmds[i] = pipeline.HGetAll(h.ctx, key)
+		serviceCmds[i] = pipeline.SMembers(h.ctx, key+common.ExceptionServicesKeySuffix)
+	}
+	if _, err := pipeline.Exec(h.ctx); err != nil && err != redis.Nil {
+		logger.Error(exceptionLogTag, "Error while getting exception occurrences ", err)
+		return nil, err
+	}
+
+	for i, entry := range indexEntries {
+		values := hashCmds[i].Val()
+		if len(values) == 0 {
+			continue
+		}
+		summary := parseExceptionSummary(entry.Member.(string), values, serviceCmds[i].Val())
+		if summary.FirstSeen > to.Unix() {
+			continue
+		}
+		// The index holds the last time the exception was seen by any observer pod.
+		summary.LastSeen = int64(entry.Score)
+		exceptions = append(exceptions, summary)
+	}
+	sort.Slice(exceptions, func(i, j int) bool {
+		if exceptions[i].Count != exceptions[j].Count {
+			return exceptions[i].Count > exceptions[j].Count
+		}
+		return exceptions[i].LastSeen > exceptions[j].LastSeen
+	})
+	if limit > 0 && len(exceptions) > limit {
+		exceptions = exceptions[:limit]
+	}
+	return exceptions, nil
+}
+
+// GetException returns the occurrences of the exception group, with the group and its latest samples. It
+// returns nil when the group has no occurrences within the ttl.
+func (h *ExceptionRedisHandler) GetException(groupHash string) (*model.ExceptionOccurrences, error) {
+	if err := h.redisHandler.CheckRedisConnection(); err != nil {
+		logger.Error(exceptionLogTag, "Error while checking redis conn ", err)
+		return nil, err
+	}
+
+	key := common.ExceptionOccurrenceKeyPrefix + groupHash
+	pipeline := h.redisHandler.RedisClient.Pipeline()
+	hashCmd := pipeline.HGetAll(h.ctx, key)
+	servicesCmd := pipeline.SMembers(h.ctx, key+common.ExceptionServicesKeySuffix)
+	samplesCmd := pipeline.LRange(h.ctx, key+common.ExceptionSamplesKeySuffix, 0, -1)
+	groupCmd := pipeline.Get(h.ctx, common.ExceptionGroupKeyPrefix+groupHash)
+	lastSeenCmd := pipeline.ZScore(h.ctx, common.ExceptionIndexKey, groupHash)
+	if _, err := pipeline.Exec(h.ctx); err != nil && err != redis.Nil {
+		logger.Error(exceptionLogTag, "Error while getting occurrences of exception group ", groupHash, " error: ", err)
+		return nil, err
+	}
+	values := hashCmd.Val()
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	occurrences := model.ExceptionOccurrences{
+		ExceptionSummary: parseExceptionSummary(groupHash, values, servicesCmd.Val()),
+		Samples:          make([]model.ExceptionSample, 0),
+	}
+	occurrences.LastSeen = int64(lastSeenCmd.Val())
+	if groupJSON := groupCmd.Val(); len(groupJSON) > 0 {
+		var group model.ExceptionGroup
+		if err := json.Unmarshal([]byte(groupJSON), &group); err != nil {
+			logger.Error(exceptionLogTag, "Error while decoding exception group ", groupHash, " error: ", err)
+		} else {
+			occurrences.Group = &group
+		}
+	}
+	for _, sampleJSON := range samplesCmd.Val() {
+		var sample model.ExceptionSample
+		if err := json.Unmarshal([]byte(sampleJSON), &sample); err != nil {
+			logger.Error(exceptionLogTag, "Error while decoding exception sample of group ", groupHash, " error: ", err)
+			continue
+		}
+		occurrences.Samples = append(occurrences.Samples, sample)
+	}
+	return &occurrences, nil
+}
+
+func parseExceptionSummary(groupHash string, values map[string]string, services []string) model.ExceptionSummary {
+	summary := model.ExceptionSummary{
+		GroupHash:       groupHash,
+		Type:            values[exceptionTypeField],
+		MessageTemplate: values[exceptionMessageTemplateField],
+		Services:        services,
+	}
+	summary.Count, _ = strconv.ParseInt(values[exceptionCountField], 10, 64)
+	summary.FirstSeen, _ = strconv.ParseInt(values[exceptionFirstSeenField], 10, 64)
+	sort.Strings(summary.Services)
+	return summary
+}
+
 func CreateExceptionDetails(event *tracev1.Span_Event) *model.ExceptionDetails {
 	exceptionAttr := event.Attributes
 	exception := model.ExceptionDetails{}
 	for _, attr := range exceptionAttr {
 		switch attr.Key {
-		case "exception.stacktrace":
+		case common.OTelExceptionStacktraceKey:
 			exception.Stacktrace = attr.Value.GetStringValue()
-		case "exception.message":
+		case common.OTelExceptionMessageKey:
 			exception.Message = attr.Value.GetStringValue()
-		case "exception.type":
+		case common.OTelExceptionTypeKey:
 			exception.Type = attr.Value.GetStringValue()
 		}
 	}
@@ -120,6 +120,14 @@ func (h *RedisHandler) SetNXPipeline(key string, value interface{}, expiration t
 	return h.setExpiry(key, expiration)
 }
 
+func (h *RedisHandler) HSetNXPipeline(key string, field string, value interface{}, expiration time.Duration) error {
+	cmd := h.Pipeline.HSetNX(h.ctx, key, field, value)
+	if cmd.Err() != nil {
+		return cmd.Err()
+	}
+	return h.setExpiry(key, expiration)
+}
+
 func (h *RedisHandler) SAddPipeline(key string, value interface{}, expiration time.Duration) error {
 	cmd := h.Pipeline.SAdd(h.ctx, key, value)
 	if cmd.Err() != nil {
@@ -128,6 +136,63 @@ func (h *RedisHandler) SAddPipeline(key string, value interface{}, expiration ti
 	return h.setExpiry(key, expiration)
 }
 
+// SAddExpireAtPipeline adds the value to the set, which expires at the given time whatever is added later.
+func (h *RedisHandler) SAddExpireAtPipeline(key string, value interface{}, expireAt time.Time) error {
+	cmd := h.Pipeline.SAdd(h.ctx, key, value)
+	if cmd.Err() != nil {
+		return cmd.Err()
+	}
+	return h.setExpiryAt(key, expireAt)
+}
+
+func (h *RedisHandler) SetExpireAtPipeline(key string, value interface{}, expireAt time.Time) error {
+	cmd := h.Pipeline.Set(h.ctx, key, value, 0)
+	if cmd.Err() != nil {
+		return cmd.Err()
+	}
+	return h.setExpiryAt(key, expireAt)
+}
+
+func (h *RedisHandler) HIncrByPipeline(key string, values map[string]int64, expiration time.Duration) error {
+	for field, value := range values {
+		cmd := h.Pipeline.HIncrBy(h.ctx, key, field, value)
+		if cmd.Err() != nil {
+			return cmd.Err()
+		}
+	}
+	return h.setExpiry(key, expiration)
+}
+
+// ZAddGTPipeline adds the member to the sorted set, or raises its score when the new score is greater.
+func (h *RedisHandler) ZAddGTPipeline(key string, member string, score float64, expiration time.Duration) error {
+	cmd := h.Pipeline.ZAddGT(h.ctx, key, redis.Z{Score: score, Member: member})
+	if cmd.Err() != nil {
+		return cmd.Err()
+	}
+	return h.setExpiry(key, expiration)
+}
+
+func (h *RedisHandler) ZRemRangeByScorePipeline(key string, min string, max string) error {
+	cmd := h.Pipeline.ZRemRangeByScore(h.ctx, key, min, max)
+	if cmd.Err() != nil {
+		return cmd.Err()
+	}
+	h.count++
+	return nil
+}
+
+// LPushTrimPipeline pushes the values to the head of the list and keeps only the first maxLength values.
+func (h *RedisHandler) LPushTrimPipeline(key string, values []interface{}, maxLength int64, expiration time.Duration) error {
+	cmd := h.Pipeline.LPush(h.ctx, key, values...)
+	if cmd.Err() != nil {
+		return cmd.Err()
+	}
+	if trimCmd := h.Pipeline.LTrim(h.ctx, key, 0, maxLength-1); trimCmd.Err() != nil {
+		return trimCmd.Err()
+	}
+	return h.setExpiry(key, expiration)
+}
+
 func (h *RedisHandler) setExpiry(key string, expiration time.Duration) error {
 	if expiration > 0 {
 		cmd := h.Pipeline.Expire(h.ctx, key, expiration)
@@ -139,6 +204,15 @@ func (h *RedisHandler) setExpiry(key string, expiration time.Duration) error {
 	return nil
 }
 
+func (h *RedisHandler) setExpiryAt(key string, expireAt time.Time) error {
+	cmd := h.Pipeline.ExpireAt(h.ctx, key, expireAt)
+	if cmd.Err() != nil {
+		return cmd.Err()
+	}
+	h.count++
+	return nil
+}
+
 func (h *RedisHandler) CheckRedisConnection() error {
 	err := h.PingRedis()
 	if err != nil {
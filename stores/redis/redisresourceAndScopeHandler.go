@@ -1,8 +1,10 @@
 package redis
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/redis/go-redis/v9"
 	"github.com/zerok-ai/zk-observer/config"
 	logger "github.com/zerok-ai/zk-utils-go/logs"
 	"github.com/zerok-ai/zk-utils-go/storage/redis/clientDBNames"
@@ -67,6 +69,34 @@ func (h *ResourceAndScopeAttributesHandler) SyncResourceAndScopeAttrData(key str
 	return nil
 }
 
+// GetResourceAndScopeAttrData returns the resource or scope info stored under the hash, or nil when it expired.
+func (h *ResourceAndScopeAttributesHandler) GetResourceAndScopeAttrData(key string) (map[string]interface{}, error) {
+	var attrStr []byte
+	if value, ok := h.existingResourceData.Load(key); ok {
+		attrStr = value.([]byte)
+	} else {
+		if err := h.redisHandler.CheckRedisConnection(); err != nil {
+			logger.Error(resourceLogTag, "Error while checking redis conn ", err)
+			return nil, err
+		}
+		value, err := h.redisHandler.RedisClient.Get(context.Background(), key).Bytes()
+		if err == redis.Nil {
+			return nil, nil
+		}
+		if err != nil {
+			logger.Error(resourceLogTag, "Error while getting resource or scope data: ", err)
+			return nil, err
+		}
+		attrStr = value
+	}
+
+	var infoMap map[string]interface{}
+	if err := json.Unmarshal(attrStr, &infoMap); err != nil {
+		return nil, err
+	}
+	return infoMap, nil
+}
+
 func (h *ResourceAndScopeAttributesHandler) SyncPipeline() {
 	h.redisHandler.SyncPipeline()
 }
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"github.com/zerok-ai/zk-observer/config"
 	"github.com/zerok-ai/zk-observer/model"
+	"github.com/zerok-ai/zk-observer/utils"
 	"github.com/zerok-ai/zk-utils-go/common"
 	logger "github.com/zerok-ai/zk-utils-go/logs"
 	"github.com/zerok-ai/zk-utils-go/storage/redis/clientDBNames"
@@ -49,6 +50,11 @@ func (h *ResourceRedisHandler) SyncResourceData(resourceIp string, attrMap map[s
 		logger.Debug(resourceLogTag, "Skipping saving resource data since resource Ip is empty")
 		return errors.New("resourceIp is empty")
 	}
+	// Keys are the normalized IPs, as looked up in the pod details, whatever the address family.
+	resourceIp = utils.NormalizeIP(resourceIp)
+	if common.IsEmpty(resourceIp) {
+		return errors.New("resourceIp is invalid")
+	}
 
 	_, ok := h.existingResourceData.Load(resourceIp)
 	if !ok {
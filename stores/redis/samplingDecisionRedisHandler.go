@@ -0,0 +1,90 @@
+package redis
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"github.com/zerok-ai/zk-observer/common"
+	"github.com/zerok-ai/zk-observer/config"
+	logger "github.com/zerok-ai/zk-utils-go/logs"
+	"time"
+)
+
+var samplingDecisionLogTag = "SamplingDecisionRedisHandler"
+
+const samplingDecisionKeep = "keep"
+
+// SamplingDecisionRedisHandler shares tail sampling keep decisions between the observer pods, so that a
+// distributed trace is kept on every node once any pod decides to keep it.
+type SamplingDecisionRedisHandler struct {
+	redisHandler *RedisHandler
+	ctx          context.Context
+	config       *config.OtlpConfig
+}
+
+func NewSamplingDecisionRedisHandler(otlpConfig *config.OtlpConfig) (*SamplingDecisionRedisHandler, error) {
+	redisHandler, err := NewRedisHandler(&otlpConfig.Redis, common.SamplingDecisionDBName, otlpConfig.TailSampling.SyncDuration, otlpConfig.TailSampling.BatchSize, samplingDecisionLogTag)
+	if err != nil {
+		logger.Error(samplingDecisionLogTag, "Error while creating redis client ", err)
+		return nil, err
+	}
+
+	handler := &SamplingDecisionRedisHandler{
+		redisHandler: redisHandler,
+		ctx:          context.Background(),
+		config:       otlpConfig,
+	}
+	return handler, nil
+}
+
+// PutKeepDecisions writes the keep decisions right away instead of through the batched pipeline, so that the other
+// pods see them before the decision windows of their buffered spans end. The decisions expire after the ttl.
+func (h *SamplingDecisionRedisHandler) PutKeepDecisions(traceIds []string, ttl time.Duration) error {
+	if len(traceIds) == 0 {
+		return nil
+	}
+	if err := h.redisHandler.CheckRedisConnection(); err != nil {
+		logger.Error(samplingDecisionLogTag, "Error while checking redis conn ", err)
+		return err
+	}
+
+	_, err := h.redisHandler.RedisClient.Pipelined(h.ctx, func(pipe redis.Pipeliner) error {
+		for _, traceId := range traceIds {
+			pipe.SetNX(h.ctx, traceId, samplingDecisionKeep, ttl)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Error(samplingDecisionLogTag, "Error while setting keep decisions for ", len(traceIds), " traces, error: ", err)
+		return err
+	}
+	return nil
+}
+
+// GetKeepDecisions returns the subset of the given trace ids which were kept by any observer pod.
+func (h *SamplingDecisionRedisHandler) GetKeepDecisions(traceIds []string) (map[string]bool, error) {
+	keptTraceIds := map[string]bool{}
+	if len(traceIds) == 0 {
+		return keptTraceIds, nil
+	}
+
+	if err := h.redisHandler.CheckRedisConnection(); err != nil {
+		logger.Error(samplingDecisionLogTag, "Error while checking redis conn ", err)
+		return keptTraceIds, err
+	}
+
+	values, err := h.redisHandler.RedisClient.MGet(h.ctx, traceIds...).Result()
+	if err != nil {
+		logger.Error(samplingDecisionLogTag, "Error while getting keep decisions ", err)
+		return keptTraceIds, err
+	}
+	for i, value := range values {
+		if value == samplingDecisionKeep {
+			keptTraceIds[traceIds[i]] = true
+		}
+	}
+	return keptTraceIds, nil
+}
+
+func (h *SamplingDecisionRedisHandler) SyncPipeline() {
+	h.redisHandler.SyncPipeline()
+}
@@ -0,0 +1,269 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"github.com/zerok-ai/zk-observer/common"
+	"github.com/zerok-ai/zk-observer/config"
+	"github.com/zerok-ai/zk-observer/model"
+	logger "github.com/zerok-ai/zk-utils-go/logs"
+	"strconv"
+	"time"
+)
+
+var serviceGraphRedisHandlerLogTag = "ServiceGraphRedisHandler"
+
+const (
+	serviceGraphKeyPrefix        = "sg_"
+	serviceGraphPendingKeyPrefix = "sg_pending_"
+
+	serviceGraphClientField         = "client"
+	serviceGraphServerField         = "server"
+	serviceGraphConnectionTypeField = "connection_type"
+	serviceGraphRequestsField       = "requests"
+	serviceGraphFailedField         = "failed"
+	serviceGraphLatencySumField     = "latency_sum_us"
+	serviceGraphLatencyFieldPrefix  = "latency_"
+)
+
+// pairEdgeSideScript stores a side of a request in the hash of the request, unless the other side is there. The
+// other side is then returned and the hash removed, so exactly one pod pairs the request.
+var pairEdgeSideScript = redis.NewScript(`
+local otherSide = redis.call('HGET', KEYS[1], ARGV[2])
+if otherSide then
+	redis.call('DEL', KEYS[1])
+	return otherSide
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[3])
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return false
+`)
+
+// ServiceGraphRedisHandler stores the service graph edges of each time bucket. A bucket has a set of edge
+// ids and a hash of counters per edge, which every observer pod increments.
+type ServiceGraphRedisHandler struct {
+	redisHandler *RedisHandler
+	ctx          context.Context
+	config       *config.OtlpConfig
+}
+
+func NewServiceGraphRedisHandler(otlpConfig *config.OtlpConfig) (*ServiceGraphRedisHandler, error) {
+	redisHandler, err := NewRedisHandler(&otlpConfig.Redis, common.ServiceGraphDBName, otlpConfig.ServiceGraph.SyncDuration, otlpConfig.ServiceGraph.BatchSize, serviceGraphRedisHandlerLogTag)
+	if err != nil {
+		logger.Error(serviceGraphRedisHandlerLogTag, "Error while creating redis client ", err)
+		return nil, err
+	}
+
+	handler := &ServiceGraphRedisHandler{
+		redisHandler: redisHandler,
+		ctx:          context.Background(),
+		config:       otlpConfig,
+	}
+	return handler, nil
+}
+
+func bucketKey(bucketStart int64) string {
+	return serviceGraphKeyPrefix + strconv.FormatInt(bucketStart, 10)
+}
+
+func edgeKey(bucketStart int64, edgeId string) string {
+	return bucketKey(bucketStart) + "_" + edgeId
+}
+
+func (h *ServiceGraphRedisHandler) PutEdges(bucketStart int64, edges []*model.ServiceGraphEdge) error {
+	if err := h.redisHandler.CheckRedisConnection(); err != nil {
+		logger.Error(serviceGraphRedisHandlerLogTag, "Error while checking redis conn ", err)
+		return err
+	}
+
+	expiry := time.Duration(h.config.ServiceGraph.Ttl) * time.Second
+	for _, edge := range edges {
+		key := edgeKey(bucketStart, edge.EdgeId())
+		nodes := map[string]interface{}{
+			serviceGraphClientField:         edge.Client,
+			serviceGraphServerField:         edge.Server,
+			serviceGraphConnectionTypeField: edge.ConnectionType,
+		}
+		if err := h.redisHandler.HMSetPipeline(key, nodes, expiry); err != nil {
+			logger.Error(serviceGraphRedisHandlerLogTag, "Error while setting edge ", key, " error: ", err)
+			return err
+		}
+
+		counters := map[string]int64{
+			serviceGraphRequestsField:   edge.Requests,
+			serviceGraphFailedField:     edge.Failed,
+			serviceGraphLatencySumField: edge.LatencySumUs,
+		}
+		for i, count := range edge.LatencyCounts {
+			if count > 0 {
+				counters[serviceGraphLatencyFieldPrefix+strconv.Itoa(i)] = count
+			}
+		}
+		if err := h.redisHandler.HIncrByPipeline(key, counters, expiry); err != nil {
+			logger.Error(serviceGraphRedisHandlerLogTag, "Error while incrementing edge ", key, " error: ", err)
+			return err
+		}
+
+		if err := h.redisHandler.SAddPipeline(bucketKey(bucketStart), edge.EdgeId(), expiry); err != nil {
+			logger.Error(serviceGraphRedisHandlerLogTag, "Error while adding edge ", key, " to bucket error: ", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// GetEdges returns the edges of the given buckets. An edge is returned once per bucket it was seen in.
+func (h *ServiceGraphRedisHandler) GetEdges(bucketStarts []int64) ([]*model.ServiceGraphEdge, error) {
+	if err := h.redisHandler.CheckRedisConnection(); err != nil {
+		logger.Error(serviceGraphRedisHandlerLogTag, "Error while checking redis conn ", err)
+		return nil, err
+	}
+
+	var edgeKeys []string
+	for _, bucketStart := range bucketStarts {
+		edgeIds, err := h.redisHandler.RedisClient.SMembers(h.ctx, bucketKey(bucketStart)).Result()
+		if err != nil {
+			logger.Error(serviceGraphRedisHandlerLogTag, "Error while getting edges of bucket ", bucketStart, " error: ", err)
+			return nil, err
+		}
+		for _, edgeId := range edgeIds {
+			edgeKeys = append(edgeKeys, edgeKey(bucketStart, edgeId))
+		}
+	}
+	if len(edgeKeys) == 0 {
+		return nil, nil
+	}
+
+	// A separate pipeline, as the shared one is flushed by the sync ticker.
+	pipeline := h.redisHandler.RedisClient.Pipeline()
+	for _, key := range edgeKeys {
+		pipeline.HGetAll(h.ctx, key)
+	}
+	cmds, err := pipeline.Exec(h.ctx)
+	if err != nil {
+		logger.Error(serviceGraphRedisHandlerLogTag, "Error while getting edges ", err)
+		return nil, err
+	}
+
+	edges := make([]*model.ServiceGraphEdge, 0, len(cmds))
+	for i, cmd := range cmds {
+		values, err := cmd.(*redis.MapStringStringCmd).Result()
+		if err != nil || len(values) == 0 {
+			continue
+		}
+		edge, err := parseEdge(values)
+		if err != nil {
+			logger.Error(serviceGraphRedisHandlerLogTag, "Invalid edge ", edgeKeys[i], " error: ", err)
+			continue
+		}
+		edges = append(edges, edge)
+	}
+	return edges, nil
+}
+
+func parseEdge(values map[string]string) (*model.ServiceGraphEdge, error) {
+	edge := model.NewServiceGraphEdge(values[serviceGraphClientField], values[serviceGraphServerField], values[serviceGraphConnectionTypeField])
+	counters := map[string]*int64{
+		serviceGraphRequestsField:   &edge.Requests,
+		serviceGraphFailedField:     &edge.Failed,
+		serviceGraphLatencySumField: &edge.LatencySumUs,
+	}
+	for i := range edge.LatencyCounts {
+		counters[serviceGraphLatencyFieldPrefix+strconv.Itoa(i)] = &edge.LatencyCounts[i]
+	}
+	for field, counter := range counters {
+		value, ok := values[field]
+		if !ok {
+			continue
+		}
+		parsedValue, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", field, err)
+		}
+		*counter = parsedValue
+	}
+	return edge, nil
+}
+
+func pendingEdgeKey(side *model.ServiceGraphEdgeSide) string {
+	return serviceGraphPendingKeyPrefix + side.Key
+}
+
+func edgeSideField(isClient bool) string {
+	if isClient {
+		return serviceGraphClientField
+	}
+	return serviceGraphServerField
+}
+
+func (h *ServiceGraphRedisHandler) PairEdgeSides(sides []*model.ServiceGraphEdgeSide, expiry time.Duration) ([]*model.ServiceGraphEdgeSide, error) {
+	if err := h.redisHandler.CheckRedisConnection(); err != nil {
+		logger.Error(serviceGraphRedisHandlerLogTag, "Error while checking redis conn ", err)
+		return nil, err
+	}
+	// Loaded on every call, as the scripts of a pipeline are not reloaded after a Redis restart.
+	if err := pairEdgeSideScript.Load(h.ctx, h.redisHandler.RedisClient).Err(); err != nil {
+		logger.Error(serviceGraphRedisHandlerLogTag, "Error while loading the pairing script ", err)
+		return nil, err
+	}
+
+	// A separate pipeline, as the shared one is flushed by the sync ticker.
+	pipeline := h.redisHandler.RedisClient.Pipeline()
+	cmds := make([]*redis.Cmd, 0, len(sides))
+	for _, side := range sides {
+		sideJSON, err := json.Marshal(side)
+		if err != nil {
+			return nil, err
+		}
+		keys := []string{pendingEdgeKey(side)}
+		cmds = append(cmds, pairEdgeSideScript.EvalSha(h.ctx, pipeline, keys, edgeSideField(side.IsClient), edgeSideField(!side.IsClient), sideJSON, expiry.Milliseconds()))
+	}
+	if _, err := pipeline.Exec(h.ctx); err != nil && err != redis.Nil {
+		logger.Error(serviceGraphRedisHandlerLogTag, "Error while pairing edge sides ", err)
+		return nil, err
+	}
+
+	otherSides := make([]*model.ServiceGraphEdgeSide, len(sides))
+	for i, cmd := range cmds {
+		otherSideJSON, err := cmd.Text()
+		if err != nil {
+			continue
+		}
+		otherSide := model.ServiceGraphEdgeSide{Key: sides[i].Key, IsClient: !sides[i].IsClient}
+		if err := json.Unmarshal([]byte(otherSideJSON), &otherSide); err != nil {
+			logger.Error(serviceGraphRedisHandlerLogTag, "Invalid edge side of ", pendingEdgeKey(sides[i]), " error: ", err)
+			continue
+		}
+		otherSides[i] = &otherSide
+	}
+	return otherSides, nil
+}
+
+func (h *ServiceGraphRedisHandler) ClaimEdgeSides(sides []*model.ServiceGraphEdgeSide) ([]bool, error) {
+	if err := h.redisHandler.CheckRedisConnection(); err != nil {
+		logger.Error(serviceGraphRedisHandlerLogTag, "Error while checking redis conn ", err)
+		return nil, err
+	}
+
+	pipeline := h.redisHandler.RedisClient.Pipeline()
+	cmds := make([]*redis.IntCmd, 0, len(sides))
+	for _, side := range sides {
+		cmds = append(cmds, pipeline.HDel(h.ctx, pendingEdgeKey(side), edgeSideField(side.IsClient)))
+	}
+	if _, err := pipeline.Exec(h.ctx); err != nil {
+		logger.Error(serviceGraphRedisHandlerLogTag, "Error while claiming edge sides ", err)
+		return nil, err
+	}
+
+	unpaired := make([]bool, len(sides))
+	for i, cmd := range cmds {
+		unpaired[i] = cmd.Val() > 0
+	}
+	return unpaired, nil
+}
+
+func (h *ServiceGraphRedisHandler) SyncPipeline() {
+	h.redisHandler.SyncPipeline()
+}
@@ -2,37 +2,79 @@ package redis
 
 import (
 	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
 	"github.com/zerok-ai/zk-observer/common"
 	"github.com/zerok-ai/zk-observer/config"
-	"github.com/zerok-ai/zk-utils-go/ds"
+	"github.com/zerok-ai/zk-observer/model"
 	logger "github.com/zerok-ai/zk-utils-go/logs"
 	"github.com/zerok-ai/zk-utils-go/storage/redis/clientDBNames"
+	zktick "github.com/zerok-ai/zk-utils-go/ticker"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 var ServiceListRedisHandlerLogTag = "ServiceListRedisHandler"
 
+const (
+	defaultServiceTtl = 86400
+
+	serviceNameField        = "service_name"
+	serviceVersionField     = "service_version"
+	serviceNamespaceField   = "namespace"
+	serviceDeploymentField  = "deployment"
+	serviceSdkLanguageField = "sdk_language"
+	serviceSdkNameField     = "sdk_name"
+	serviceSdkVersionField  = "sdk_version"
+	serviceFirstSeenField   = "first_seen"
+	serviceLastSeenField    = "last_seen"
+	// Every observer pod keeps its own span rate field, as `<rate>|<unix time>`. Rates of pods which did not
+	// update them for a few sync durations are ignored.
+	serviceSpanRateFieldPrefix = "span_rate_"
+	spanRateStaleSyncs         = 3
+)
+
+// serviceRecord holds the metadata of a service and the spans counted since the last sync.
+type serviceRecord struct {
+	metadata  model.ServiceMetadata
+	spanCount int64
+}
+
+// ServiceListRedisHandler keeps a hash with the metadata of every service which sent spans, which expires
+// when the service stops sending them. The service_list set holds the names of these services.
 type ServiceListRedisHandler struct {
 	redisHandler *RedisHandler
 	ctx          context.Context
 	config       *config.OtlpConfig
-	serviceList  ds.Set[string]
+	services     map[string]*serviceRecord
+	mutex        sync.Mutex
+	lastSync     time.Time
+	ticker       *zktick.TickerTask
 }
 
 func NewServiceListRedisHandler(otlpConfig *config.OtlpConfig) (*ServiceListRedisHandler, error) {
 	redisHandler, err := NewRedisHandler(&otlpConfig.Redis, clientDBNames.ServiceListDBName, otlpConfig.Services.SyncDuration, otlpConfig.Services.BatchSize, ServiceListRedisHandlerLogTag)
 	if err != nil {
 		logger.Error(ServiceListRedisHandlerLogTag, "Error while creating redis client ", err)
+		return nil, err
+	}
+	if otlpConfig.Services.Ttl <= 0 {
+		otlpConfig.Services.Ttl = defaultServiceTtl
 	}
 
 	handler := &ServiceListRedisHandler{
 		redisHandler: redisHandler,
 		ctx:          context.Background(),
 		config:       otlpConfig,
-		serviceList:  make(ds.Set[string]),
+		services:     map[string]*serviceRecord{},
+		lastSync:     time.Now(),
 	}
 
-	handler.serviceList.Add(common.ScenarioWorkloadGenericServiceNameKey)
-	_ = handler.PutServiceListData(common.ServiceListKey, common.ScenarioWorkloadGenericServiceNameKey)
+	_ = handler.redisHandler.SAddPipeline(common.ServiceListKey, common.ScenarioWorkloadGenericServiceNameKey, -1)
+	handler.ticker = zktick.GetNewTickerTask("sync_services", time.Duration(otlpConfig.Services.SyncDuration)*time.Second, handler.syncServices)
+	handler.ticker.Start()
 	return handler, nil
 }
 
@@ -40,20 +82,202 @@ func (h *ServiceListRedisHandler) CheckRedisConnection() error {
 	return h.redisHandler.CheckRedisConnection()
 }
 
-func (h *ServiceListRedisHandler) PutServiceListData(key string, serviceName string) error {
+// RecordService counts a span of the service. The metadata is read from the resource attributes of the first
+// span of every sync duration.
+func (h *ServiceListRedisHandler) RecordService(serviceName string, resourceAttrMap map[string]interface{}) {
+	now := time.Now().Unix()
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	record, ok := h.services[serviceName]
+	if !ok {
+		record = &serviceRecord{metadata: model.ServiceMetadata{ServiceName: serviceName, FirstSeen: now}}
+		h.services[serviceName] = record
+	}
+	if record.spanCount == 0 {
+		telemetryDetails := model.CreateTelemetryDetails(resourceAttrMap)
+		record.metadata.ServiceVersion = telemetryDetails.ServiceVersion
+		record.metadata.SdkLanguage = telemetryDetails.TelemetrySdkLanguage
+		record.metadata.SdkName = telemetryDetails.TelemetrySdkName
+		record.metadata.SdkVersion = telemetryDetails.TelemetrySdkVersion
+		record.metadata.Namespace, _ = resourceAttrMap[common.OTelResourceAttrNamespaceKey].(string)
+		record.metadata.Deployment, _ = resourceAttrMap[common.OTelResourceAttrDeploymentNameKey].(string)
+	}
+	record.metadata.LastSeen = now
+	record.spanCount++
+}
+
+// syncServices writes the services which sent spans since the last sync, and removes the expired services.
+func (h *ServiceListRedisHandler) syncServices() {
+	now := time.Now()
+	ttl := time.Duration(h.config.Services.Ttl) * time.Second
+
+	h.mutex.Lock()
+	elapsedSeconds := now.Sub(h.lastSync).Seconds()
+	h.lastSync = now
+	var activeServices []model.ServiceMetadata
+	for serviceName, record := range h.services {
+		if record.spanCount == 0 {
+			if now.Sub(time.Unix(record.metadata.LastSeen, 0)) > ttl {
+				delete(h.services, serviceName)
+			}
+			continue
+		}
+		metadata := record.metadata
+		if elapsedSeconds > 0 {
+			metadata.SpanRate = float64(record.spanCount) / elapsedSeconds
+		}
+		activeServices = append(activeServices, metadata)
+		record.spanCount = 0
+	}
+	h.mutex.Unlock()
+
 	if err := h.redisHandler.CheckRedisConnection(); err != nil {
-		logger.Error(traceRedisHandlerLogTag, "Error while checking redis conn ", err)
-		return err
+		logger.Error(ServiceListRedisHandlerLogTag, "Error while checking redis conn ", err)
+		return
+	}
+	for _, metadata := range activeServices {
+		if err := h.putServiceMetadata(metadata, now, ttl); err != nil {
+			logger.Error(ServiceListRedisHandlerLogTag, "Error while setting metadata of service ", metadata.ServiceName, " error: ", err)
+		}
 	}
+	h.removeExpiredServices()
+}
 
-	h.serviceList.Add(serviceName)
+func (h *ServiceListRedisHandler) putServiceMetadata(metadata model.ServiceMetadata, now time.Time, ttl time.Duration) error {
+	key := common.ServiceKeyPrefix + metadata.ServiceName
+	if err := h.redisHandler.HSetNXPipeline(key, serviceFirstSeenField, metadata.FirstSeen, ttl); err != nil {
+		return err
+	}
 
-	if err := h.redisHandler.SAddPipeline(key, h.serviceList.GetAll(), -1); err != nil {
-		logger.Error(ServiceListRedisHandlerLogTag, "Error while setting service name: %s, %v", serviceName, err)
+	values := map[string]interface{}{
+		serviceNameField:                   metadata.ServiceName,
+		serviceLastSeenField:               metadata.LastSeen,
+		serviceSpanRateFieldPrefix + podIp: fmt.Sprintf("%f|%d", metadata.SpanRate, now.Unix()),
+	}
+	optionalValues := map[string]string{
+		serviceVersionField:     metadata.ServiceVersion,
+		serviceNamespaceField:   metadata.Namespace,
+		serviceDeploymentField:  metadata.Deployment,
+		serviceSdkLanguageField: metadata.SdkLanguage,
+		serviceSdkNameField:     metadata.SdkName,
+		serviceSdkVersionField:  metadata.SdkVersion,
+	}
+	for field, value := range optionalValues {
+		if len(value) > 0 {
+			values[field] = value
+		}
+	}
+	if err := h.redisHandler.HMSetPipeline(key, values, ttl); err != nil {
 		return err
 	}
+	return h.redisHandler.SAddPipeline(common.ServiceListKey, metadata.ServiceName, -1)
+}
 
-	return nil
+// removeExpiredServices removes the services whose metadata hash expired from the service list.
+func (h *ServiceListRedisHandler) removeExpiredServices() {
+	serviceNames, err := h.redisHandler.RedisClient.SMembers(h.ctx, common.ServiceListKey).Result()
+	if err != nil {
+		logger.Error(ServiceListRedisHandlerLogTag, "Error while getting service list ", err)
+		return
+	}
+
+	pipeline := h.redisHandler.RedisClient.Pipeline()
+	existsCmds := map[string]*redis.IntCmd{}
+	for _, serviceName := range serviceNames {
+		if serviceName == common.ScenarioWorkloadGenericServiceNameKey {
+			continue
+		}
+		existsCmds[serviceName] = pipeline.Exists(h.ctx, common.ServiceKeyPrefix+serviceName)
+	}
+	if len(existsCmds) == 0 {
+		return
+	}
+	if _, err := pipeline.Exec(h.ctx); err != nil {
+		logger.Error(ServiceListRedisHandlerLogTag, "Error while checking service metadata ", err)
+		return
+	}
+
+	var expiredServices []interface{}
+	for serviceName, existsCmd := range existsCmds {
+		if existsCmd.Val() == 0 {
+			expiredServices = append(expiredServices, serviceName)
+		}
+	}
+	if len(expiredServices) > 0 {
+		if err := h.redisHandler.RedisClient.SRem(h.ctx, common.ServiceListKey, expiredServices...).Err(); err != nil {
+			logger.Error(ServiceListRedisHandlerLogTag, "Error while removing expired services ", err)
+		}
+	}
+}
+
+// GetServices returns the metadata of the services in the service list, with the span rates of all pods.
+func (h *ServiceListRedisHandler) GetServices() ([]model.ServiceMetadata, error) {
+	if err := h.redisHandler.CheckRedisConnection(); err != nil {
+		logger.Error(ServiceListRedisHandlerLogTag, "Error while checking redis conn ", err)
+		return nil, err
+	}
+
+	serviceNames, err := h.redisHandler.RedisClient.SMembers(h.ctx, common.ServiceListKey).Result()
+	if err != nil {
+		logger.Error(ServiceListRedisHandlerLogTag, "Error while getting service list ", err)
+		return nil, err
+	}
+
+	pipeline := h.redisHandler.RedisClient.Pipeline()
+	var cmds []*redis.MapStringStringCmd
+	for _, serviceName := range serviceNames {
+		if serviceName == common.ScenarioWorkloadGenericServiceNameKey {
+			continue
+		}
+		cmds = append(cmds, pipeline.HGetAll(h.ctx, common.ServiceKeyPrefix+serviceName))
+	}
+	services := make([]model.ServiceMetadata, 0, len(cmds))
+	if len(cmds) == 0 {
+		return services, nil
+	}
+	if _, err := pipeline.Exec(h.ctx); err != nil {
+		logger.Error(ServiceListRedisHandlerLogTag, "Error while getting service metadata ", err)
+		return nil, err
+	}
+
+	staleBefore := time.Now().Unix() - int64(spanRateStaleSyncs*h.config.Services.SyncDuration)
+	for _, cmd := range cmds {
+		values := cmd.Val()
+		if len(values) == 0 {
+			continue
+		}
+		services = append(services, parseServiceMetadata(values, staleBefore))
+	}
+	return services, nil
+}
+
+func parseServiceMetadata(values map[string]string, staleBefore int64) model.ServiceMetadata {
+	metadata := model.ServiceMetadata{
+		ServiceName:    values[serviceNameField],
+		ServiceVersion: values[serviceVersionField],
+		Namespace:      values[serviceNamespaceField],
+		Deployment:     values[serviceDeploymentField],
+		SdkLanguage:    values[serviceSdkLanguageField],
+		SdkName:        values[serviceSdkNameField],
+		SdkVersion:     values[serviceSdkVersionField],
+	}
+	metadata.FirstSeen, _ = strconv.ParseInt(values[serviceFirstSeenField], 10, 64)
+	metadata.LastSeen, _ = strconv.ParseInt(values[serviceLastSeenField], 10, 64)
+	for field, value := range values {
+		if !strings.HasPrefix(field, serviceSpanRateFieldPrefix) {
+			continue
+		}
+		rate, updatedAt, _ := strings.Cut(value, "|")
+		updatedAtSeconds, err := strconv.ParseInt(updatedAt, 10, 64)
+		if err != nil || updatedAtSeconds < staleBefore {
+			continue
+		}
+		if spanRate, err := strconv.ParseFloat(rate, 64); err == nil {
+			metadata.SpanRate += spanRate
+		}
+	}
+	return metadata
 }
 
 func (h *ServiceListRedisHandler) SyncPipeline() {
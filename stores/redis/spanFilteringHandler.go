@@ -2,31 +2,45 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/zerok-ai/zk-observer/common"
 	"github.com/zerok-ai/zk-observer/config"
 	promMetrics "github.com/zerok-ai/zk-observer/metrics"
+	"github.com/zerok-ai/zk-observer/model"
+	"github.com/zerok-ai/zk-observer/sampling"
+	"github.com/zerok-ai/zk-observer/scenario"
 	"github.com/zerok-ai/zk-observer/utils"
 	zkUtilsCommonModel "github.com/zerok-ai/zk-utils-go/common"
 	logger "github.com/zerok-ai/zk-utils-go/logs"
 	zkmodel "github.com/zerok-ai/zk-utils-go/scenario/model"
 	evaluator "github.com/zerok-ai/zk-utils-go/scenario/model/evaluators"
+	"github.com/zerok-ai/zk-utils-go/scenario/model/evaluators/cache"
 	"github.com/zerok-ai/zk-utils-go/scenario/model/evaluators/functions"
 	zkredis "github.com/zerok-ai/zk-utils-go/storage/redis"
 	"github.com/zerok-ai/zk-utils-go/storage/redis/clientDBNames"
 	"github.com/zerok-ai/zk-utils-go/storage/redis/stores"
+	zktick "github.com/zerok-ai/zk-utils-go/ticker"
 	"k8s.io/utils/strings/slices"
 	"math/rand"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var spanFilteringLogTag = "SpanFilteringHandler"
+
+const defaultBucketActiveDuration = 60
+
 var podIp = os.Getenv("POD_IP")
 
 type SpanFilteringHandler struct {
 	VersionedStore    *zkredis.VersionedStore[zkmodel.Scenario]
+	fileSource        *scenario.FileScenarioSource
 	Cfg               *config.OtlpConfig
 	ruleEvaluator     evaluator.RuleEvaluator
 	redisHandler      *RedisHandler
@@ -34,11 +48,86 @@ type SpanFilteringHandler struct {
 	ctx               context.Context
 	executorAttrStore *stores.ExecutorAttrStore
 	podDetailsStore   *stores.LocalCacheHSetStore
+	// lastMatches holds the unix time of the last match by scenario id, and by workloadMatchKey.
+	lastMatches sync.Map
+	plan        atomic.Pointer[filteringPlan]
+	// activeBuckets holds the start of the last bucket written as active, by workload id.
+	activeBuckets sync.Map
+	// captureLimiters holds the *workloadCaptureLimiter of the workloads by workloadMatchKey.
+	captureLimiters sync.Map
+	planTicker      *zktick.TickerTask
+}
+
+type workloadMatchKey struct {
+	scenarioId string
+	workloadId string
+}
+
+// filteringPlan is the evaluation plan of the scenarios, with the metrics of its workloads by workloadMatchKey.
+type filteringPlan struct {
+	*scenario.EvaluationPlan
+	workloadMetrics map[workloadMatchKey]*workloadMetrics
+}
+
+// workloadMetrics are the metrics of a planned workload, with the scenario and workload labels set when the plan
+// is compiled, so that the spans do not look them up.
+type workloadMetrics struct {
+	evaluations       prometheus.Counter
+	matches           prometheus.Counter
+	evaluationSeconds prometheus.Observer
+	errors            *prometheus.CounterVec
+	captureDrops      *prometheus.CounterVec
+}
+
+func newWorkloadMetrics(key workloadMatchKey) *workloadMetrics {
+	labels := prometheus.Labels{"scenario": key.scenarioId, "workload": key.workloadId}
+	return &workloadMetrics{
+		evaluations:       promMetrics.ScenarioWorkloadEvaluations.With(labels),
+		matches:           promMetrics.ScenarioWorkloadMatches.With(labels),
+		evaluationSeconds: promMetrics.ScenarioWorkloadEvaluationSeconds.With(labels),
+		errors:            promMetrics.ScenarioWorkloadErrors.MustCurryWith(labels),
+		captureDrops:      promMetrics.ScenarioWorkloadCaptureDrops.MustCurryWith(labels),
+	}
+}
+
+// deleteWorkloadMetrics removes the series of a workload which is not planned anymore.
+func deleteWorkloadMetrics(key workloadMatchKey) {
+	promMetrics.ScenarioWorkloadEvaluations.DeleteLabelValues(key.scenarioId, key.workloadId)
+	promMetrics.ScenarioWorkloadMatches.DeleteLabelValues(key.scenarioId, key.workloadId)
+	promMetrics.ScenarioWorkloadEvaluationSeconds.DeleteLabelValues(key.scenarioId, key.workloadId)
+	labels := prometheus.Labels{"scenario": key.scenarioId, "workload": key.workloadId}
+	promMetrics.ScenarioWorkloadErrors.DeletePartialMatch(labels)
+	promMetrics.ScenarioWorkloadCaptureDrops.DeletePartialMatch(labels)
+}
+
+// workloadCaptureLimiter is the capture limiter of a workload, for the version of the scenario it was created for.
+type workloadCaptureLimiter struct {
+	scenarioVersion string
+	limiter         *sampling.CaptureLimiter
 }
 
+// Classes of the rule evaluation errors, as reported in the workload metrics.
+const (
+	ruleErrorMissingValue    = "missing_value"
+	ruleErrorInvalidOperator = "invalid_operator"
+	ruleErrorInvalidValue    = "invalid_value"
+	ruleErrorInvalidRule     = "invalid_rule"
+	ruleErrorPanic           = "panic"
+	ruleErrorOther           = "other"
+)
+
+// WorkLoadTraceId is a trace id to add to a bucket of a workload.
 type WorkLoadTraceId struct {
-	WorkLoadId string
-	TraceId    string
+	WorkLoadId  string
+	TraceId     string
+	BucketStart int64
+}
+
+// workloadTraceKey keys the trace ids pending for the workload buckets, so that a trace id is added once.
+type workloadTraceKey struct {
+	workloadId  string
+	bucketStart int64
+	traceId     string
 }
 
 type WorkloadIdList []string
@@ -55,8 +144,18 @@ func NewSpanFilteringHandler(cfg *config.OtlpConfig, executorAttrStore *stores.E
 		return nil, err
 	}
 
+	var fileSource *scenario.FileScenarioSource
+	if len(cfg.Scenario.Files.Dir) > 0 {
+		fileSource, err = scenario.NewFileScenarioSource(cfg.Scenario.Files)
+		if err != nil {
+			logger.Error(spanFilteringLogTag, "Error while creating file scenario source:", err)
+			return nil, err
+		}
+	}
+
 	handler := SpanFilteringHandler{
 		VersionedStore:    store,
+		fileSource:        fileSource,
 		Cfg:               cfg,
 		ruleEvaluator:     *evaluator.NewRuleEvaluator(executorAttrStore, podDetailsStore),
 		workloadDetails:   sync.Map{},
@@ -66,25 +165,39 @@ func NewSpanFilteringHandler(cfg *config.OtlpConfig, executorAttrStore *stores.E
 		podDetailsStore:   podDetailsStore,
 	}
 
+	// The plan is compiled again when the scenarios of the stores change.
+	handler.refreshPlan()
+	planDuration := time.Duration(cfg.Scenario.SyncDuration) * time.Second
+	handler.planTicker = zktick.GetNewTickerTask("scenario_plan", planDuration, handler.refreshPlan)
+	handler.planTicker.Start()
 	return &handler, nil
 }
 
-func (h *SpanFilteringHandler) FilterSpans(traceId string, spanDetailsMap map[string]interface{}, serviceName string) (WorkloadIdList, zkUtilsCommonModel.GroupByMap) {
+func (h *SpanFilteringHandler) FilterSpans(traceId string, spanDetailsMap map[string]interface{}, target scenario.SpanTarget) (WorkloadIdList, zkUtilsCommonModel.GroupByMap) {
 	promMetrics.TotalSpansProcessed.WithLabelValues(podIp).Inc()
 	defer func() {
 		if r := recover(); r != nil {
 			logger.Error(spanFilteringLogTag, "FilterSpans: Recovered from panic: ", r)
 		}
 	}()
-	scenarios := h.VersionedStore.GetAllValues()
+	satisfiedWorkLoadIds, groupByMap := h.filterSpans(h.plan.Load(), traceId, spanDetailsMap, target)
+	if len(satisfiedWorkLoadIds) > 0 {
+		promMetrics.TotalSpansFiltered.WithLabelValues(podIp).Inc()
+	}
+	err := h.syncWorkloadsToRedis()
+	if err != nil {
+		logger.Error(spanFilteringLogTag, "Error while syncing workload data to redis pipeline ", err)
+	}
+	return satisfiedWorkLoadIds, groupByMap
+}
+
+// filterSpans evaluates the candidate workloads of the plan for the span.
+func (h *SpanFilteringHandler) filterSpans(plan *filteringPlan, traceId string, spanDetailsMap map[string]interface{}, target scenario.SpanTarget) (WorkloadIdList, zkUtilsCommonModel.GroupByMap) {
 	var satisfiedWorkLoadIds WorkloadIdList
 	var groupByMap zkUtilsCommonModel.GroupByMap
-	for _, scenario := range scenarios {
-		if scenario == nil {
-			logger.Info(spanFilteringLogTag, "No scenario found")
-			continue
-		}
-		processedWorkloadIds := h.processScenarioWorkloads(scenario, traceId, spanDetailsMap, serviceName)
+	for _, candidates := range plan.Candidates(target) {
+		scenario := candidates.Scenario
+		processedWorkloadIds := h.processScenarioWorkloads(plan, candidates, traceId, spanDetailsMap)
 		if len(processedWorkloadIds) > 0 {
 			if satisfiedWorkLoadIds == nil {
 				satisfiedWorkLoadIds = make(WorkloadIdList, 0)
@@ -98,13 +211,67 @@ func (h *SpanFilteringHandler) FilterSpans(traceId string, spanDetailsMap map[st
 			}
 		}
 	}
-	err := h.syncWorkloadsToRedis()
-	if err != nil {
-		logger.Error(spanFilteringLogTag, "Error while syncing workload data to redis pipeline ", err)
-	}
 	return satisfiedWorkLoadIds, groupByMap
 }
 
+// refreshPlan compiles the evaluation plan of the scenarios when they changed since the last plan.
+func (h *SpanFilteringHandler) refreshPlan() {
+	scenarios := h.GetScenarios()
+	previousPlan := h.plan.Load()
+	if previousPlan != nil && previousPlan.Signature() == scenario.PlanSignature(scenarios) {
+		return
+	}
+	plan := newFilteringPlan(scenarios, previousPlan)
+	h.plan.Store(plan)
+	if previousPlan != nil {
+		for key := range previousPlan.workloadMetrics {
+			if _, ok := plan.workloadMetrics[key]; !ok {
+				deleteWorkloadMetrics(key)
+			}
+		}
+	}
+	// Limiters keep the trace ids captured in the current bucket, so only the ones of removed workloads, or of
+	// scenarios with a new version or new limits, are replaced.
+	h.captureLimiters.Range(func(key, value interface{}) bool {
+		limiterKey := key.(workloadMatchKey)
+		workloadLimiter := value.(*workloadCaptureLimiter)
+		zkScenario := scenarios[limiterKey.scenarioId]
+		if zkScenario == nil || zkScenario.Workloads == nil {
+			h.captureLimiters.Delete(key)
+			return true
+		}
+		if _, ok := (*zkScenario.Workloads)[limiterKey.workloadId]; !ok || zkScenario.Version != workloadLimiter.scenarioVersion ||
+			h.captureLimits(zkScenario, limiterKey.workloadId) != workloadLimiter.limiter.Limits() {
+			h.captureLimiters.Delete(key)
+		}
+		return true
+	})
+	logger.Info(spanFilteringLogTag, "Compiled evaluation plan for ", len(scenarios), " scenarios")
+}
+
+// newFilteringPlan compiles the plan of the scenarios. The metrics of the workloads of the previous plan, which
+// may be nil, are kept.
+func newFilteringPlan(scenarios map[string]*zkmodel.Scenario, previousPlan *filteringPlan) *filteringPlan {
+	plan := &filteringPlan{EvaluationPlan: scenario.NewEvaluationPlan(scenarios), workloadMetrics: map[workloadMatchKey]*workloadMetrics{}}
+	plan.ForEachWorkload(func(zkScenario *zkmodel.Scenario, workload scenario.PlannedWorkload) {
+		key := workloadMatchKey{scenarioId: zkScenario.Id, workloadId: workload.Id}
+		if previousPlan != nil && previousPlan.workloadMetrics[key] != nil {
+			plan.workloadMetrics[key] = previousPlan.workloadMetrics[key]
+		} else {
+			plan.workloadMetrics[key] = newWorkloadMetrics(key)
+		}
+	})
+	return plan
+}
+
+// GetScenarios returns the scenarios from Redis, merged with the ones from the scenario directory when it is set.
+func (h *SpanFilteringHandler) GetScenarios() map[string]*zkmodel.Scenario {
+	if h.fileSource == nil {
+		return h.VersionedStore.GetAllValues()
+	}
+	return h.fileSource.Merge(h.VersionedStore.GetAllValues())
+}
+
 func (h *SpanFilteringHandler) processGroupBy(scenario *zkmodel.Scenario, spanDetailsMap map[string]interface{}, satisfiedWorkLoadIds WorkloadIdList) (zkUtilsCommonModel.GroupByValues, bool) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -146,59 +313,290 @@ func getProtocolForWorkloadId(workloadID string, scenario *zkmodel.Scenario) zkm
 	return workload.Protocol
 }
 
-func (h *SpanFilteringHandler) IsSpanToBeEvaluated(workload zkmodel.Workload, serviceName string) bool {
-	if workload.Executor != zkmodel.ExecutorOTel {
-		logger.Debug(spanFilteringLogTag, "Workload executor is not OTel")
-		return false
+func (h *SpanFilteringHandler) processScenarioWorkloads(plan *filteringPlan, candidates scenario.ScenarioCandidates, traceId string, spanDetailsMap map[string]interface{}) WorkloadIdList {
+	var satisfiedWorkLoadIds = make(WorkloadIdList, 0)
+	scenarioId := candidates.Scenario.Id
+	for _, protocolWorkloads := range candidates.Protocols {
+		attribKey := utils.GenerateAttribStoreKey(spanDetailsMap, protocolWorkloads.Protocol)
+		for _, plannedWorkload := range protocolWorkloads.Workloads {
+			id := plannedWorkload.Id
+			metrics := plan.workloadMetrics[workloadMatchKey{scenarioId: scenarioId, workloadId: id}]
+			start := time.Now()
+			matched, err := h.evaluateWorkload(&h.ruleEvaluator, plannedWorkload.Workload, attribKey, spanDetailsMap)
+			metrics.evaluations.Inc()
+			metrics.evaluationSeconds.Observe(time.Since(start).Seconds())
+			if err != nil {
+				// Missing attributes are expected on most spans, so errors are only logged at debug level.
+				metrics.errors.WithLabelValues(classifyRuleError(err)).Inc()
+				logger.Debug(spanFilteringLogTag, "Error while evaluating rule for scenario: ", candidates.Scenario.Title, " workload id: ", id, " error: ", err)
+				continue
+			}
+			if matched {
+				metrics.matches.Inc()
+				now := time.Now().Unix()
+				h.lastMatches.Store(scenarioId, now)
+				h.lastMatches.Store(workloadMatchKey{scenarioId: scenarioId, workloadId: id}, now)
+				// Workloads which matched, but did not capture the trace, are not reported for the span.
+				if h.captureTrace(candidates.Scenario, id, traceId, metrics) {
+					satisfiedWorkLoadIds = append(satisfiedWorkLoadIds, id)
+				}
+			}
+		}
 	}
+	return satisfiedWorkLoadIds
+}
 
+// captureTrace adds the trace id to the active bucket of the workload, within the capture limits of the workload,
+// and tells if the trace id is captured. Trace ids evicted by reservoir sampling are removed from the bucket.
+func (h *SpanFilteringHandler) captureTrace(zkScenario *zkmodel.Scenario, workloadId string, traceId string, metrics *workloadMetrics) bool {
+	limiterKey := workloadMatchKey{scenarioId: zkScenario.Id, workloadId: workloadId}
+	value, ok := h.captureLimiters.Load(limiterKey)
+	if !ok {
+		value, _ = h.captureLimiters.LoadOrStore(limiterKey, &workloadCaptureLimiter{
+			scenarioVersion: zkScenario.Version,
+			limiter:         sampling.NewCaptureLimiter(h.captureLimits(zkScenario, workloadId)),
+		})
+	}
+
+	bucketStart := h.workloadBucketStart(time.Now())
+	decision := value.(*workloadCaptureLimiter).limiter.Offer(traceId, bucketStart)
+	if len(decision.Evicted) > 0 {
+		// The evicted trace id is kept in the bucket, as the spans it was reported for are already stored and
+		// sampled for the workload. Its next spans do not report the workload anymore.
+		metrics.captureDrops.WithLabelValues(sampling.CaptureDropEvicted).Inc()
+	}
+	// The other spans of a trace already decided on are neither drops, nor new trace ids for the bucket.
+	if decision.Repeated {
+		return decision.Captured
+	}
+	if !decision.Captured {
+		metrics.captureDrops.WithLabelValues(decision.DropReason).Inc()
+		return false
+	}
+	h.workloadDetails.Store(workloadTraceKey{workloadId: workloadId, bucketStart: bucketStart, traceId: traceId},
+		WorkLoadTraceId{WorkLoadId: workloadId, TraceId: traceId, BucketStart: bucketStart})
 	return true
 }
 
-func (h *SpanFilteringHandler) isSpanToBeEvaluatedForOTelService(workload zkmodel.Workload, spanServiceName string) bool {
-	workloadServiceName := workload.Service
-	if spanServiceName != common.ScenarioWorkloadGenericDeploymentKey && spanServiceName != workloadServiceName {
-		logger.Info(spanFilteringLogTag, "OTel service name is not matching")
-		return false
+// captureLimits returns the capture limits of a workload. The config defaults are overridden by the first
+// matching config limit, and the rate by the rate limit of the scenario definition.
+func (h *SpanFilteringHandler) captureLimits(zkScenario *zkmodel.Scenario, workloadId string) sampling.CaptureLimits {
+	workloadsConfig := h.Cfg.Workloads
+	limits := sampling.CaptureLimits{
+		MaxTracesPerBucket: workloadsConfig.MaxTracesPerBucketPerPod,
+		TracesPerSecond:    workloadsConfig.TracesPerSecondPerPod,
+	}
+	for _, limit := range workloadsConfig.Limits {
+		if matchesLimitId(limit.Scenario, zkScenario.Id) && matchesLimitId(limit.Workload, workloadId) {
+			limits.MaxTracesPerBucket = limit.MaxTracesPerBucketPerPod
+			limits.TracesPerSecond = limit.TracesPerSecondPerPod
+			break
+		}
 	}
 
-	return true
+	if len(zkScenario.RateLimit) > 0 && zkScenario.RateLimit[0].BucketRefillSize > 0 {
+		rateLimit := zkScenario.RateLimit[0]
+		tickDuration, err := time.ParseDuration(rateLimit.TickDuration)
+		if err != nil || tickDuration <= 0 {
+			tickDuration = time.Second
+		}
+		limits.TracesPerSecond = float64(rateLimit.BucketRefillSize) / tickDuration.Seconds()
+		limits.Burst = float64(rateLimit.BucketMaxSize)
+	}
+	return limits
 }
 
-func (h *SpanFilteringHandler) processScenarioWorkloads(scenario *zkmodel.Scenario, traceId string, spanDetailsMap map[string]interface{}, serviceName string) WorkloadIdList {
-	var satisfiedWorkLoadIds = make(WorkloadIdList, 0)
-	//Getting workloads and iterate over them
-	workloads := scenario.Workloads
-	if workloads == nil {
-		logger.Debug(spanFilteringLogTag, "No workloads found for scenario: ", scenario.Title)
-		return satisfiedWorkLoadIds
-	}
-	for id, workload := range *workloads {
-		// Check if span is to be evaluated for this workload
-		if !h.IsSpanToBeEvaluated(workload, serviceName) {
-			logger.Debug(spanFilteringLogTag, "Span not to be evaluated for workload: ", id)
-			continue
+func matchesLimitId(limitId string, id string) bool {
+	return len(limitId) == 0 || limitId == common.ScenarioWorkloadGenericServiceNameKey || limitId == id
+}
+
+// workloadSkipReason returns why the workload is skipped for the span, as done by the evaluation plan.
+func workloadSkipReason(workload zkmodel.Workload, target scenario.SpanTarget) string {
+	return scenario.WorkloadSkipReason(workload, target)
+}
+
+// evaluateWorkload evaluates the rule of the workload on the span. A panic of the evaluators is returned as an
+// error, so that it only fails the workload.
+func (h *SpanFilteringHandler) evaluateWorkload(ruleEvaluator *evaluator.RuleEvaluator, workload zkmodel.Workload, attribKey cache.AttribStoreKey, spanDetailsMap map[string]interface{}) (matched bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			matched, err = false, &rulePanicError{value: r}
 		}
+	}()
+	return ruleEvaluator.EvalRule(workload.Rule, attribKey, spanDetailsMap)
+}
 
-		rule := workload.Rule
-		logger.Debug(spanFilteringLogTag, "Evaluating rule for scenario: ", scenario.Title, " workload id: ", id)
-		attribKey := utils.GenerateAttribStoreKey(spanDetailsMap, workload.Protocol)
-		value, err := h.ruleEvaluator.EvalRule(rule, attribKey, spanDetailsMap)
-		if err != nil {
-			logger.Info(spanFilteringLogTag, "Error while evaluating rule for scenario: ", scenario.Title, " workload id: ", id, " error: ", err)
+type rulePanicError struct {
+	value interface{}
+}
+
+func (e *rulePanicError) Error() string {
+	return fmt.Sprintf("panic while evaluating rule: %v", e.value)
+}
+
+// classifyRuleError returns the class of an error of the rule evaluators, which only return formatted errors.
+func classifyRuleError(err error) string {
+	var panicErr *rulePanicError
+	if errors.As(err, &panicErr) {
+		return ruleErrorPanic
+	}
+	message := err.Error()
+	switch {
+	case strings.Contains(message, "not found in valueStore") || strings.Contains(message, "value not found"):
+		return ruleErrorMissingValue
+	case strings.Contains(message, "invalid operator"):
+		return ruleErrorInvalidOperator
+	case strings.Contains(message, "error converting") || strings.Contains(message, "invalid number of values") || strings.Contains(message, "invalid boolean value"):
+		return ruleErrorInvalidValue
+	case strings.Contains(message, "is nil") || strings.Contains(message, "LeafRuleEvaluator not found"):
+		return ruleErrorInvalidRule
+	}
+	return ruleErrorOther
+}
+
+// GetScenarioStatus returns the loaded scenarios with their source, version and last match times.
+func (h *SpanFilteringHandler) GetScenarioStatus() []model.ScenarioStatus {
+	var fileScenarios map[string]*zkmodel.Scenario
+	if h.fileSource != nil {
+		fileScenarios = h.fileSource.GetAllValues()
+	}
+	scenarios := h.GetScenarios()
+	statusList := make([]model.ScenarioStatus, 0, len(scenarios))
+	for id, scenario := range scenarios {
+		if scenario == nil {
 			continue
 		}
-		if value {
-			currentTime := fmt.Sprintf("%v", time.Now().UnixNano())
-			key := currentTime + "_" + h.getRandomNumber() + "_" + id
-			h.workloadDetails.Store(key, WorkLoadTraceId{WorkLoadId: id, TraceId: traceId})
-			satisfiedWorkLoadIds = append(satisfiedWorkLoadIds, id)
+		status := model.ScenarioStatus{
+			ScenarioId: id,
+			Title:      scenario.Title,
+			Version:    scenario.Version,
+			Enabled:    scenario.Enabled,
+			Source:     model.ScenarioSourceRedis,
+			LastMatch:  h.lastMatch(id),
+			Workloads:  make([]model.WorkloadStatus, 0),
 		}
+		if fileScenario, ok := fileScenarios[id]; ok && fileScenario == scenario {
+			status.Source = model.ScenarioSourceFile
+		}
+		if scenario.Workloads != nil {
+			for workloadId, workload := range *scenario.Workloads {
+				status.Workloads = append(status.Workloads, model.WorkloadStatus{
+					WorkloadId: workloadId,
+					Service:    workload.Service,
+					Executor:   string(workload.Executor),
+					LastMatch:  h.lastMatch(workloadMatchKey{scenarioId: id, workloadId: workloadId}),
+				})
+			}
+		}
+		sort.Slice(status.Workloads, func(i, j int) bool {
+			return status.Workloads[i].WorkloadId < status.Workloads[j].WorkloadId
+		})
+		statusList = append(statusList, status)
 	}
-	if len(satisfiedWorkLoadIds) > 0 {
-		promMetrics.TotalSpansFiltered.WithLabelValues(podIp).Inc()
+	sort.Slice(statusList, func(i, j int) bool {
+		return statusList[i].ScenarioId < statusList[j].ScenarioId
+	})
+	return statusList
+}
+
+func (h *SpanFilteringHandler) lastMatch(key interface{}) int64 {
+	if value, ok := h.lastMatches.Load(key); ok {
+		return value.(int64)
 	}
-	return satisfiedWorkLoadIds
+	return 0
+}
+
+// EvaluateScenario evaluates the workloads and the group by of the scenario on the span like FilterSpans, and
+// explains the result of every rule. Nothing is written to Redis.
+func (h *SpanFilteringHandler) EvaluateScenario(scenario *zkmodel.Scenario, spanDetailsMap map[string]interface{}, target scenario.SpanTarget) model.SpanEvaluation {
+	// The evaluators keep the attribute store key of the span, so dry runs get their own.
+	ruleEvaluator := evaluator.NewRuleEvaluator(h.executorAttrStore, h.podDetailsStore)
+	result := model.SpanEvaluation{
+		ServiceName:      target.ServiceName,
+		MatchedWorkloads: make([]string, 0),
+		Workloads:        make([]model.WorkloadEvaluation, 0),
+	}
+	if scenario.Workloads == nil {
+		return result
+	}
+
+	workloadIds := make([]string, 0, len(*scenario.Workloads))
+	for id := range *scenario.Workloads {
+		workloadIds = append(workloadIds, id)
+	}
+	sort.Strings(workloadIds)
+	for _, id := range workloadIds {
+		workload := (*scenario.Workloads)[id]
+		skipReason := workloadSkipReason(workload, target)
+		workloadResult := model.WorkloadEvaluation{WorkloadId: id, SkipReason: skipReason}
+		if len(skipReason) == 0 {
+			attribKey := utils.GenerateAttribStoreKey(spanDetailsMap, workload.Protocol)
+			matched, err := h.evaluateWorkload(ruleEvaluator, workload, attribKey, spanDetailsMap)
+			workloadResult.Matched = matched && err == nil
+			if err != nil {
+				workloadResult.Error = err.Error()
+			}
+			ruleResult := explainRule(ruleEvaluator, workload.Rule, attribKey, spanDetailsMap)
+			workloadResult.Rule = &ruleResult
+		}
+		if workloadResult.Matched {
+			result.MatchedWorkloads = append(result.MatchedWorkloads, id)
+		}
+		result.Workloads = append(result.Workloads, workloadResult)
+	}
+
+	if len(result.MatchedWorkloads) > 0 {
+		groupByValues, hasData := h.processGroupBy(scenario, spanDetailsMap, result.MatchedWorkloads)
+		for _, groupByValue := range groupByValues {
+			if hasData && groupByValue != nil {
+				result.GroupBy = append(result.GroupBy, model.GroupByEvaluation{
+					WorkloadId: groupByValue.WorkloadId,
+					Title:      groupByValue.Title,
+					Hash:       groupByValue.Hash,
+				})
+			}
+		}
+	}
+	return result
+}
+
+// explainRule evaluates the rule and, for rule groups, every rule of the group.
+func explainRule(ruleEvaluator *evaluator.RuleEvaluator, rule zkmodel.Rule, attribKey cache.AttribStoreKey, spanDetailsMap map[string]interface{}) model.RuleEvaluation {
+	result := model.RuleEvaluation{Type: rule.Type}
+	passed, err := ruleEvaluator.EvalRule(rule, attribKey, spanDetailsMap)
+	result.Passed = passed && err == nil
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	if rule.Type == zkmodel.RULE_GROUP {
+		if rule.RuleGroup == nil {
+			return result
+		}
+		if rule.Condition != nil {
+			result.Condition = string(*rule.Condition)
+		}
+		for _, childRule := range rule.Rules {
+			result.Rules = append(result.Rules, explainRule(ruleEvaluator, childRule, attribKey, spanDetailsMap))
+		}
+		return result
+	}
+	if rule.RuleLeaf == nil {
+		return result
+	}
+	if rule.ID != nil {
+		result.Id = *rule.ID
+	}
+	if rule.Field != nil {
+		result.Field = *rule.Field
+	}
+	if rule.Operator != nil {
+		result.Operator = string(*rule.Operator)
+	}
+	if rule.Value != nil {
+		result.Value = string(*rule.Value)
+	}
+	return result
 }
 
 func (h *SpanFilteringHandler) syncWorkloadsToRedis() error {
@@ -207,33 +605,58 @@ func (h *SpanFilteringHandler) syncWorkloadsToRedis() error {
 		logger.Error(spanFilteringLogTag, "Error while checking redis conn ", err)
 		return err
 	}
-	var keysToDelete []string
 	h.workloadDetails.Range(func(key, value interface{}) bool {
-		keyStr := key.(string)
 		workLoadTraceId := value.(WorkLoadTraceId)
-
 		workloadId := workLoadTraceId.WorkLoadId
-		traceId := workLoadTraceId.TraceId
+		redisKey := fmt.Sprintf("%s_%d", workloadId, workLoadTraceId.BucketStart)
 
-		redisKey := workloadId + "_latest"
-		err := h.redisHandler.SAddPipeline(redisKey, traceId, time.Duration(h.Cfg.Workloads.Ttl)*time.Second)
+		expireAt := h.workloadBucketExpireAt(workLoadTraceId.BucketStart)
+		err := h.redisHandler.SAddExpireAtPipeline(redisKey, workLoadTraceId.TraceId, expireAt)
 		if err != nil {
 			logger.Error(spanFilteringLogTag, "Error while setting workload data: ", err)
 			return true
 		}
-		keysToDelete = append(keysToDelete, keyStr)
+		if err = h.setActiveBucket(workloadId, workLoadTraceId.BucketStart, expireAt); err != nil {
+			logger.Error(spanFilteringLogTag, "Error while setting active bucket of workload ", workloadId, ": ", err)
+		}
+		// The trace id may have been stored again meanwhile, and then has to be synced again.
+		h.workloadDetails.CompareAndDelete(key, value)
 		return true
 	})
-	// Delete the keys from the sync.Map after the iteration
-	for _, key := range keysToDelete {
-		h.workloadDetails.Delete(key)
-	}
 	return nil
 }
 
-func (h *SpanFilteringHandler) getRandomNumber() string {
-	randomNumber := rand.Intn(10000)
-	return fmt.Sprintf("%v", randomNumber)
+// workloadBucketStart returns the start in unix seconds of the bucket of a match. Buckets are active for
+// bucketActiveDuration, and then kept for the workload ttl.
+func (h *SpanFilteringHandler) workloadBucketStart(matchedAt time.Time) int64 {
+	return matchedAt.Unix() - matchedAt.Unix()%h.bucketDuration()
+}
+
+func (h *SpanFilteringHandler) workloadBucketExpireAt(bucketStart int64) time.Time {
+	return time.Unix(bucketStart+h.bucketDuration()+int64(h.Cfg.Workloads.Ttl), 0)
+}
+
+func (h *SpanFilteringHandler) bucketDuration() int64 {
+	if h.Cfg.Workloads.BucketActiveDuration <= 0 {
+		return defaultBucketActiveDuration
+	}
+	return int64(h.Cfg.Workloads.BucketActiveDuration)
+}
+
+// setActiveBucket points the workload to the bucket, when it is the current one and was not written yet. Matches
+// synced late do not move the pointer back, as other receivers may already point to the current bucket.
+func (h *SpanFilteringHandler) setActiveBucket(workloadId string, bucketStart int64, expireAt time.Time) error {
+	if bucketStart != h.workloadBucketStart(time.Now()) {
+		return nil
+	}
+	if activeBucket, ok := h.activeBuckets.Load(workloadId); ok && activeBucket.(int64) >= bucketStart {
+		return nil
+	}
+	if err := h.redisHandler.SetExpireAtPipeline(workloadId+common.WorkloadActiveBucketKeySuffix, bucketStart, expireAt); err != nil {
+		return err
+	}
+	h.activeBuckets.Store(workloadId, bucketStart)
+	return nil
 }
 
 func (h *SpanFilteringHandler) SyncPipeline() {
@@ -0,0 +1,121 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/zerok-ai/zk-observer/common"
+	"github.com/zerok-ai/zk-observer/config"
+	"github.com/zerok-ai/zk-observer/scenario"
+	"github.com/zerok-ai/zk-observer/utils"
+	logger "github.com/zerok-ai/zk-utils-go/logs"
+	logsConfig "github.com/zerok-ai/zk-utils-go/logs/config"
+	zkmodel "github.com/zerok-ai/zk-utils-go/scenario/model"
+	evaluator "github.com/zerok-ai/zk-utils-go/scenario/model/evaluators"
+	commonv1 "go.opentelemetry.io/proto/otlp/common/v1"
+	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// benchmarkScenarios returns scenarios of OTel workloads for 20 services, with a rule on the http route of the
+// service.
+func benchmarkScenarios(b *testing.B, scenarioCount int, workloadsPerScenario int) map[string]*zkmodel.Scenario {
+	scenarios := map[string]*zkmodel.Scenario{}
+	for s := 0; s < scenarioCount; s++ {
+		workloads := map[string]zkmodel.Workload{}
+		for w := 0; w < workloadsPerScenario; w++ {
+			i := s*workloadsPerScenario + w
+			var rule zkmodel.Rule
+			ruleJSON := fmt.Sprintf(`{"type": "rule", "id": "attributes.\"http.route\"", "datatype": "string", "operator": "equal", "value": "/orders/%d"}`, i%20)
+			if err := json.Unmarshal([]byte(ruleJSON), &rule); err != nil {
+				b.Fatalf("decoding rule: %v", err)
+			}
+			workloads[fmt.Sprintf("workload-%d", i)] = zkmodel.Workload{
+				Executor: zkmodel.ExecutorOTel,
+				Service:  fmt.Sprintf("service-%d", i%20),
+				Protocol: zkmodel.ProtocolHTTP,
+				Rule:     rule,
+			}
+		}
+		id := fmt.Sprintf("scenario-%d", s)
+		scenarios[id] = &zkmodel.Scenario{Id: id, Version: "1", Workloads: &workloads}
+	}
+	return scenarios
+}
+
+// benchmarkSpanDetails returns the span map of the handler for the span map of a server span of service-3.
+func benchmarkSpanDetails(spanMap map[string]interface{}) map[string]interface{} {
+	spanMap[common.OTelLatencyNsKey] = uint64(250000000)
+	spanMap[common.OTelSpanAttrKey] = map[string]interface{}{"http.route": "/orders/3", "http.method": "GET", "http.status_code": float64(200)}
+	spanMap[common.OTelResourceAttrKey] = map[string]interface{}{"service.name": "service-3", common.OTelResourceAttrNamespaceKey: "shop"}
+	spanMap[common.OTelScopeAttrKey] = map[string]interface{}{}
+	spanMap[common.OTelSchemaVersionKey] = common.DefaultSchemaVersion
+	spanMap[common.OTelSpanEventsKey] = nil
+	spanMap[common.OTelSpanErrorKey] = false
+	return spanMap
+}
+
+// scanWorkloads evaluates the rule of every OTel workload of the scenarios on the span, as FilterSpans did before
+// the workloads were planned.
+func (h *SpanFilteringHandler) scanWorkloads(scenarios map[string]*zkmodel.Scenario, spanDetailsMap map[string]interface{}) WorkloadIdList {
+	var satisfiedWorkLoadIds WorkloadIdList
+	for _, zkScenario := range scenarios {
+		for id, workload := range *zkScenario.Workloads {
+			if workload.Executor != zkmodel.ExecutorOTel {
+				continue
+			}
+			attribKey := utils.GenerateAttribStoreKey(spanDetailsMap, workload.Protocol)
+			if matched, err := h.ruleEvaluator.EvalRule(workload.Rule, attribKey, spanDetailsMap); err == nil && matched {
+				satisfiedWorkLoadIds = append(satisfiedWorkLoadIds, id)
+			}
+		}
+	}
+	return satisfiedWorkLoadIds
+}
+
+// BenchmarkFilterSpans compares the filtering of a span, from the span to the matched workloads, before and after
+// the workloads were planned. The Redis sync of the matches is the same for both, and is not measured.
+func BenchmarkFilterSpans(b *testing.B) {
+	logger.Init(logsConfig.LogsConfig{Level: "FATAL"})
+	scenarios := benchmarkScenarios(b, 20, 10)
+	handler := &SpanFilteringHandler{Cfg: &config.OtlpConfig{}, ruleEvaluator: *evaluator.NewRuleEvaluator(nil, nil)}
+	plan := newFilteringPlan(scenarios, nil)
+	span := &tracev1.Span{
+		TraceId:           []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		SpanId:            []byte{1, 2, 3, 4, 5, 6, 7, 8},
+		Name:              "GET /orders/{id}",
+		Kind:              tracev1.Span_SPAN_KIND_SERVER,
+		StartTimeUnixNano: 1700000000000000000,
+		EndTimeUnixNano:   1700000000250000000,
+		Attributes: []*commonv1.KeyValue{
+			{Key: "http.route", Value: &commonv1.AnyValue{Value: &commonv1.AnyValue_StringValue{StringValue: "/orders/3"}}},
+		},
+		Status: &tracev1.Status{},
+	}
+	traceId := "0102030405060708090a0b0c0d0e0f10"
+	spanDetails := benchmarkSpanDetails(utils.SpanToInterfaceMap(span))
+	target := scenario.NewSpanTarget("service-3", spanDetails[common.OTelResourceAttrKey].(map[string]interface{}), span.Kind, zkmodel.ProtocolHTTP)
+
+	// Both paths match the same workloads.
+	want := handler.scanWorkloads(scenarios, benchmarkSpanDetails(utils.ObjectToInterfaceMap(span)))
+	got, _ := handler.filterSpans(plan, traceId, spanDetails, target)
+	sort.Strings(want)
+	sort.Strings(got)
+	if len(want) == 0 || !reflect.DeepEqual(got, want) {
+		b.Fatalf("planned workloads matched %v, want %v", got, want)
+	}
+
+	b.Run("scan", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			handler.scanWorkloads(scenarios, benchmarkSpanDetails(utils.ObjectToInterfaceMap(span)))
+		}
+	})
+	b.Run("plan", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			handler.filterSpans(plan, traceId, benchmarkSpanDetails(utils.SpanToInterfaceMap(span)), target)
+		}
+	})
+}
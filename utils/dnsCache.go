@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"container/list"
+	"context"
+	"github.com/zerok-ai/zk-observer/config"
+	promMetrics "github.com/zerok-ai/zk-observer/metrics"
+	logger "github.com/zerok-ai/zk-utils-go/logs"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var dnsCacheLogTag = "DnsCache"
+
+var podIp = os.Getenv("POD_IP")
+
+const (
+	defaultDnsCacheSize   = 10000
+	defaultDnsTtl         = 60
+	defaultDnsNegativeTtl = 10
+	defaultDnsTimeoutMs   = 200
+)
+
+type dnsEntry struct {
+	host    string
+	address string
+	expiry  time.Time
+	element *list.Element
+}
+
+// dnsCall is a lookup in progress. Concurrent lookups of the same host wait for it instead of querying again.
+type dnsCall struct {
+	done    chan struct{}
+	address string
+}
+
+// DnsCache resolves peer names to IPs for the spans which carry no peer address. Results, failures included,
+// are kept in a size bounded LRU cache until their TTL expires.
+type DnsCache struct {
+	enabled     bool
+	maxSize     int
+	ttl         time.Duration
+	negativeTtl time.Duration
+	timeout     time.Duration
+	resolver    *net.Resolver
+	mutex       sync.Mutex
+	entries     map[string]*dnsEntry
+	lruOrder    *list.List
+	inFlight    map[string]*dnsCall
+}
+
+func NewDnsCache(cfg config.DnsConfig) *DnsCache {
+	if cfg.CacheSize <= 0 {
+		cfg.CacheSize = defaultDnsCacheSize
+	}
+	if cfg.Ttl <= 0 {
+		cfg.Ttl = defaultDnsTtl
+	}
+	if cfg.NegativeTtl <= 0 {
+		cfg.NegativeTtl = defaultDnsNegativeTtl
+	}
+	if cfg.TimeoutMs <= 0 {
+		cfg.TimeoutMs = defaultDnsTimeoutMs
+	}
+	return &DnsCache{
+		enabled:     cfg.Enabled == nil || *cfg.Enabled,
+		maxSize:     cfg.CacheSize,
+		ttl:         time.Duration(cfg.Ttl) * time.Second,
+		negativeTtl: time.Duration(cfg.NegativeTtl) * time.Second,
+		timeout:     time.Duration(cfg.TimeoutMs) * time.Millisecond,
+		resolver:    net.DefaultResolver,
+		entries:     map[string]*dnsEntry{},
+		lruOrder:    list.New(),
+		inFlight:    map[string]*dnsCall{},
+	}
+}
+
+// LookupHost returns the first address of the host, or false if it could not be resolved. IP literals are
+// returned as they are. A nil or disabled cache never resolves.
+func (c *DnsCache) LookupHost(host string) (string, bool) {
+	if c == nil || !c.enabled || len(host) == 0 {
+		return "", false
+	}
+	if net.ParseIP(host) != nil {
+		return host, true
+	}
+
+	c.mutex.Lock()
+	if entry, ok := c.entries[host]; ok && time.Now().Before(entry.expiry) {
+		c.lruOrder.MoveToFront(entry.element)
+		c.mutex.Unlock()
+		promMetrics.DnsCacheHits.WithLabelValues(podIp).Inc()
+		return entry.address, len(entry.address) > 0
+	}
+	promMetrics.DnsCacheMisses.WithLabelValues(podIp).Inc()
+	if call, ok := c.inFlight[host]; ok {
+		c.mutex.Unlock()
+		<-call.done
+		return call.address, len(call.address) > 0
+	}
+	call := &dnsCall{done: make(chan struct{})}
+	c.inFlight[host] = call
+	c.mutex.Unlock()
+
+	call.address = c.resolve(host)
+
+	c.mutex.Lock()
+	delete(c.inFlight, host)
+	c.store(host, call.address)
+	c.mutex.Unlock()
+	close(call.done)
+	return call.address, len(call.address) > 0
+}
+
+func (c *DnsCache) resolve(host string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	addresses, err := c.resolver.LookupHost(ctx, host)
+	if err != nil || len(addresses) == 0 {
+		logger.Debug(dnsCacheLogTag, "Could not resolve host ", host, " error: ", err)
+		return ""
+	}
+	return addresses[0]
+}
+
+// store must be called with the mutex held. An empty address is cached as a negative entry.
+func (c *DnsCache) store(host string, address string) {
+	ttl := c.ttl
+	if len(address) == 0 {
+		ttl = c.negativeTtl
+	}
+
+	if entry, ok := c.entries[host]; ok {
+		entry.address = address
+		entry.expiry = time.Now().Add(ttl)
+		c.lruOrder.MoveToFront(entry.element)
+		return
+	}
+
+	entry := &dnsEntry{host: host, address: address, expiry: time.Now().Add(ttl)}
+	entry.element = c.lruOrder.PushFront(entry)
+	c.entries[host] = entry
+	for c.lruOrder.Len() > c.maxSize {
+		oldest := c.lruOrder.Back()
+		c.lruOrder.Remove(oldest)
+		delete(c.entries, oldest.Value.(*dnsEntry).host)
+	}
+}
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"context"
+	logger "github.com/zerok-ai/zk-utils-go/logs"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sync"
+	"time"
+)
+
+var podIpLookupLogTag = "PodIpLookup"
+
+const (
+	podIpLookupTtl        = time.Minute
+	podIpLookupTimeout    = 5 * time.Second
+	maxPodIpLookupEntries = 10000
+)
+
+type podIpEntry struct {
+	ip     string
+	expiry time.Time
+}
+
+// PodIpLookup gets the IPs of pods from the API server, for the spans without a pod IP when the k8s metadata
+// cache is disabled. IPs are cached for a minute, and so are the pods which were not found.
+type PodIpLookup struct {
+	clientset kubernetes.Interface
+	mutex     sync.Mutex
+	entries   map[string]podIpEntry
+}
+
+func NewPodIpLookup() (*PodIpLookup, error) {
+	clientset, err := GetK8sClient()
+	if err != nil {
+		logger.Error(podIpLookupLogTag, "Error while creating k8s client ", err)
+		return nil, err
+	}
+	return &PodIpLookup{clientset: clientset, entries: map[string]podIpEntry{}}, nil
+}
+
+// GetPodIP returns the IP of the pod, or an empty string when it is not known. A nil lookup never finds an IP.
+func (l *PodIpLookup) GetPodIP(podName string, namespace string) string {
+	if l == nil || len(podName) == 0 || len(namespace) == 0 {
+		return ""
+	}
+	key := namespace + "/" + podName
+	l.mutex.Lock()
+	entry, ok := l.entries[key]
+	l.mutex.Unlock()
+	if ok && time.Now().Before(entry.expiry) {
+		return entry.ip
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), podIpLookupTimeout)
+	defer cancel()
+	ip := ""
+	pod, err := l.clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		logger.Debug(podIpLookupLogTag, "Could not get pod ", key, " error: ", err)
+	} else {
+		ip = pod.Status.PodIP
+	}
+
+	l.mutex.Lock()
+	if len(l.entries) >= maxPodIpLookupEntries {
+		l.entries = map[string]podIpEntry{}
+	}
+	l.entries[key] = podIpEntry{ip: ip, expiry: time.Now().Add(podIpLookupTtl)}
+	l.mutex.Unlock()
+	return ip
+}
@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"fmt"
+	"github.com/zerok-ai/zk-observer/config"
+	"github.com/zerok-ai/zk-observer/model"
+	logger "github.com/zerok-ai/zk-utils-go/logs"
+	"sort"
+	"strings"
+)
+
+var protocolDetectorLogTag = "ProtocolDetector"
+
+const (
+	ProtocolRuleSpanKindFallback        = "span_kind_fallback"
+	ProtocolRuleAttributePrefixFallback = "attribute_prefix_fallback"
+)
+
+// DefaultProtocolDetectionRules are used when no rules are configured. gRPC comes first, as gRPC over
+// HTTP/2 spans also carry HTTP attributes.
+var DefaultProtocolDetectionRules = []config.ProtocolDetectionRule{
+	{Name: "grpc", Protocol: string(model.ProtocolTypeGRPC), Priority: 40, AttributeId: string(GRPCIdentifierAttrId), Attributes: []string{"rpc.system=grpc", "rpc.grpc.status_code"}},
+	{Name: "messaging", Protocol: string(model.ProtocolTypeMessaging), Priority: 30, AttributeId: string(MessagingIdentifierAttrId), Attributes: []string{"messaging.system"}},
+	{Name: "db", Protocol: string(model.ProtocolTypeDB), Priority: 20, AttributeId: string(DBIdentifierAttrId), Attributes: []string{"db.system"}},
+	{Name: "http", Protocol: string(model.ProtocolTypeHTTP), Priority: 10, AttributeId: string(HTTPIdentifierAttrId), Attributes: []string{"http.request.method", "http.method", "http.response.status_code", "http.status_code"}},
+}
+
+// attributePrefixFallbacks are checked in order for client and server spans no rule matched.
+var attributePrefixFallbacks = []struct {
+	prefix   string
+	protocol model.ProtocolType
+}{
+	{"rpc.grpc.", model.ProtocolTypeGRPC},
+	{"messaging.", model.ProtocolTypeMessaging},
+	{"db.", model.ProtocolTypeDB},
+	{"http.", model.ProtocolTypeHTTP},
+	{"url.", model.ProtocolTypeHTTP},
+}
+
+type attributeMatcher struct {
+	key   string
+	value string
+}
+
+type protocolRule struct {
+	name        string
+	protocol    model.ProtocolType
+	priority    int
+	attributeId AttributeID
+	matchers    []attributeMatcher
+}
+
+// ProtocolDetector detects the protocol of a span with rules checked in priority order. A rule matches when its
+// attribute id resolves through the executor attribute store, or when one of its attributes is on the span.
+// Spans no rule matches fall back to span kind and attribute prefix heuristics.
+type ProtocolDetector struct {
+	rules []protocolRule
+}
+
+func NewProtocolDetector(cfg config.ProtocolDetectionConfig) (*ProtocolDetector, error) {
+	ruleConfigs := cfg.Rules
+	if len(ruleConfigs) == 0 {
+		ruleConfigs = DefaultProtocolDetectionRules
+	}
+
+	detector := ProtocolDetector{}
+	for i, ruleConfig := range ruleConfigs {
+		rule, err := newProtocolRule(ruleConfig)
+		if err != nil {
+			logger.Error(protocolDetectorLogTag, "Invalid protocol detection rule at index ", i, ": ", err)
+			return nil, err
+		}
+		detector.rules = append(detector.rules, rule)
+	}
+	// Rules with the same priority keep their configured order.
+	sort.SliceStable(detector.rules, func(i, j int) bool {
+		return detector.rules[i].priority > detector.rules[j].priority
+	})
+	return &detector, nil
+}
+
+func newProtocolRule(ruleConfig config.ProtocolDetectionRule) (protocolRule, error) {
+	rule := protocolRule{
+		name:        ruleConfig.Name,
+		protocol:    model.ProtocolType(strings.ToUpper(ruleConfig.Protocol)),
+		priority:    ruleConfig.Priority,
+		attributeId: AttributeID(ruleConfig.AttributeId),
+	}
+	switch rule.protocol {
+	case model.ProtocolTypeHTTP, model.ProtocolTypeGRPC, model.ProtocolTypeDB, model.ProtocolTypeMessaging:
+	default:
+		return rule, fmt.Errorf("unknown protocol %s", ruleConfig.Protocol)
+	}
+	if len(rule.attributeId) == 0 && len(ruleConfig.Attributes) == 0 {
+		return rule, fmt.Errorf("rule %s needs an attributeId or attributes", ruleConfig.Name)
+	}
+	if len(rule.name) == 0 {
+		rule.name = strings.ToLower(string(rule.protocol))
+	}
+
+	for _, attribute := range ruleConfig.Attributes {
+		key, value, _ := strings.Cut(attribute, "=")
+		rule.matchers = append(rule.matchers, attributeMatcher{key: key, value: value})
+	}
+	return rule, nil
+}
+
+// Detect returns the protocol of the span and the name of the rule which matched. resolveAttributeId may be
+// nil, in which case only the span attributes are checked.
+func (d *ProtocolDetector) Detect(spanKind model.SpanKind, spanAttributes map[string]interface{}, resolveAttributeId func(attrId AttributeID) bool) (model.ProtocolType, string) {
+	for _, rule := range d.rules {
+		if rule.matches(spanAttributes, resolveAttributeId) {
+			return rule.protocol, rule.name
+		}
+	}
+
+	if spanKind == model.SpanKindProducer || spanKind == model.SpanKindConsumer {
+		return model.ProtocolTypeMessaging, ProtocolRuleSpanKindFallback
+	}
+	if spanKind == model.SpanKindClient || spanKind == model.SpanKindServer {
+		for _, fallback := range attributePrefixFallbacks {
+			for key := range spanAttributes {
+				if strings.HasPrefix(key, fallback.prefix) {
+					return fallback.protocol, ProtocolRuleAttributePrefixFallback
+				}
+			}
+		}
+	}
+	return model.ProtocolTypeUnknown, ""
+}
+
+// DetectProtocolFromAttributes detects the protocol from the span attributes only.
+func (d *ProtocolDetector) DetectProtocolFromAttributes(spanKind model.SpanKind, spanAttributes map[string]interface{}) (model.ProtocolType, string) {
+	return d.Detect(spanKind, spanAttributes, nil)
+}
+
+func (r protocolRule) matches(spanAttributes map[string]interface{}, resolveAttributeId func(attrId AttributeID) bool) bool {
+	if len(r.attributeId) > 0 && resolveAttributeId != nil && resolveAttributeId(r.attributeId) {
+		return true
+	}
+	for _, matcher := range r.matchers {
+		value, ok := spanAttributes[matcher.key]
+		if !ok {
+			continue
+		}
+		if len(matcher.value) == 0 || strings.EqualFold(fmt.Sprintf("%v", value), matcher.value) {
+			return true
+		}
+	}
+	return false
+}
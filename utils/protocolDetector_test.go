@@ -0,0 +1,212 @@
+package utils
+
+import (
+	"github.com/zerok-ai/zk-observer/config"
+	"github.com/zerok-ai/zk-observer/model"
+	"testing"
+)
+
+func TestDetectProtocolFromAttributes(t *testing.T) {
+	detector, err := NewProtocolDetector(config.ProtocolDetectionConfig{})
+	if err != nil {
+		t.Fatalf("NewProtocolDetector() error = %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		kind         model.SpanKind
+		attributes   map[string]interface{}
+		wantProtocol model.ProtocolType
+		wantRule     string
+	}{
+		{
+			name:         "http and db attributes",
+			kind:         model.SpanKindClient,
+			attributes:   map[string]interface{}{"http.request.method": "GET", "db.system": "postgresql"},
+			wantProtocol: model.ProtocolTypeDB,
+			wantRule:     "db",
+		},
+		{
+			name:         "messaging with http attributes",
+			kind:         model.SpanKindProducer,
+			attributes:   map[string]interface{}{"messaging.system": "kafka", "http.method": "POST", "http.status_code": 200},
+			wantProtocol: model.ProtocolTypeMessaging,
+			wantRule:     "messaging",
+		},
+		{
+			name:         "grpc over http2",
+			kind:         model.SpanKindServer,
+			attributes:   map[string]interface{}{"rpc.system": "grpc", "http.request.method": "POST"},
+			wantProtocol: model.ProtocolTypeGRPC,
+			wantRule:     "grpc",
+		},
+		{
+			name:         "grpc status code without rpc.system",
+			kind:         model.SpanKindClient,
+			attributes:   map[string]interface{}{"rpc.grpc.status_code": 0},
+			wantProtocol: model.ProtocolTypeGRPC,
+			wantRule:     "grpc",
+		},
+		{
+			name:         "rpc.system of another framework",
+			kind:         model.SpanKindInternal,
+			attributes:   map[string]interface{}{"rpc.system": "dotnet_wcf"},
+			wantProtocol: model.ProtocolTypeUnknown,
+		},
+		{
+			name:         "old http semconv",
+			kind:         model.SpanKindServer,
+			attributes:   map[string]interface{}{"http.method": "GET"},
+			wantProtocol: model.ProtocolTypeHTTP,
+			wantRule:     "http",
+		},
+		{
+			name:         "producer without attributes",
+			kind:         model.SpanKindProducer,
+			attributes:   map[string]interface{}{},
+			wantProtocol: model.ProtocolTypeMessaging,
+			wantRule:     ProtocolRuleSpanKindFallback,
+		},
+		{
+			name:         "consumer with unrelated attributes",
+			kind:         model.SpanKindConsumer,
+			attributes:   map[string]interface{}{"thread.name": "worker-1"},
+			wantProtocol: model.ProtocolTypeMessaging,
+			wantRule:     ProtocolRuleSpanKindFallback,
+		},
+		{
+			name:         "client with db prefix only",
+			kind:         model.SpanKindClient,
+			attributes:   map[string]interface{}{"db.statement": "SELECT 1"},
+			wantProtocol: model.ProtocolTypeDB,
+			wantRule:     ProtocolRuleAttributePrefixFallback,
+		},
+		{
+			name:         "server with url prefix only",
+			kind:         model.SpanKindServer,
+			attributes:   map[string]interface{}{"url.path": "/health"},
+			wantProtocol: model.ProtocolTypeHTTP,
+			wantRule:     ProtocolRuleAttributePrefixFallback,
+		},
+		{
+			name:         "prefix fallback order",
+			kind:         model.SpanKindClient,
+			attributes:   map[string]interface{}{"url.full": "http://cart/items", "messaging.destination.name": "orders"},
+			wantProtocol: model.ProtocolTypeMessaging,
+			wantRule:     ProtocolRuleAttributePrefixFallback,
+		},
+		{
+			name:         "internal span with prefix only",
+			kind:         model.SpanKindInternal,
+			attributes:   map[string]interface{}{"db.statement": "SELECT 1"},
+			wantProtocol: model.ProtocolTypeUnknown,
+		},
+		{
+			name:         "nil attributes",
+			kind:         model.SpanKindClient,
+			wantProtocol: model.ProtocolTypeUnknown,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			protocol, rule := detector.DetectProtocolFromAttributes(test.kind, test.attributes)
+			if protocol != test.wantProtocol || rule != test.wantRule {
+				t.Errorf("DetectProtocolFromAttributes() = (%s, %q), want (%s, %q)", protocol, rule, test.wantProtocol, test.wantRule)
+			}
+		})
+	}
+}
+
+func TestDetectCustomRulePriority(t *testing.T) {
+	rules := []config.ProtocolDetectionRule{
+		{Name: "http", Protocol: "http", Priority: 10, Attributes: []string{"http.request.method"}},
+		{Name: "redis-over-http", Protocol: "db", Priority: 50, Attributes: []string{"http.route=/redis"}},
+		{Name: "queue", Protocol: "messaging", Priority: 30, Attributes: []string{"queue.name"}},
+		{Name: "queue-as-db", Protocol: "db", Priority: 30, Attributes: []string{"queue.name"}},
+		{Name: "", Protocol: "grpc", Priority: 20, AttributeId: string(GRPCIdentifierAttrId)},
+	}
+	detector, err := NewProtocolDetector(config.ProtocolDetectionConfig{Rules: rules})
+	if err != nil {
+		t.Fatalf("NewProtocolDetector() error = %v", err)
+	}
+
+	resolvesGrpc := func(attrId AttributeID) bool { return attrId == GRPCIdentifierAttrId }
+	tests := []struct {
+		name               string
+		kind               model.SpanKind
+		attributes         map[string]interface{}
+		resolveAttributeId func(attrId AttributeID) bool
+		wantProtocol       model.ProtocolType
+		wantRule           string
+	}{
+		{
+			name:         "higher priority value match wins",
+			kind:         model.SpanKindClient,
+			attributes:   map[string]interface{}{"http.request.method": "GET", "http.route": "/REDIS"},
+			wantProtocol: model.ProtocolTypeDB,
+			wantRule:     "redis-over-http",
+		},
+		{
+			name:         "value mismatch falls through",
+			kind:         model.SpanKindClient,
+			attributes:   map[string]interface{}{"http.request.method": "GET", "http.route": "/items"},
+			wantProtocol: model.ProtocolTypeHTTP,
+			wantRule:     "http",
+		},
+		{
+			name:         "equal priorities keep configured order",
+			kind:         model.SpanKindConsumer,
+			attributes:   map[string]interface{}{"queue.name": "orders"},
+			wantProtocol: model.ProtocolTypeMessaging,
+			wantRule:     "queue",
+		},
+		{
+			name:               "attribute id resolved",
+			kind:               model.SpanKindClient,
+			attributes:         map[string]interface{}{"http.request.method": "POST"},
+			resolveAttributeId: resolvesGrpc,
+			wantProtocol:       model.ProtocolTypeGRPC,
+			wantRule:           "grpc",
+		},
+		{
+			name:               "attribute id below a matching rule",
+			kind:               model.SpanKindClient,
+			attributes:         map[string]interface{}{"queue.name": "orders"},
+			resolveAttributeId: resolvesGrpc,
+			wantProtocol:       model.ProtocolTypeMessaging,
+			wantRule:           "queue",
+		},
+		{
+			name:         "attribute id without resolver",
+			kind:         model.SpanKindInternal,
+			attributes:   map[string]interface{}{"rpc.system": "grpc"},
+			wantProtocol: model.ProtocolTypeUnknown,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			protocol, rule := detector.Detect(test.kind, test.attributes, test.resolveAttributeId)
+			if protocol != test.wantProtocol || rule != test.wantRule {
+				t.Errorf("Detect() = (%s, %q), want (%s, %q)", protocol, rule, test.wantProtocol, test.wantRule)
+			}
+		})
+	}
+}
+
+func TestNewProtocolDetectorInvalidRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule config.ProtocolDetectionRule
+	}{
+		{name: "unknown protocol", rule: config.ProtocolDetectionRule{Protocol: "smtp", Attributes: []string{"smtp.host"}}},
+		{name: "no attributes", rule: config.ProtocolDetectionRule{Protocol: "http"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules := []config.ProtocolDetectionRule{test.rule}
+			if _, err := NewProtocolDetector(config.ProtocolDetectionConfig{Rules: rules}); err == nil {
+				t.Errorf("NewProtocolDetector() error = nil, want an error")
+			}
+		})
+	}
+}
@@ -1,25 +1,109 @@
 package utils
 
+import (
+	"regexp"
+	"strings"
+)
+
 // Ref: https://docs.google.com/spreadsheets/d/1E_MoV1mRL96hdTv2Q0o3pIAQ1hRF3-RQyF56kLagF04/edit#gid=1422911777
 const (
 	DBIdentifierAttrId AttributeID = "db_identifier"
 
-	DBSystemAttrId   AttributeID = "db_system"
-	DBMethodAttrId   AttributeID = ""
-	DBRouteAttrId    AttributeID = ""
-	DBSchemeAttrId   AttributeID = ""
-	DBPathAttrId     AttributeID = ""
-	DBQueryAttrId    AttributeID = ""
-	DBStatusAttrId   AttributeID = ""
-	DBUsernameAttrId AttributeID = ""
+	DBSystemAttrId        AttributeID = "db_system"
+	DBMethodAttrId        AttributeID = "db_operation"
+	DBRouteAttrId         AttributeID = "db_sql_table"
+	DBSchemeAttrId        AttributeID = "db_system"
+	DBPathAttrId          AttributeID = "db_name"
+	DBQueryAttrId         AttributeID = "db_statement"
+	DBStatusAttrId        AttributeID = ""
+	DBUsernameAttrId      AttributeID = "db_user"
+	DBServerAddressAttrId AttributeID = "server_address"
+)
+
+// dbSemconvAttributes are read from the span when the executor attribute store does not know an attribute id.
+// Newer names come last.
+var dbSemconvAttributes = map[AttributeID][]string{
+	DBSystemAttrId:        {"db.system"},
+	DBMethodAttrId:        {"db.operation", "db.operation.name"},
+	DBRouteAttrId:         {"db.sql.table", "db.mongodb.collection", "db.cassandra.table", "db.cosmosdb.container", "db.collection.name"},
+	DBPathAttrId:          {"db.name", "db.namespace"},
+	DBQueryAttrId:         {"db.statement", "db.query.text"},
+	DBUsernameAttrId:      {"db.user"},
+	DBServerAddressAttrId: {"server.address", "net.peer.name"},
+}
+
+const sanitizedPlaceholder = "?"
+
+var (
+	sqlStringLiteral  = regexp.MustCompile(`'(?:[^']|'')*'`)
+	sqlHexLiteral     = regexp.MustCompile(`\b0[xX][0-9a-fA-F]+\b`)
+	sqlNumericLiteral = regexp.MustCompile(`([^\w.$]|^)-?\d+(?:\.\d+)?(?:[eE][+-]?\d+)?\b`)
+	jsonStringValue   = regexp.MustCompile(`:\s*"(?:[^"\\]|\\.)*"`)
+	jsonNumericValue  = regexp.MustCompile(`:\s*-?\d+(?:\.\d+)?`)
+	whitespaces       = regexp.MustCompile(`\s+`)
 )
 
+// Statements of these systems are commands followed by keys and values.
+var dbCommandSystems = map[string]bool{"redis": true, "memcached": true}
+
+// Statements of these systems are JSON documents.
+var dbDocumentSystems = map[string]bool{"mongodb": true, "elasticsearch": true, "couchdb": true, "cosmosdb": true}
+
 func (s SpanProtocolUtil) AddDBSpanProperties() {
-	s.spanDetails.Method = GetSpanAttributeValue[string](DBMethodAttrId, s.spanDetailsMap, s.executorAttrStore, s.functionFactory, s.attrStoreKey)
-	s.spanDetails.Route = GetSpanAttributeValue[string](DBRouteAttrId, s.spanDetailsMap, s.executorAttrStore, s.functionFactory, s.attrStoreKey)
-	s.spanDetails.Scheme = GetSpanAttributeValue[string](DBSchemeAttrId, s.spanDetailsMap, s.executorAttrStore, s.functionFactory, s.attrStoreKey)
-	s.spanDetails.Path = GetSpanAttributeValue[string](DBPathAttrId, s.spanDetailsMap, s.executorAttrStore, s.functionFactory, s.attrStoreKey)
-	s.spanDetails.Query = GetSpanAttributeValue[string](DBQueryAttrId, s.spanDetailsMap, s.executorAttrStore, s.functionFactory, s.attrStoreKey)
+	s.spanDetails.Method = s.getStringAttributeValue(DBMethodAttrId, dbSemconvAttributes)
+	s.spanDetails.Route = s.getStringAttributeValue(DBRouteAttrId, dbSemconvAttributes)
+	s.spanDetails.Scheme = s.getStringAttributeValue(DBSchemeAttrId, dbSemconvAttributes)
+	s.spanDetails.Path = s.getStringAttributeValue(DBPathAttrId, dbSemconvAttributes)
+	s.spanDetails.Query = s.getStringAttributeValue(DBQueryAttrId, dbSemconvAttributes)
 	s.spanDetails.Status = GetSpanAttributeValue[float64](DBStatusAttrId, s.spanDetailsMap, s.executorAttrStore, s.functionFactory, s.attrStoreKey)
-	s.spanDetails.Username = GetSpanAttributeValue[string](DBUsernameAttrId, s.spanDetailsMap, s.executorAttrStore, s.functionFactory, s.attrStoreKey)
+	s.spanDetails.Username = s.getStringAttributeValue(DBUsernameAttrId, dbSemconvAttributes)
+	s.spanDetails.ServerAddress = s.getStringAttributeValue(DBServerAddressAttrId, dbSemconvAttributes)
+
+	if s.spanDetails.Query != nil {
+		dbSystem := ""
+		if s.spanDetails.Scheme != nil {
+			dbSystem = strings.ToLower(*s.spanDetails.Scheme)
+		}
+		if s.spanDetails.Method == nil {
+			s.spanDetails.Method = operationFromStatement(*s.spanDetails.Query)
+		}
+		sanitizedStatement := SanitizeDBStatement(dbSystem, *s.spanDetails.Query)
+		s.spanDetails.Query = &sanitizedStatement
+	}
+}
+
+// SanitizeDBStatement replaces the literal values of a statement with placeholders, so that it can be stored
+// and grouped without leaking data.
+func SanitizeDBStatement(dbSystem string, statement string) string {
+	statement = strings.TrimSpace(statement)
+	if dbCommandSystems[dbSystem] {
+		// Keep the command and the key.
+		parts := strings.Fields(statement)
+		for i := 2; i < len(parts); i++ {
+			parts[i] = sanitizedPlaceholder
+		}
+		return strings.Join(parts, " ")
+	}
+	if dbDocumentSystems[dbSystem] {
+		statement = jsonStringValue.ReplaceAllString(statement, `:"`+sanitizedPlaceholder+`"`)
+		return jsonNumericValue.ReplaceAllString(statement, ":"+sanitizedPlaceholder)
+	}
+
+	statement = sqlStringLiteral.ReplaceAllString(statement, sanitizedPlaceholder)
+	statement = sqlHexLiteral.ReplaceAllString(statement, sanitizedPlaceholder)
+	statement = sqlNumericLiteral.ReplaceAllString(statement, "${1}"+sanitizedPlaceholder)
+	return whitespaces.ReplaceAllString(statement, " ")
+}
+
+// operationFromStatement returns the first keyword of a statement, like SELECT or GET.
+func operationFromStatement(statement string) *string {
+	parts := strings.Fields(statement)
+	if len(parts) == 0 {
+		return nil
+	}
+	operation := strings.ToUpper(strings.Trim(parts[0], "({;"))
+	if len(operation) == 0 {
+		return nil
+	}
+	return &operation
 }
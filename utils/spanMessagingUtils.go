@@ -0,0 +1,30 @@
+package utils
+
+// Ref: https://opentelemetry.io/docs/specs/semconv/messaging/messaging-spans/
+const (
+	MessagingIdentifierAttrId AttributeID = "messaging_identifier"
+
+	MessagingMethodAttrId        AttributeID = "messaging_operation"
+	MessagingRouteAttrId         AttributeID = "messaging_destination_name"
+	MessagingSchemeAttrId        AttributeID = "messaging_system"
+	MessagingMessageIdAttrId     AttributeID = "messaging_message_id"
+	MessagingServerAddressAttrId AttributeID = "server_address"
+)
+
+// messagingSemconvAttributes are read from the span when the executor attribute store does not know an
+// attribute id. Older names come first.
+var messagingSemconvAttributes = map[AttributeID][]string{
+	MessagingMethodAttrId:        {"messaging.operation", "messaging.operation.type"},
+	MessagingRouteAttrId:         {"messaging.destination", "messaging.destination.name"},
+	MessagingSchemeAttrId:        {"messaging.system"},
+	MessagingMessageIdAttrId:     {"messaging.message_id", "messaging.message.id"},
+	MessagingServerAddressAttrId: {"server.address", "net.peer.name"},
+}
+
+func (s SpanProtocolUtil) AddMessagingSpanProperties() {
+	s.spanDetails.Method = s.getStringAttributeValue(MessagingMethodAttrId, messagingSemconvAttributes)
+	s.spanDetails.Route = s.getStringAttributeValue(MessagingRouteAttrId, messagingSemconvAttributes)
+	s.spanDetails.Scheme = s.getStringAttributeValue(MessagingSchemeAttrId, messagingSemconvAttributes)
+	s.spanDetails.MessageId = s.getStringAttributeValue(MessagingMessageIdAttrId, messagingSemconvAttributes)
+	s.spanDetails.ServerAddress = s.getStringAttributeValue(MessagingServerAddressAttrId, messagingSemconvAttributes)
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"fmt"
 	"github.com/zerok-ai/zk-observer/model"
 	"github.com/zerok-ai/zk-utils-go/scenario/model/evaluators/cache"
 	"github.com/zerok-ai/zk-utils-go/scenario/model/evaluators/functions"
@@ -9,13 +10,6 @@ import (
 
 type AttributeID string
 
-// DetectSpanProtocolMap Mapping of span attributes to protocol type.
-var DetectSpanProtocolMap = map[AttributeID]model.ProtocolType{
-	HTTPIdentifierAttrId: model.ProtocolTypeHTTP,
-	DBIdentifierAttrId:   model.ProtocolTypeDB,
-	GRPCIdentifierAttrId: model.ProtocolTypeGRPC,
-}
-
 type SpanProtocolUtil struct {
 	spanDetails       *model.OTelSpanDetails
 	spanDetailsMap    *map[string]interface{}
@@ -23,26 +17,27 @@ type SpanProtocolUtil struct {
 	podDetailsStore   *stores.LocalCacheHSetStore
 	attrStoreKey      *cache.AttribStoreKey
 	functionFactory   *functions.FunctionFactory
+	spanAttributes    map[string]interface{}
 }
 
-func NewSpanProtocolUtil(spanDetails *model.OTelSpanDetails, spanDetailsMap *map[string]interface{}, executorAttrStore *stores.ExecutorAttrStore, podDetailsStore *stores.LocalCacheHSetStore, attrStoreKey *cache.AttribStoreKey) SpanProtocolUtil {
+func NewSpanProtocolUtil(spanDetails *model.OTelSpanDetails, spanDetailsMap *map[string]interface{}, spanAttributes map[string]interface{}, executorAttrStore *stores.ExecutorAttrStore, podDetailsStore *stores.LocalCacheHSetStore, attrStoreKey *cache.AttribStoreKey) SpanProtocolUtil {
 	ff := functions.NewFunctionFactory(podDetailsStore, executorAttrStore)
 	return SpanProtocolUtil{
 		spanDetails:       spanDetails,
 		spanDetailsMap:    spanDetailsMap,
+		spanAttributes:    spanAttributes,
 		executorAttrStore: executorAttrStore,
 		functionFactory:   ff,
 		attrStoreKey:      attrStoreKey,
 	}
 }
 
-func (s SpanProtocolUtil) DetectSpanProtocol() model.ProtocolType {
-	for attributeId, protocol := range DetectSpanProtocolMap {
-		if val, ok := s.functionFactory.EvaluateString(string(attributeId), *s.spanDetailsMap, s.attrStoreKey); ok && val != nil {
-			return protocol
-		}
-	}
-	return model.ProtocolTypeUnknown
+// DetectSpanProtocol returns the protocol of the span and the name of the detection rule which matched.
+func (s SpanProtocolUtil) DetectSpanProtocol(detector *ProtocolDetector) (model.ProtocolType, string) {
+	return detector.Detect(s.spanDetails.SpanKind, s.spanAttributes, func(attrId AttributeID) bool {
+		val, ok := s.functionFactory.EvaluateString(string(attrId), *s.spanDetailsMap, s.attrStoreKey)
+		return ok && val != nil
+	})
 }
 
 func (s SpanProtocolUtil) AddSpanProtocolProperties() {
@@ -51,7 +46,23 @@ func (s SpanProtocolUtil) AddSpanProtocolProperties() {
 	} else if s.spanDetails.Protocol == model.ProtocolTypeGRPC {
 		s.AddGRPCSpanProperties()
 	} else if s.spanDetails.Protocol == model.ProtocolTypeDB {
-		/* TODO: Implement following method with DB attributes. */
-		// s.AddDBSpanProperties()
+		s.AddDBSpanProperties()
+	} else if s.spanDetails.Protocol == model.ProtocolTypeMessaging {
+		s.AddMessagingSpanProperties()
+	}
+}
+
+// getStringAttributeValue resolves the attribute id through the executor attribute store, and falls back to
+// the semantic convention attributes of the span when the store has no entry for it.
+func (s SpanProtocolUtil) getStringAttributeValue(attrId AttributeID, semconvAttributes map[AttributeID][]string) *string {
+	if value := GetSpanAttributeValue[string](attrId, s.spanDetailsMap, s.executorAttrStore, s.functionFactory, s.attrStoreKey); value != nil {
+		return value
+	}
+	for _, key := range semconvAttributes[attrId] {
+		if value, ok := s.spanAttributes[key]; ok && value != nil {
+			valueStr := fmt.Sprintf("%v", value)
+			return &valueStr
+		}
 	}
+	return nil
 }
@@ -1,8 +1,8 @@
 package utils
 
 import (
-	"context"
 	"crypto/md5"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -15,11 +15,10 @@ import (
 	zkmodel "github.com/zerok-ai/zk-utils-go/scenario/model"
 	commonv1 "go.opentelemetry.io/proto/otlp/common/v1"
 	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	"net"
+	"net/netip"
 	"os"
 	"sort"
 	"strings"
@@ -32,82 +31,100 @@ var NET_PEER_NAME = "net.peer.name"
 var NET_HOST_IP = "net.host.ip"
 var NET_PEER_IP = "net.peer.ip"
 var SERVER_SOCKET_ADDRESS = "server.socket.address"
+var CLIENT_SOCKET_ADDRESS = "client.socket.address"
+var NETWORK_PEER_ADDRESS = "network.peer.address"
+var NETWORK_LOCAL_ADDRESS = "network.local.address"
+var SERVER_ADDRESS = "server.address"
+var CLIENT_ADDRESS = "client.address"
+
+// Attributes holding the addresses of the connection, by role and by span kind, in order of preference. The
+// peer and local addresses are the server and the client on client spans, and the other way around on server
+// spans. Current semantic convention names come before the older ones. client.address is not a source IP, as it
+// can be a host name or the end client address forwarded by proxies.
+var (
+	clientSpanDestIPKeys   = []string{NETWORK_PEER_ADDRESS, NET_SOCK_PEER_ADDR, SERVER_SOCKET_ADDRESS}
+	clientSpanDestHostKeys = []string{SERVER_ADDRESS, NET_PEER_NAME}
+	serverSpanDestIPKeys   = []string{NETWORK_LOCAL_ADDRESS, NET_SOCK_HOST_ADDR, SERVER_SOCKET_ADDRESS, NET_HOST_IP}
+	serverSpanSourceIPKeys = []string{NETWORK_PEER_ADDRESS, CLIENT_SOCKET_ADDRESS, NET_SOCK_PEER_ADDR, NET_PEER_IP}
+)
+
+// Executor protocols which zk-utils-go has no names for yet.
+const (
+	ExecutorProtocolDB        zkmodel.ProtocolName = "DB"
+	ExecutorProtocolMessaging zkmodel.ProtocolName = "MESSAGING"
+)
 
 const (
 	ScopeAttributeHashPrefix    = "sh_" // sh stands for scope hash
 	ResourceAttributeHashPrefix = "rh_" // rh stands for resource hash
 )
 
-func GetSourceDestIPPair(spanKind model.SpanKind, attributes map[string]interface{}, resourceAttrMap map[string]interface{}) (string, string) {
+// GetSourceDestIPPair returns the source and destination IPs of a span. The source IP of client spans is the pod IP
+// of the resource, or the one of the pod name and namespace from podIpLookup when it is not set.
+func GetSourceDestIPPair(spanKind model.SpanKind, attributes map[string]interface{}, resourceAttrMap map[string]interface{}, dnsCache *DnsCache, podIpLookup *PodIpLookup) (string, string) {
 	destIP := ""
 	sourceIP := ""
 
 	if spanKind == model.SpanKindClient {
 		if len(attributes) > 0 {
-			if peerAddr, ok := attributes[NET_SOCK_PEER_ADDR]; ok {
-				destIP = peerAddr.(string)
-			} else if peerName, ok := attributes[NET_PEER_NAME]; ok {
-				address, err := net.LookupHost(peerName.(string))
-				if err == nil && len(address) > 0 {
-					destIP = address[0]
+			destIP = firstAttributeValue(attributes, clientSpanDestIPKeys)
+			if len(destIP) == 0 {
+				if host := firstAttributeValue(attributes, clientSpanDestHostKeys); len(host) > 0 {
+					if address, resolved := dnsCache.LookupHost(host); resolved {
+						destIP = address
+					} else if _, err := netip.ParseAddr(host); err == nil {
+						destIP = host
+					}
 				}
 			}
 		}
-		podName, ok1 := resourceAttrMap["k8s.pod.name"]
-		namespace, ok2 := resourceAttrMap["k8s.namespace.name"]
-		if ok1 && ok2 {
-			podNameStr, ok1 := podName.(string)
-			namespaceStr, ok2 := namespace.(string)
-			if ok1 && ok2 {
-				clientIp, err := GetPodIP(podNameStr, namespaceStr)
-				if err == nil {
-					sourceIP = clientIp
-				}
-			}
+		// The pod IP is set by the SDK or by the k8s metadata enrichment.
+		if podIp, ok := resourceAttrMap[common.OTelResourceAttrPodIpKey].(string); ok && len(podIp) > 0 {
+			sourceIP = podIp
+		} else {
+			podName, _ := resourceAttrMap[common.OTelResourceAttrPodNameKey].(string)
+			namespace, _ := resourceAttrMap[common.OTelResourceAttrNamespaceKey].(string)
+			sourceIP = podIpLookup.GetPodIP(podName, namespace)
 		}
 
 	} else if spanKind == model.SpanKindServer {
 		if len(attributes) > 0 {
-			if hostAddr, ok := attributes[NET_SOCK_HOST_ADDR]; ok {
-				destIP = hostAddr.(string)
-			} else if hostAddr, ok := attributes[SERVER_SOCKET_ADDRESS]; ok {
-				destIP = hostAddr.(string)
-			} else if hostAddr, ok := attributes[NET_HOST_IP]; ok {
-				destIP = hostAddr.(string)
-			}
-
-			if peerAddr, ok := attributes[NET_SOCK_PEER_ADDR]; ok {
-				sourceIP = peerAddr.(string)
-			} else if peerAddr, ok := attributes[NET_PEER_IP]; ok {
-				sourceIP = peerAddr.(string)
-			}
+			destIP = firstAttributeValue(attributes, serverSpanDestIPKeys)
+			sourceIP = firstAttributeValue(attributes, serverSpanSourceIPKeys)
 		}
 	}
 
 	if len(destIP) > 0 {
-		destIP = ConvertToIpv4(destIP)
+		destIP = NormalizeIP(destIP)
 	}
 
 	if len(sourceIP) > 0 {
-		sourceIP = ConvertToIpv4(sourceIP)
+		sourceIP = NormalizeIP(sourceIP)
 	}
 
 	return sourceIP, destIP
 }
 
-func ConvertToIpv4(ipStr string) string {
-	ip := net.ParseIP(ipStr)
+// firstAttributeValue returns the first non-empty string value of the keys. Values of other types are skipped.
+func firstAttributeValue(attributes map[string]interface{}, keys []string) string {
+	for _, key := range keys {
+		if value, ok := attributes[key].(string); ok && len(value) > 0 {
+			return value
+		}
+	}
+	return ""
+}
 
-	if ip == nil {
+// NormalizeIP returns the canonical form of an IPv4 or IPv6 address, which is also the form used in the
+// pod details keys. IPv4-mapped IPv6 addresses are returned as IPv4, and brackets and zones are dropped.
+func NormalizeIP(ipStr string) string {
+	ipStr = strings.TrimSuffix(strings.TrimPrefix(ipStr, "["), "]")
+	ip, err := netip.ParseAddr(ipStr)
+	if err != nil {
 		logger.Error(spanUtilsLogTag, "Invalid IP address ", ipStr)
 		return ""
 	}
-
-	if ip.To4() != nil {
-		ipv4 := ip.String()
-		return ipv4
-	}
-	return ""
+	return ip.Unmap().WithZone("").String()
 }
 
 func ConvertKVListToMap(attr []*commonv1.KeyValue) map[string]interface{} {
@@ -130,20 +147,6 @@ func GetSpanKind(kind tracev1.Span_SpanKind) model.SpanKind {
 	return model.SpanKindInternal
 }
 
-func GetPodIP(podName string, namespace string) (string, error) {
-	clientset, err := GetK8sClient()
-	if err != nil {
-		return "", err
-	}
-
-	pod, err := clientset.CoreV1().Pods(namespace).Get(context.TODO(), podName, metav1.GetOptions{})
-	if err != nil {
-		return "", err
-	}
-
-	return pod.Status.PodIP, nil
-}
-
 func GetK8sClient() (*kubernetes.Clientset, error) {
 	config, err := rest.InClusterConfig()
 	if err != nil {
@@ -180,6 +183,10 @@ func GetExecutorProtocolFromSpanProtocol(spanProtocol model.ProtocolType) zkmode
 		return zkmodel.ProtocolHTTP
 	} else if spanProtocol == model.ProtocolTypeGRPC {
 		return zkmodel.ProtocolGRPC
+	} else if spanProtocol == model.ProtocolTypeDB {
+		return ExecutorProtocolDB
+	} else if spanProtocol == model.ProtocolTypeMessaging {
+		return ExecutorProtocolMessaging
 	}
 	return zkmodel.ProtocolGeneral
 }
@@ -199,6 +206,48 @@ func ObjectToInterfaceMap(spanDetails any) map[string]interface{} {
 	return spanDetailMap
 }
 
+// SpanToInterfaceMap returns the fields of the span as ObjectToInterfaceMap does, without the json round trip.
+// Attributes, events and links are left out, as the processed ones are added to the span view.
+func SpanToInterfaceMap(span *tracev1.Span) map[string]interface{} {
+	spanMap := make(map[string]interface{}, 16)
+	putBytes := func(key string, value []byte) {
+		if len(value) > 0 {
+			spanMap[key] = base64.StdEncoding.EncodeToString(value)
+		}
+	}
+	putNumber := func(key string, value uint64) {
+		if value != 0 {
+			spanMap[key] = float64(value)
+		}
+	}
+	putBytes("trace_id", span.TraceId)
+	putBytes("span_id", span.SpanId)
+	putBytes("parent_span_id", span.ParentSpanId)
+	if len(span.TraceState) > 0 {
+		spanMap["trace_state"] = span.TraceState
+	}
+	if len(span.Name) > 0 {
+		spanMap["name"] = span.Name
+	}
+	putNumber("kind", uint64(span.Kind))
+	putNumber("start_time_unix_nano", span.StartTimeUnixNano)
+	putNumber("end_time_unix_nano", span.EndTimeUnixNano)
+	putNumber("dropped_attributes_count", uint64(span.DroppedAttributesCount))
+	putNumber("dropped_events_count", uint64(span.DroppedEventsCount))
+	putNumber("dropped_links_count", uint64(span.DroppedLinksCount))
+	if span.Status != nil {
+		status := map[string]interface{}{}
+		if len(span.Status.Message) > 0 {
+			status["message"] = span.Status.Message
+		}
+		if span.Status.Code != 0 {
+			status["code"] = float64(span.Status.Code)
+		}
+		spanMap["status"] = status
+	}
+	return spanMap
+}
+
 func GetResourceIp(spanKind model.SpanKind, sourceIp string, destIp string) string {
 	if spanKind == model.SpanKindClient && len(sourceIp) > 0 {
 		return sourceIp
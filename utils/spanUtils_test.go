@@ -0,0 +1,202 @@
+package utils
+
+import (
+	"fmt"
+	"github.com/zerok-ai/zk-observer/common"
+	"github.com/zerok-ai/zk-observer/model"
+	commonv1 "go.opentelemetry.io/proto/otlp/common/v1"
+	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeIP(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		want string
+	}{
+		{name: "ipv4", ip: "10.1.2.3", want: "10.1.2.3"},
+		{name: "ipv6", ip: "2001:db8::1", want: "2001:db8::1"},
+		{name: "ipv6 expanded", ip: "2001:0DB8:0000:0000:0000:0000:0000:0001", want: "2001:db8::1"},
+		{name: "ipv6 loopback", ip: "::1", want: "::1"},
+		{name: "ipv6 bracketed", ip: "[fd00::a]", want: "fd00::a"},
+		{name: "ipv6 with zone", ip: "fe80::1%eth0", want: "fe80::1"},
+		{name: "ipv4-mapped ipv6", ip: "::ffff:10.1.2.3", want: "10.1.2.3"},
+		{name: "ipv4-mapped ipv6 in hex", ip: "::ffff:a01:203", want: "10.1.2.3"},
+		{name: "host name", ip: "cart.shop.svc", want: ""},
+		{name: "empty", ip: "", want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := NormalizeIP(test.ip); got != test.want {
+				t.Errorf("NormalizeIP(%q) = %q, want %q", test.ip, got, test.want)
+			}
+		})
+	}
+}
+
+func TestGetSourceDestIPPair(t *testing.T) {
+	tests := []struct {
+		name       string
+		kind       model.SpanKind
+		attributes map[string]interface{}
+		resource   map[string]interface{}
+		wantSource string
+		wantDest   string
+	}{
+		{
+			name:       "client ipv4",
+			kind:       model.SpanKindClient,
+			attributes: map[string]interface{}{NET_SOCK_PEER_ADDR: "10.0.0.2"},
+			resource:   map[string]interface{}{common.OTelResourceAttrPodIpKey: "10.0.0.1"},
+			wantSource: "10.0.0.1",
+			wantDest:   "10.0.0.2",
+		},
+		{
+			name:       "client ipv6",
+			kind:       model.SpanKindClient,
+			attributes: map[string]interface{}{NETWORK_PEER_ADDRESS: "fd00::2"},
+			resource:   map[string]interface{}{common.OTelResourceAttrPodIpKey: "fd00::1"},
+			wantSource: "fd00::1",
+			wantDest:   "fd00::2",
+		},
+		{
+			name:       "client ipv4-mapped",
+			kind:       model.SpanKindClient,
+			attributes: map[string]interface{}{NET_SOCK_PEER_ADDR: "::ffff:10.0.0.2"},
+			resource:   map[string]interface{}{common.OTelResourceAttrPodIpKey: "::ffff:10.0.0.1"},
+			wantSource: "10.0.0.1",
+			wantDest:   "10.0.0.2",
+		},
+		{
+			name:       "client ip literal server address",
+			kind:       model.SpanKindClient,
+			attributes: map[string]interface{}{SERVER_ADDRESS: "fd00::2"},
+			wantDest:   "fd00::2",
+		},
+		{
+			name:       "server ipv4",
+			kind:       model.SpanKindServer,
+			attributes: map[string]interface{}{NET_SOCK_HOST_ADDR: "10.0.0.2", NET_SOCK_PEER_ADDR: "10.0.0.1"},
+			wantSource: "10.0.0.1",
+			wantDest:   "10.0.0.2",
+		},
+		{
+			name:       "server ipv6",
+			kind:       model.SpanKindServer,
+			attributes: map[string]interface{}{NETWORK_LOCAL_ADDRESS: "[fd00::2]", NETWORK_PEER_ADDRESS: "fd00:0:0:0:0:0:0:1"},
+			wantSource: "fd00::1",
+			wantDest:   "fd00::2",
+		},
+		{
+			name:       "server ipv4-mapped",
+			kind:       model.SpanKindServer,
+			attributes: map[string]interface{}{NETWORK_LOCAL_ADDRESS: "::ffff:10.0.0.2", NETWORK_PEER_ADDRESS: "::ffff:10.0.0.1"},
+			wantSource: "10.0.0.1",
+			wantDest:   "10.0.0.2",
+		},
+		{
+			name:       "server client address is not the source",
+			kind:       model.SpanKindServer,
+			attributes: map[string]interface{}{NETWORK_LOCAL_ADDRESS: "10.0.0.2", CLIENT_ADDRESS: "203.0.113.7"},
+			wantDest:   "10.0.0.2",
+		},
+		{
+			name:       "non-string values are skipped",
+			kind:       model.SpanKindServer,
+			attributes: map[string]interface{}{NET_SOCK_HOST_ADDR: []interface{}{"10.0.0.9"}, NET_HOST_IP: "10.0.0.2", NET_SOCK_PEER_ADDR: int64(1), NET_PEER_IP: "10.0.0.1"},
+			wantSource: "10.0.0.1",
+			wantDest:   "10.0.0.2",
+		},
+		{
+			name:       "invalid addresses",
+			kind:       model.SpanKindClient,
+			attributes: map[string]interface{}{NET_SOCK_PEER_ADDR: "not-an-ip"},
+			resource:   map[string]interface{}{common.OTelResourceAttrPodIpKey: "10.0.0.300"},
+		},
+		{
+			name:       "internal spans have no addresses",
+			kind:       model.SpanKindInternal,
+			attributes: map[string]interface{}{NET_SOCK_PEER_ADDR: "10.0.0.2"},
+			resource:   map[string]interface{}{common.OTelResourceAttrPodIpKey: "10.0.0.1"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source, dest := GetSourceDestIPPair(test.kind, test.attributes, test.resource, nil, nil)
+			if source != test.wantSource || dest != test.wantDest {
+				t.Errorf("GetSourceDestIPPair() = %q, %q, want %q, %q", source, dest, test.wantSource, test.wantDest)
+			}
+		})
+	}
+}
+
+// benchmarkSpan returns a server span with 20 attributes and an exception event.
+func benchmarkSpan() *tracev1.Span {
+	span := &tracev1.Span{
+		TraceId:           []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		SpanId:            []byte{1, 2, 3, 4, 5, 6, 7, 8},
+		ParentSpanId:      []byte{8, 7, 6, 5, 4, 3, 2, 1},
+		TraceState:        "vendor=value",
+		Name:              "GET /orders/{id}",
+		Kind:              tracev1.Span_SPAN_KIND_SERVER,
+		StartTimeUnixNano: 1700000000000000000,
+		EndTimeUnixNano:   1700000000250000000,
+		DroppedLinksCount: 2,
+		Status:            &tracev1.Status{Code: tracev1.Status_STATUS_CODE_ERROR, Message: "internal error"},
+		Events: []*tracev1.Span_Event{{
+			Name:         "exception",
+			TimeUnixNano: 1700000000200000000,
+			Attributes: []*commonv1.KeyValue{
+				{Key: "exception.type", Value: &commonv1.AnyValue{Value: &commonv1.AnyValue_StringValue{StringValue: "IllegalStateException"}}},
+			},
+		}},
+	}
+	for i := 0; i < 20; i++ {
+		span.Attributes = append(span.Attributes, &commonv1.KeyValue{
+			Key:   fmt.Sprintf("attribute.%d", i),
+			Value: &commonv1.AnyValue{Value: &commonv1.AnyValue_StringValue{StringValue: fmt.Sprintf("value-%d", i)}},
+		})
+	}
+	return span
+}
+
+func TestSpanToInterfaceMap(t *testing.T) {
+	tests := []struct {
+		name string
+		span *tracev1.Span
+	}{
+		{name: "all fields", span: benchmarkSpan()},
+		{name: "empty status", span: &tracev1.Span{Name: "span", Status: &tracev1.Status{}}},
+		{name: "empty span", span: &tracev1.Span{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			want := ObjectToInterfaceMap(test.span)
+			// The processed attributes and events replace the ones of the span in the span view.
+			delete(want, "attributes")
+			delete(want, "events")
+			delete(want, "links")
+			if got := SpanToInterfaceMap(test.span); !reflect.DeepEqual(got, want) {
+				t.Errorf("SpanToInterfaceMap() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func BenchmarkSpanToInterfaceMap(b *testing.B) {
+	span := benchmarkSpan()
+	b.Run("object_to_interface_map", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			ObjectToInterfaceMap(span)
+		}
+	})
+	b.Run("span_to_interface_map", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			SpanToInterfaceMap(span)
+		}
+	})
+}